@@ -0,0 +1,335 @@
+// Package ast declares the types used to represent the syntax tree of a mathxf template.
+//
+// The tree is built from a compiled template by mathxf.Program.AST, it is a read-only
+// snapshot: changing it never changes the compiled program.
+package ast
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Pos is a position in the original template source.
+type Pos struct {
+	Offset int // byte offset, starting at 0
+	Line   int // line number, starting at 1
+	Column int // byte column, starting at 1
+}
+
+// IsValid reports whether the position is known.
+func (p Pos) IsValid() bool {
+	return p.Line > 0
+}
+
+func (p Pos) String() string {
+	if !p.IsValid() {
+		return "-"
+	}
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// Node is implemented by all nodes of the tree.
+// Pos is the position of the first byte of the node, End the position right after it.
+type Node interface {
+	Pos() Pos
+	End() Pos
+}
+
+// Stmt is implemented by all statement nodes.
+type Stmt interface {
+	Node
+	stmtNode()
+}
+
+// Expr is implemented by all expression nodes.
+type Expr interface {
+	Node
+	exprNode()
+}
+
+// ----------------------------------------------------------------------------
+// Statements
+
+type (
+	// File is the root of a template.
+	File struct {
+		Stmts    []Stmt
+		StartPos Pos
+		EndPos   Pos
+	}
+
+	// ExprStmt is a bare expression, its value is written to the default result key under Name, e.g. res1.
+	ExprStmt struct {
+		X    Expr
+		Name string
+	}
+
+	// AssignStmt is Target = Value.
+	AssignStmt struct {
+		Target *Var
+		Value  Expr
+	}
+
+	// ValStmt is val a, b = Value. Value is nil when the names are declared without a value.
+	ValStmt struct {
+		Val   Pos // position of the val keyword
+		Names []*Ident
+		Value Expr
+	}
+
+	// IfStmt is if Cond { Body } else Else. Else is nil, a *BlockStmt or an *IfStmt for else if.
+	IfStmt struct {
+		If   Pos // position of the if keyword
+		Cond Expr
+		Body *BlockStmt
+		Else Stmt
+	}
+
+	// ForStmt is for Key in X { Body } or for Key, Value in X { Body }. Value is nil for a single loop variable.
+	ForStmt struct {
+		For   Pos // position of the for keyword
+		Key   *Ident
+		Value *Ident
+		X     Expr
+		Body  *BlockStmt
+	}
+
+	// FuncStmt is func Name(Params) { Body }.
+	FuncStmt struct {
+		Func   Pos // position of the func keyword
+		Name   *Ident
+		Params []*Ident
+		Body   *BlockStmt
+	}
+
+	// ReturnStmt is return or return Result. Result is nil for a bare return.
+	ReturnStmt struct {
+		Return    Pos // position of the return keyword
+		ReturnEnd Pos // position right after the return keyword
+		Result    Expr
+	}
+
+	// BranchStmt is break or continue.
+	BranchStmt struct {
+		TokPos Pos
+		TokEnd Pos
+		Tok    string // "break" or "continue"
+	}
+
+	// BlockStmt is a braced statement list.
+	BlockStmt struct {
+		Lbrace Pos
+		Stmts  []Stmt
+		Rbrace Pos // position of the closing '}'
+	}
+
+	// CustomStmt is a tag registered by RegisterTag or WithTag. Impl is the node returned by its TagParser.
+	CustomStmt struct {
+		Name   *Ident
+		Impl   any
+		EndPos Pos
+	}
+)
+
+// ----------------------------------------------------------------------------
+// Expressions
+
+// LitKind is the kind of a BasicLit.
+type LitKind int
+
+const (
+	Number LitKind = iota
+	String
+	Bool
+	Char
+	Money    // an amount and a currency code, e.g. 100.00 CNY
+	Duration // numbers with units, e.g. 3d or 1h30m
+)
+
+func (k LitKind) String() string {
+	switch k {
+	case Number:
+		return "Number"
+	case String:
+		return "String"
+	case Bool:
+		return "Bool"
+	case Char:
+		return "Char"
+	case Money:
+		return "Money"
+	case Duration:
+		return "Duration"
+	}
+	return fmt.Sprintf("LitKind(%d)", int(k))
+}
+
+type (
+	// Ident is a name: a variable, a loop variable, a function or a parameter.
+	Ident struct {
+		NamePos Pos
+		NameEnd Pos // names replaced by WithReplaceStrMap may differ in length from the source
+		Name    string
+	}
+
+	// BasicLit is a number, string, bool, char, money or duration literal. Value is the literal as written, strings without quotes.
+	BasicLit struct {
+		ValuePos Pos
+		ValueEnd Pos
+		Kind     LitKind
+		Value    string
+	}
+
+	// ArrayLit is [Elems].
+	ArrayLit struct {
+		Lbrack Pos
+		Elems  []Expr
+		Rbrack Pos // position of the closing ']'
+	}
+
+	// Var is a variable path such as user.level, items[0].price or max(a, b).
+	Var struct {
+		Elems  []*VarElem
+		EndPos Pos
+	}
+
+	// VarElem is one element of a variable path. It is a name (Name is set), a subscript (Index is set),
+	// and a name may be called as a function (Call is true).
+	VarElem struct {
+		ElemPos Pos // position of the name or of '['
+		Name    string
+		Index   Expr
+		Call    bool
+		Args    []Expr
+	}
+
+	// BinaryExpr is X Op Y. Op is the operator as written, e.g. "+", ">=", "and", "&&", "in".
+	BinaryExpr struct {
+		X     Expr
+		OpPos Pos
+		Op    string
+		Y     Expr
+	}
+
+	// UnaryExpr is Op X, Op is "-", "+", "!" or "not".
+	UnaryExpr struct {
+		OpPos Pos
+		Op    string
+		X     Expr
+	}
+
+	// CondExpr is Cond ? X : Y.
+	CondExpr struct {
+		Cond Expr
+		X    Expr
+		Y    Expr
+	}
+)
+
+// String returns the path of v, subscripts are written as [...] and calls as (...),
+// e.g. items[...].price or max(...).
+func (v *Var) String() string {
+	var sb strings.Builder
+	for i, e := range v.Elems {
+		if e.Index != nil {
+			sb.WriteString("[...]")
+		} else {
+			if i > 0 {
+				sb.WriteByte('.')
+			}
+			sb.WriteString(e.Name)
+		}
+		if e.Call {
+			sb.WriteString("(...)")
+		}
+	}
+	return sb.String()
+}
+
+// Root returns the first name of the path.
+func (v *Var) Root() string {
+	return v.Elems[0].Name
+}
+
+// ----------------------------------------------------------------------------
+// Positions
+
+func (s *File) Pos() Pos       { return s.StartPos }
+func (s *ExprStmt) Pos() Pos   { return s.X.Pos() }
+func (s *AssignStmt) Pos() Pos { return s.Target.Pos() }
+func (s *ValStmt) Pos() Pos    { return s.Val }
+func (s *IfStmt) Pos() Pos     { return s.If }
+func (s *ForStmt) Pos() Pos    { return s.For }
+func (s *FuncStmt) Pos() Pos   { return s.Func }
+func (s *ReturnStmt) Pos() Pos { return s.Return }
+func (s *BranchStmt) Pos() Pos { return s.TokPos }
+func (s *BlockStmt) Pos() Pos  { return s.Lbrace }
+func (s *CustomStmt) Pos() Pos { return s.Name.Pos() }
+
+func (s *File) End() Pos       { return s.EndPos }
+func (s *ExprStmt) End() Pos   { return s.X.End() }
+func (s *AssignStmt) End() Pos { return s.Value.End() }
+func (s *ValStmt) End() Pos {
+	if s.Value != nil {
+		return s.Value.End()
+	}
+	return s.Names[len(s.Names)-1].End()
+}
+func (s *IfStmt) End() Pos {
+	if s.Else != nil {
+		return s.Else.End()
+	}
+	return s.Body.End()
+}
+func (s *ForStmt) End() Pos  { return s.Body.End() }
+func (s *FuncStmt) End() Pos { return s.Body.End() }
+func (s *ReturnStmt) End() Pos {
+	if s.Result != nil {
+		return s.Result.End()
+	}
+	return s.ReturnEnd
+}
+func (s *BranchStmt) End() Pos { return s.TokEnd }
+func (s *BlockStmt) End() Pos {
+	return Pos{Offset: s.Rbrace.Offset + 1, Line: s.Rbrace.Line, Column: s.Rbrace.Column + 1}
+}
+func (s *CustomStmt) End() Pos { return s.EndPos }
+
+func (x *Ident) Pos() Pos      { return x.NamePos }
+func (x *BasicLit) Pos() Pos   { return x.ValuePos }
+func (x *ArrayLit) Pos() Pos   { return x.Lbrack }
+func (x *Var) Pos() Pos        { return x.Elems[0].ElemPos }
+func (x *BinaryExpr) Pos() Pos { return x.X.Pos() }
+func (x *UnaryExpr) Pos() Pos  { return x.OpPos }
+func (x *CondExpr) Pos() Pos   { return x.Cond.Pos() }
+
+func (x *Ident) End() Pos    { return x.NameEnd }
+func (x *BasicLit) End() Pos { return x.ValueEnd }
+func (x *ArrayLit) End() Pos {
+	return Pos{Offset: x.Rbrack.Offset + 1, Line: x.Rbrack.Line, Column: x.Rbrack.Column + 1}
+}
+func (x *Var) End() Pos        { return x.EndPos }
+func (x *BinaryExpr) End() Pos { return x.Y.End() }
+func (x *UnaryExpr) End() Pos  { return x.X.End() }
+func (x *CondExpr) End() Pos   { return x.Y.End() }
+
+// stmtNode() ensures that only statement nodes can be assigned to a Stmt.
+func (*ExprStmt) stmtNode()   {}
+func (*AssignStmt) stmtNode() {}
+func (*ValStmt) stmtNode()    {}
+func (*IfStmt) stmtNode()     {}
+func (*ForStmt) stmtNode()    {}
+func (*FuncStmt) stmtNode()   {}
+func (*ReturnStmt) stmtNode() {}
+func (*BranchStmt) stmtNode() {}
+func (*BlockStmt) stmtNode()  {}
+func (*CustomStmt) stmtNode() {}
+
+// exprNode() ensures that only expression nodes can be assigned to an Expr.
+func (*Ident) exprNode()      {}
+func (*BasicLit) exprNode()   {}
+func (*ArrayLit) exprNode()   {}
+func (*Var) exprNode()        {}
+func (*BinaryExpr) exprNode() {}
+func (*UnaryExpr) exprNode()  {}
+func (*CondExpr) exprNode()   {}
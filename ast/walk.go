@@ -0,0 +1,117 @@
+package ast
+
+import "fmt"
+
+// A Visitor's Visit method is invoked for each node encountered by Walk.
+// If the result visitor w is not nil, Walk visits each of the children
+// of node with the visitor w, followed by a call of w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+func walkStmts(v Visitor, list []Stmt) {
+	for _, s := range list {
+		Walk(v, s)
+	}
+}
+
+func walkExprs(v Visitor, list []Expr) {
+	for _, x := range list {
+		Walk(v, x)
+	}
+}
+
+// Walk traverses the tree in depth-first order: it starts by calling v.Visit(node);
+// a nil node is skipped. If the visitor w returned by v.Visit(node) is not nil,
+// Walk is invoked recursively with visitor w for each of the non-nil children
+// of node, followed by a call of w.Visit(nil).
+func Walk(v Visitor, node Node) {
+	if node == nil {
+		return
+	}
+	if v = v.Visit(node); v == nil {
+		return
+	}
+	switch n := node.(type) {
+	case *File:
+		walkStmts(v, n.Stmts)
+	case *ExprStmt:
+		Walk(v, n.X)
+	case *AssignStmt:
+		Walk(v, n.Target)
+		Walk(v, n.Value)
+	case *ValStmt:
+		for _, name := range n.Names {
+			Walk(v, name)
+		}
+		if n.Value != nil {
+			Walk(v, n.Value)
+		}
+	case *IfStmt:
+		Walk(v, n.Cond)
+		Walk(v, n.Body)
+		if n.Else != nil {
+			Walk(v, n.Else)
+		}
+	case *ForStmt:
+		Walk(v, n.Key)
+		if n.Value != nil {
+			Walk(v, n.Value)
+		}
+		Walk(v, n.X)
+		Walk(v, n.Body)
+	case *FuncStmt:
+		Walk(v, n.Name)
+		for _, param := range n.Params {
+			Walk(v, param)
+		}
+		Walk(v, n.Body)
+	case *ReturnStmt:
+		if n.Result != nil {
+			Walk(v, n.Result)
+		}
+	case *BlockStmt:
+		walkStmts(v, n.Stmts)
+	case *CustomStmt:
+		Walk(v, n.Name)
+	case *BranchStmt, *Ident, *BasicLit:
+		// nothing to do
+	case *ArrayLit:
+		walkExprs(v, n.Elems)
+	case *Var:
+		for _, e := range n.Elems {
+			if e.Index != nil {
+				Walk(v, e.Index)
+			}
+			walkExprs(v, e.Args)
+		}
+	case *BinaryExpr:
+		Walk(v, n.X)
+		Walk(v, n.Y)
+	case *UnaryExpr:
+		Walk(v, n.X)
+	case *CondExpr:
+		Walk(v, n.Cond)
+		Walk(v, n.X)
+		Walk(v, n.Y)
+	default:
+		panic(fmt.Sprintf("ast.Walk: unexpected node type %T", n))
+	}
+	v.Visit(nil)
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses the tree in depth-first order: it starts by calling f(node);
+// a nil node is skipped. If f returns true, Inspect invokes f recursively for each
+// of the non-nil children of node, followed by a call of f(nil).
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
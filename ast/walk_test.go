@@ -0,0 +1,21 @@
+package ast
+
+import "testing"
+
+func TestWalkSkipsNilChildren(t *testing.T) {
+	tree := &ExprStmt{X: &BinaryExpr{X: &Ident{Name: "a"}, Op: "+"}}
+	var visited []Node
+	Inspect(tree, func(n Node) bool {
+		if n != nil {
+			visited = append(visited, n)
+		}
+		return true
+	})
+	if len(visited) != 3 {
+		t.Errorf("visited %d nodes, want 3", len(visited))
+	}
+	Inspect(nil, func(Node) bool {
+		t.Error("visited a nil node")
+		return true
+	})
+}
@@ -0,0 +1,535 @@
+package mathxf
+
+import (
+	"sync"
+
+	"github.com/shopspring/decimal"
+)
+
+type opcode uint8
+
+const (
+	opConst     opcode = iota // push the constant a
+	opGlobal                  // push the value of vars[a], its root is resolved once per run into slot b
+	opLocal                   // push the value of vars[a], its root is looked up on every access
+	opLoopVar                 // push the value of vars[a], its root is a variable of the loop b>>1 levels out, the value if b&1 is 1
+	opArray                   // pop a values and push them as a list
+	opDup                     // push the top of the stack again
+	opBinary                  // pop y and x, push x op y, op is tokens[a] and the position of y tokens[b]
+	opUnary                   // pop x, push op x, op is tokens[a] and the position of x tokens[b]
+	opIdentity                // replace x on top by x*1 or x+0 of idents[a]
+	opJump                    // jump to a
+	opJumpFalse               // pop x and jump to a unless x is true, x is used as names[b]
+	opAnd                     // jump to a with false on the stack unless the top is true, otherwise pop it
+	opOr                      // jump to a with true on the stack if the top is true, otherwise pop it
+	opBool                    // replace the top by its truth value, it is the operand of tokens[a]
+	opBuiltin                 // fall through if the slot of calls[a] resolves to its builtin, otherwise jump to b
+	opCall                    // pop the arguments of calls[a] and push the result of its builtin
+	opSetRes                  // pop x and write it to the default result key under names[a], unless b is 1 and x is nil
+	opAssign                  // assign the chunk of assigns[a] to its variable, b is 1 for a result like res.x
+	opTarget                  // look up the root of vars[a], the target of the next opStore
+	opStore                   // pop x and store it in the target of the last opTarget
+	opDefine                  // pop x and declare sets[a] with it
+	opEnter                   // enter the block that starts at tokens[a]
+	opLeave                   // leave the current block
+	opIter                    // pop the iterable of loops[a] and start iterating it
+	opNext                    // declare the loop variables of the next item, jump to a after the last one
+	opEndLoop                 // end the innermost loop
+	opExec                    // execute the tree node nodes[a]
+	opReturn                  // end of a chunk
+)
+
+// instr is a single instruction, t indexes tokens and is the position of a failing step, -1 if none.
+type instr struct {
+	op   opcode
+	a, b int32
+	t    int32
+}
+
+// builtinFunc is a function of DefConst or AddFuncOrConst called without reflect.
+type builtinFunc func(ctx *EvaluatorContext, args []*Value) (*Value, error)
+
+type builtinCall struct {
+	ele    *ValElement // the constant the slot has to resolve to
+	slot   int32
+	fn     builtinFunc
+	nargs  int
+	varPos *Token
+	argPos []*Token
+}
+
+type assignChunk struct {
+	variable *variableResolver
+	start    int
+	pos      *Token
+}
+
+// bytecode is a compiled nodeDocument, see Program.compileBytecode. It is read-only while running.
+type bytecode struct {
+	code      []instr
+	consts    [2][]*Value
+	tokens    []*Token
+	names     []string
+	vars      []*variableResolver
+	slotNames []string
+	calls     []*builtinCall
+	assigns   []*assignChunk
+	loops     []*tagForNode
+	sets      []*SetNode
+	idents    []*identityExpression
+	nodes     []INode
+	fresh     bool // every iteration of a loop needs a scope of its own, a func may capture it
+
+	pool sync.Pool // *machine
+}
+
+// constant pools, indexed by IsHighPrecision
+const (
+	poolFloat   = 0
+	poolDecimal = 1
+)
+
+func boolIndex(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// bytecodeCompiler translates the nodes of a document. Statements and expressions
+// without an instruction of their own are kept as tree nodes and executed by opExec.
+type bytecodeCompiler struct {
+	bc       *bytecode
+	prog     *Program
+	declared map[string]bool // names declared in the template, never resolved into a slot
+	slots    map[string]int32
+	tokenIdx map[*Token]int32
+	noSlots  bool   // custom tags may declare any name
+	pos      *Token // the statement being compiled, the position of instructions without one
+	loops    []*loopLabels
+}
+
+// loopLabels are the jump targets of break and continue in the innermost loop.
+type loopLabels struct {
+	node   *tagForNode
+	next   int   // the opNext of the loop
+	breaks []int // jumps to patch to the opEndLoop
+}
+
+func (p *Program) compileBytecode() *bytecode {
+	c := &bytecodeCompiler{
+		bc:       new(bytecode),
+		prog:     p,
+		declared: make(map[string]bool),
+		slots:    make(map[string]int32),
+		tokenIdx: make(map[*Token]int32),
+	}
+	for _, node := range p.exec.Nodes {
+		c.collect(node)
+	}
+	c.bc.fresh = c.bc.fresh || c.noSlots
+	for _, node := range p.exec.Nodes {
+		c.stmt(node)
+	}
+	c.emit(opReturn, 0, 0, nil)
+	return c.bc
+}
+
+// collect records the names declared by node and its children.
+func (c *bytecodeCompiler) collect(node INode) {
+	switch n := node.(type) {
+	case tagSetNode:
+		for _, set := range n.setNodes {
+			c.declared[set.name] = true
+		}
+	case *tagIfNode:
+		for _, w := range n.wrappers {
+			c.collectWrapper(w)
+		}
+	case *tagForNode:
+		c.declared[n.keyName] = true
+		c.declared[n.valName] = true
+		c.collectWrapper(n.wrapper)
+	case *tagFuncNode:
+		c.declared[n.nameToken.val] = true
+		c.bc.fresh = true
+		for _, param := range n.params {
+			c.declared[param] = true
+		}
+		c.collectWrapper(n.body)
+	case *NodeWrapper:
+		c.collectWrapper(n)
+	case *customTagNode:
+		c.noSlots = true
+	}
+}
+
+func (c *bytecodeCompiler) collectWrapper(w *NodeWrapper) {
+	for _, node := range w.nodes {
+		c.collect(node)
+	}
+}
+
+func (c *bytecodeCompiler) token(t *Token) int32 {
+	if t == nil {
+		return -1
+	}
+	if i, ok := c.tokenIdx[t]; ok {
+		return i
+	}
+	i := int32(len(c.bc.tokens))
+	c.bc.tokens = append(c.bc.tokens, t)
+	c.tokenIdx[t] = i
+	return i
+}
+
+func (c *bytecodeCompiler) emit(op opcode, a, b int32, pos *Token) int {
+	if pos == nil {
+		pos = c.pos
+	}
+	c.bc.code = append(c.bc.code, instr{op: op, a: a, b: b, t: c.token(pos)})
+	return len(c.bc.code) - 1
+}
+
+// patch makes the jump at i go to the next instruction.
+func (c *bytecodeCompiler) patch(i int) {
+	c.bc.code[i].a = int32(len(c.bc.code))
+}
+
+// constant adds a constant with the value f without and d with high precision.
+func (c *bytecodeCompiler) constant(f, d *Value) int32 {
+	bc := c.bc
+	bc.consts[poolFloat] = append(bc.consts[poolFloat], f)
+	bc.consts[poolDecimal] = append(bc.consts[poolDecimal], d)
+	return int32(len(bc.consts[poolFloat]) - 1)
+}
+
+func (c *bytecodeCompiler) name(s string) int32 {
+	c.bc.names = append(c.bc.names, s)
+	return int32(len(c.bc.names) - 1)
+}
+
+func (c *bytecodeCompiler) exec(node INode) {
+	c.bc.nodes = append(c.bc.nodes, node)
+	c.emit(opExec, int32(len(c.bc.nodes)-1), 0, nil)
+}
+
+func (c *bytecodeCompiler) stmt(node INode) {
+	if pos := nodeToken(node); pos != nil {
+		defer func(outer *Token) { c.pos = outer }(c.pos)
+		c.pos = pos
+	}
+	switch n := node.(type) {
+	case NodeResData:
+		c.expr(n.evl)
+		c.emit(opSetRes, c.name(n.name), int32(boolIndex(isCall(n.evl))), n.evl.GetPositionToken())
+	case *NodeAssignment:
+		if root := n.variable.parts[0]; len(n.variable.parts) == 1 && !root.isFunctionCall && !c.resultKey(root.name) {
+			c.bc.vars = append(c.bc.vars, n.variable)
+			c.emit(opTarget, int32(len(c.bc.vars)-1), 0, n.variable.locationToken)
+			c.expr(n.value)
+			c.emit(opStore, 0, 0, n.variable.locationToken)
+			return
+		}
+		jump := c.emit(opJump, 0, 0, nil)
+		start := len(c.bc.code)
+		c.expr(n.value)
+		c.emit(opReturn, 0, 0, nil)
+		c.patch(jump)
+		c.bc.assigns = append(c.bc.assigns, &assignChunk{variable: n.variable, start: start, pos: n.value.GetPositionToken()})
+		c.emit(opAssign, int32(len(c.bc.assigns)-1), int32(boolIndex(c.isResult(n.variable))), n.variable.locationToken)
+	case tagSetNode:
+		for i, set := range n.setNodes {
+			if i == 0 || !n.isAssign {
+				c.expr(set.expression)
+			}
+			if n.isAssign && i < len(n.setNodes)-1 {
+				// the names share the value of the expression
+				c.emit(opDup, 0, 0, nil)
+			}
+			c.bc.sets = append(c.bc.sets, set)
+			c.emit(opDefine, int32(len(c.bc.sets)-1), 0, set.expression.GetPositionToken())
+		}
+	case *tagIfNode:
+		var ends []int
+		for i, cond := range n.conditions {
+			c.expr(cond)
+			next := c.emit(opJumpFalse, 0, c.name("condition of "+n.ifTokens[i].spelling()), cond.GetPositionToken())
+			c.block(n.wrappers[i])
+			ends = append(ends, c.emit(opJump, 0, 0, nil))
+			c.patch(next)
+		}
+		if len(n.wrappers) > len(n.conditions) {
+			c.block(n.wrappers[len(n.conditions)])
+		}
+		for _, end := range ends {
+			c.patch(end)
+		}
+	case *tagForNode:
+		c.expr(n.iterable)
+		c.bc.loops = append(c.bc.loops, n)
+		c.emit(opIter, int32(len(c.bc.loops)-1), 0, n.iterable.GetPositionToken())
+		labels := &loopLabels{node: n, next: c.emit(opNext, 0, 0, n.iterable.GetPositionToken())}
+		c.loops = append(c.loops, labels)
+		c.block(n.wrapper)
+		c.loops = c.loops[:len(c.loops)-1]
+		c.emit(opJump, int32(labels.next), 0, nil)
+		c.patch(labels.next)
+		for _, jump := range labels.breaks {
+			c.patch(jump)
+		}
+		c.emit(opEndLoop, 0, 0, nil)
+	case tagBreakNode:
+		if len(c.loops) == 0 {
+			c.exec(node)
+			return
+		}
+		labels := c.loops[len(c.loops)-1]
+		labels.breaks = append(labels.breaks, c.emit(opJump, 0, 0, n.locationToken))
+	case tagContinueNode:
+		if len(c.loops) == 0 {
+			c.exec(node)
+			return
+		}
+		c.emit(opJump, int32(c.loops[len(c.loops)-1].next), 0, n.locationToken)
+	case *NodeWrapper:
+		c.block(n)
+	default:
+		c.exec(node)
+	}
+}
+
+// isResult reports whether v is a result like res.x, written to the results unless the key is shadowed by a variable.
+func (c *bytecodeCompiler) isResult(v *variableResolver) bool {
+	if len(v.parts) != 2 || !c.resultKey(v.parts[0].name) {
+		return false
+	}
+	part := v.parts[1]
+	return part.typ == VariablePartTypeIdent && !v.parts[0].isFunctionCall && !part.isFunctionCall
+}
+
+// resultKey reports whether name is a key of the results, an assignment to it is left to SetPartValue.
+func (c *bytecodeCompiler) resultKey(name string) bool {
+	if name == c.prog.defResultKey {
+		return true
+	}
+	for _, key := range c.prog.resultKeys {
+		if key == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *bytecodeCompiler) block(w *NodeWrapper) {
+	c.emit(opEnter, c.token(w.locationToken), 0, w.locationToken)
+	for _, node := range w.nodes {
+		c.stmt(node)
+	}
+	c.emit(opLeave, 0, 0, nil)
+}
+
+func (c *bytecodeCompiler) binary(x, y IEvaluator, op *Token) {
+	c.expr(x)
+	if y == nil {
+		return
+	}
+	c.expr(y)
+	c.emit(opBinary, c.token(op), c.token(y.GetPositionToken()), op)
+}
+
+func (c *bytecodeCompiler) expr(e IEvaluator) {
+	switch n := e.(type) {
+	case *numberResolver:
+		c.emit(opConst, c.constant(AsValue(n.val), AsValue(decimal.NewFromFloat(n.val))), 0, n.locationToken)
+	case *boolResolver:
+		v := AsValue(n.val)
+		c.emit(opConst, c.constant(v, v), 0, n.locationToken)
+	case *stringResolver:
+		v := AsValue(n.val)
+		c.emit(opConst, c.constant(v, v), 0, n.locationToken)
+	case *moneyResolver:
+		v := AsValue(n.val)
+		c.emit(opConst, c.constant(v, v), 0, n.locationToken)
+	case *durationResolver:
+		v := AsValue(n.val)
+		c.emit(opConst, c.constant(v, v), 0, n.locationToken)
+	case *constResolver:
+		if len(n.consts) > 0 || n.numeric != nil {
+			// inlined constants and the numeric settings are checked by Evaluate
+			c.evalNode(e)
+			return
+		}
+		c.emit(opConst, c.constant(n.vals[poolFloat], n.vals[poolDecimal]), 0, n.locationToken)
+	case *identityExpression:
+		c.expr(n.x)
+		c.bc.idents = append(c.bc.idents, n)
+		c.emit(opIdentity, int32(len(c.bc.idents)-1), 0, n.opToken)
+	case *arrayResolver:
+		if len(n.parts) == 0 {
+			v := &Value{}
+			c.emit(opConst, c.constant(v, v), 0, n.locationToken)
+			return
+		}
+		for _, part := range n.parts {
+			c.expr(part.subscript)
+		}
+		c.emit(opArray, int32(len(n.parts)), 0, n.locationToken)
+	case *variableResolver:
+		c.variable(n)
+	case *Expression:
+		if n.expr2 == nil {
+			c.expr(n.expr1)
+			return
+		}
+		c.expr(n.expr1)
+		op := opAnd
+		if n.opToken.typ == TokenOr {
+			op = opOr
+		}
+		end := c.emit(op, 0, c.token(n.opToken), n.opToken)
+		c.expr(n.expr2)
+		c.emit(opBool, c.token(n.opToken), 0, n.opToken)
+		c.patch(end)
+	case *ternaryExpression:
+		c.expr(n.cond)
+		next := c.emit(opJumpFalse, 0, c.name("condition of "+n.opToken.spelling()), n.cond.GetPositionToken())
+		c.expr(n.expr1)
+		end := c.emit(opJump, 0, 0, nil)
+		c.patch(next)
+		c.expr(n.expr2)
+		c.patch(end)
+	case *relationalExpression:
+		c.binary(n.expr1, n.expr2, n.opToken)
+	case *simpleExpression:
+		c.binary(n.term1, n.term2, n.opToken)
+	case *termExpression:
+		c.binary(n.factor1, n.factor2, n.opToken)
+	case *powerExpression:
+		c.binary(n.power1, n.power2, n.opToken)
+	case *unaryExpression:
+		c.expr(n.expr)
+		c.emit(opUnary, c.token(n.opToken), c.token(n.expr.GetPositionToken()), n.opToken)
+	default:
+		// an expression the compiler does not know, evaluate it as a tree
+		c.evalNode(e)
+	}
+}
+
+func (c *bytecodeCompiler) evalNode(e IEvaluator) {
+	c.bc.nodes = append(c.bc.nodes, evalNode{e})
+	c.emit(opExec, int32(len(c.bc.nodes)-1), 1, e.GetPositionToken())
+}
+
+func (c *bytecodeCompiler) variable(v *variableResolver) {
+	c.bc.vars = append(c.bc.vars, v)
+	idx := int32(len(c.bc.vars) - 1)
+	root := v.parts[0]
+	if c.noSlots || c.declared[root.name] {
+		// a val in the body of a loop cannot redeclare its variables, only an inner loop shadows them
+		for i := len(c.loops) - 1; i >= 0 && !c.noSlots; i-- {
+			out := int32(len(c.loops)-1-i) << 1
+			switch root.name {
+			case c.loops[i].node.keyName:
+				c.emit(opLoopVar, idx, out, v.locationToken)
+				return
+			case c.loops[i].node.valName:
+				c.emit(opLoopVar, idx, out|1, v.locationToken)
+				return
+			}
+		}
+		c.emit(opLocal, idx, 0, v.locationToken)
+		return
+	}
+	slot, ok := c.slots[root.name]
+	if !ok {
+		slot = int32(len(c.bc.slotNames))
+		c.slots[root.name] = slot
+		c.bc.slotNames = append(c.bc.slotNames, root.name)
+	}
+	if call := c.builtin(v, slot); call != nil {
+		c.bc.calls = append(c.bc.calls, call)
+		callIdx := int32(len(c.bc.calls) - 1)
+		guard := c.emit(opBuiltin, callIdx, 0, v.locationToken)
+		for _, arg := range root.callingArgs {
+			c.expr(arg)
+		}
+		c.emit(opCall, callIdx, 0, v.locationToken)
+		end := c.emit(opJump, 0, 0, nil)
+		c.bc.code[guard].b = int32(len(c.bc.code))
+		c.emit(opGlobal, idx, slot, v.locationToken)
+		c.patch(end)
+		return
+	}
+	c.emit(opGlobal, idx, slot, v.locationToken)
+}
+
+// builtin returns the direct call of v, a call of a registered Go function with *Value arguments.
+func (c *bytecodeCompiler) builtin(v *variableResolver, slot int32) *builtinCall {
+	root := v.parts[0]
+	if len(v.parts) != 1 || !root.isFunctionCall {
+		return nil
+	}
+	ele, ok := c.prog.lookupConst(root.name)
+	if !ok || !ele.IsFunc {
+		return nil
+	}
+	call := &builtinCall{ele: ele, slot: slot, nargs: len(root.callingArgs), varPos: v.locationToken}
+	for _, arg := range root.callingArgs {
+		call.argPos = append(call.argPos, arg.GetPositionToken())
+	}
+	switch fn := ele.Val.(type) {
+	case func(*EvaluatorContext, ...*Value) (*Value, error):
+		call.fn = func(ctx *EvaluatorContext, args []*Value) (*Value, error) {
+			return fn(ctx, args...)
+		}
+	case func(*EvaluatorContext, *Value) (*Value, error):
+		if call.nargs != 1 {
+			return nil
+		}
+		call.fn = func(ctx *EvaluatorContext, args []*Value) (*Value, error) {
+			return fn(ctx, args[0])
+		}
+	case func(*EvaluatorContext, *Value, *Value) (*Value, error):
+		if call.nargs != 2 {
+			return nil
+		}
+		call.fn = func(ctx *EvaluatorContext, args []*Value) (*Value, error) {
+			return fn(ctx, args[0], args[1])
+		}
+	case func(*EvaluatorContext, *Value, *Value, ...*Value) (*Value, error):
+		if call.nargs < 2 {
+			return nil
+		}
+		call.fn = func(ctx *EvaluatorContext, args []*Value) (*Value, error) {
+			return fn(ctx, args[0], args[1], args[2:]...)
+		}
+	case func(*Value) (*Value, error):
+		if call.nargs != 1 {
+			return nil
+		}
+		call.fn = func(ctx *EvaluatorContext, args []*Value) (*Value, error) {
+			return fn(args[0])
+		}
+	case func(*Value, *Value) (*Value, error):
+		if call.nargs != 2 {
+			return nil
+		}
+		call.fn = func(ctx *EvaluatorContext, args []*Value) (*Value, error) {
+			return fn(args[0], args[1])
+		}
+	default:
+		return nil
+	}
+	return call
+}
+
+// evalNode runs an expression unknown to the compiler as a tree, opExec with b=1 pushes its value.
+type evalNode struct {
+	IEvaluator
+}
+
+func (n evalNode) Execute(ctx *EvaluatorContext) error {
+	_, err := n.Evaluate(ctx)
+	return err
+}
@@ -0,0 +1,196 @@
+package mathxf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// benchRules compare the tree-walker with the bytecode VM: go test -bench . -run '^$'
+var benchRules = []struct {
+	name string
+	src  string
+}{
+	{"direct", `1 + 2 * 6 / 4 + (456 - 8 * 9.2) - (2 + 4 ^ 5)`},
+	{"coupon", `
+if TotalOrders > 5 && SpecialOffer_10_Orders <= 3 {
+   res.CouponFace = max((OrderTotalAmount - SpecialOffer_10_Orders * 10) * 0.3, 0)
+}
+`},
+	{"loop", `
+val total = 0
+for i, item in items {
+   if item > 50 { continue }
+   total = total + item * (i + 1)
+}
+res.total = total
+`},
+}
+
+var benchEnv = map[string]any{
+	"TotalOrders":            10,
+	"OrderTotalAmount":       100,
+	"SpecialOffer_10_Orders": 3,
+	"items":                  []int{10, 20, 30, 60, 40, 70, 5},
+}
+
+func BenchmarkRun(b *testing.B) {
+	for _, rule := range benchRules {
+		for _, bytecode := range []bool{false, true} {
+			prog, err := Compile(rule.src, WithBytecode(bytecode))
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.Run(fmt.Sprintf("%s/bytecode=%v", rule.name, bytecode), func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if _, err := prog.Run(context.Background(), benchEnv); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// readmeRules are the rules of the README, each is run as a tree and as bytecode.
+var readmeRules = []struct {
+	name string
+	src  string
+	env  map[string]any
+	opts []Option
+}{
+	{name: "direct", src: benchRules[0].src},
+	{name: "coupon", src: benchRules[1].src, env: benchEnv},
+	{name: "loop", src: benchRules[2].src, env: benchEnv},
+	{name: "replace", src: "if 用户显示变量 A > 10 {\n  res.aa=5\n}", env: map[string]any{"UserA": 100},
+		opts: []Option{WithReplaceStrMap(map[string]string{"用户显示变量 A": "UserA"})}},
+	{name: "keywords", src: "如果 等级 > 3 并且 非 冻结 {\n  res.rate = 0.3\n} 否则 {\n  res.rate = 0\n}",
+		env:  map[string]any{"等级": 5, "冻结": false},
+		opts: []Option{WithKeywords(map[string]string{"如果": "if", "否则": "else", "并且": "and", "或者": "or", "非": "not"})}},
+	{name: "consts", src: `if level > 3 { res.rate = 0.3 * ff }`, env: map[string]any{"level": 5},
+		opts: []Option{WithFuncOrConst("ff", 100)}},
+	{name: "combined", src: `
+// 用户优惠劵面值计算规则
+if 用户A.总订单数 > 5 && 特价商品区.单价小于10元.订单数<=3 {
+   优惠劵面值= 优惠算法((用户A.订单总金额-特价商品区.单价小于10元.订单数*10)*优惠比率最大值,用户A.用户等级)
+}
+`, env: map[string]any{"TotalOrders": 10, "UserLevel": 4, "OrderTotalAmount": 100, "SpecialOffer_10_Orders": 3, "CouponFace": 0},
+		opts: []Option{
+			WithReplaceStrMap(map[string]string{
+				"用户A.总订单数": "TotalOrders", "用户A.用户等级": "UserLevel", "用户A.订单总金额": "OrderTotalAmount",
+				"特价商品区.单价小于10元.订单数": "SpecialOffer_10_Orders", "优惠比率最大值": "DiscountRatioMax",
+				"优惠劵面值": "CouponFace", "优惠算法": "DiscountAlgorithm",
+			}),
+			WithFuncOrConst("DiscountAlgorithm", func(a, b *Value) decimal.Decimal {
+				if b.Integer() > 3 {
+					return a.Decimal().Mul(decimal.NewFromFloat(0.3))
+				}
+				return a.Decimal().Mul(decimal.NewFromFloat(0.1))
+			}),
+			WithFuncOrConst("DiscountRatioMax", 0.3),
+		}},
+	{name: "ternary", src: `res.rate = level > 3 ? 0.3 : 0.1`, env: map[string]any{"level": 2}},
+	{name: "func", src: "func fib(n) { return n < 2 ? n : fib(n - 1) + fib(n - 2) }\nres.x = fib(10)"},
+	{name: "range", src: "val s = 0\nfor i in range(0, 10, 2) { s = s + i }\nres.s = s"},
+	{name: "money", src: `res.total = price * 3 + 10 CNY
+res.rate = 25 CNY / 100 CNY
+res.parts = split(100 CNY, 3)
+res.coupons = allocate(0.05 CNY, 3, 7)
+res.text = format_money(1234567.891 CNY)`, env: map[string]any{"price": Money{Amount: decimal.NewFromInt(5), Currency: "CNY"}}},
+	{name: "datetime", src: `val due = date("2026-10-18") + 3d
+val left = due - now()
+res.overdue = now() > due && left < -1d
+res.days = days_between(start, due)
+res.next = add_months(date("2026-01-31"), 1)`,
+		env:  map[string]any{"start": time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)},
+		opts: []Option{WithLocation(time.UTC), WithNow(func() time.Time { return time.Date(2026, 10, 25, 0, 0, 0, 0, time.UTC) })}},
+	{name: "strings", src: `res.n = len("你好world")
+res.s = substr("你好世界", 1, 2)
+val parts = split("a,b,c", ",")
+res.text = join(parts, "/") + pad_left(to_string(7), 3, "0")
+res.price = format("%.2f 元", 2.675)`},
+	{name: "math", src: `res.r = sqrt(2) + ln(10) + sin(pi / 6)`, opts: []Option{WithMathScale(30)}},
+	// loops and assignments run natively by the VM
+	{name: "nested loops", src: `val s = 0
+for i in range(0, 3) {
+   for i, v in items {
+      if v > 30 { break } else if i == 0 { continue }
+      s = s + i * v
+   }
+   s = s + i
+}
+res.s = s`, env: benchEnv},
+	{name: "map loop", src: "val s = \"\"\nfor k, v in m { s = s + k + to_string(v) }\nfor k in m { s = s + k }\nres.s = s",
+		env: map[string]any{"m": map[string]int{"b": 2, "a": 1, "c": 3}}},
+	{name: "string loop", src: "val s = \"\"\nfor i, c in \"你好ab\" { s = s + to_string(i) + c }\nfor c in \"是\" { s = s + c }\nres.s = s"},
+	{name: "loop var", src: "val s = 0\nfor v in items {\n  v = v * 2\n  s = s + v\n}\nres.s = s", env: benchEnv},
+	{name: "env writes", src: "res.a = n + 1\nn = n * 2\nres.b = n\nn = n + 1\nres.c = n", env: map[string]any{"n": 3}},
+	{name: "res shadowed", src: "res.x = 1\nres.y = 2", env: map[string]any{"res": map[string]any{"x": 5}}},
+	{name: "func in loop", src: `val s = 0
+for i in range(1, 4) {
+   func f(x) { return x * i }
+   s = s + f(10)
+}
+res.s = s`},
+	// errors
+	{name: "unknown func", src: "res.p = 1\nres.q = 用户A.总订单数(1) + a",
+		opts: []Option{WithReplaceStrMap(map[string]string{"用户A.总订单数": "TotalOrders"})}},
+	{name: "divide zero", src: "val a = 1\nres.x = a / (b - 2)", env: map[string]any{"b": 2}},
+	{name: "argument", src: `res.x = substr("abc", 0, n)`, env: map[string]any{"n": -1}},
+	{name: "currency", src: `res.x = 1 CNY + 1 USD`},
+	{name: "break", src: "val a = 1\n跳出", opts: []Option{WithKeywords(map[string]string{"跳出": "break"})}},
+	{name: "loop error", src: "for i in items {\n  res.x = 10 / (i - 20)\n}", env: benchEnv},
+	{name: "assign undeclared", src: "val a = 1\nb = a"},
+	{name: "assign const", src: "res.x = 1\npi = 3"},
+	{name: "max steps", src: "val s = 0\nfor i in range(0, 100) { s = s + i }", opts: []Option{WithMaxSteps(50)}},
+}
+
+// TestBytecodeMatchesTree checks that the VM gives the results and error positions of the tree-walker.
+func TestBytecodeMatchesTree(t *testing.T) {
+	for _, rule := range readmeRules {
+		for _, hp := range []bool{true, false} {
+			var want string
+			for _, bytecode := range []bool{false, true} {
+				got := runDump(rule.src, rule.env, append(rule.opts, WithHighPrecision(hp), WithBytecode(bytecode))...)
+				if !bytecode {
+					want = got
+				} else if got != want {
+					t.Errorf("%s hp=%v:\ntree:     %s\nbytecode: %s", rule.name, hp, want, got)
+				}
+			}
+		}
+	}
+}
+
+// runDump compiles and runs src and returns its results or its error, compile errors included.
+func runDump(src string, env map[string]any, opts ...Option) string {
+	p, err := Compile(src, opts...)
+	if err == nil {
+		var res map[string]ValMap
+		if res, err = p.Run(context.Background(), env); err == nil {
+			var lines []string
+			for key, vals := range res {
+				for name, v := range vals {
+					lines = append(lines, fmt.Sprintf("%s.%s=%v", key, name, v.Interface()))
+				}
+			}
+			sort.Strings(lines)
+			return fmt.Sprint(lines)
+		}
+	}
+	var e *Error
+	if !errors.As(err, &e) {
+		return "error " + err.Error()
+	}
+	// steps are counted per instruction by the VM, only the code of the limit is compared
+	if e.Code == StepLimitExceededErr.Code() {
+		return fmt.Sprintf("error %d", e.Code)
+	}
+	return fmt.Sprintf("error %d at %d:%d [%d,%d) %s", e.Code, e.Line, e.Column, e.Start, e.End, e.Message)
+}
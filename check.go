@@ -0,0 +1,256 @@
+package mathxf
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/xslasd/mathxf/ast"
+)
+
+// Check reports mistakes that would otherwise only be found when the failing statement runs:
+// calls of unknown functions, wrong argument counts against the registered Go signatures
+// and func definitions, assignments to constants and duplicate val declarations.
+//
+// env lists the env variables the program may use. When it is given, reads of and assignments to
+// any other unknown identifier are reported as well, without it unknown variables are assumed to come from env.
+func (p *Program) Check(env ...string) ErrorList {
+	c := &checker{
+		prog:     p,
+		scopes:   []map[string]*checkSymbol{{}},
+		checkEnv: len(env) > 0,
+		env:      make(map[string]bool, len(env)),
+	}
+	for _, name := range env {
+		c.env[name] = true
+	}
+	if p.root != nil {
+		c.stmts(p.AST().Stmts)
+	}
+	return c.errs
+}
+
+// checkSymbol is a name declared in the template, arity is the number of parameters of a func, -1 otherwise.
+type checkSymbol struct {
+	arity int
+}
+
+type checker struct {
+	prog     *Program
+	scopes   []map[string]*checkSymbol
+	checkEnv bool
+	env      map[string]bool
+	errs     ErrorList
+}
+
+// nodeErr sets the span of n on ec, the result refers to the original source.
+func (p *Program) nodeErr(ec ECodes, n ast.Node) error {
+	pos, end := n.Pos(), n.End()
+	t := &Token{line: pos.Line, col: end.Column - 1, pos: pos.Offset, end: end.Offset}
+	err := p.parseErrFn(ec.SetToken(t))
+	var e *Error
+	if errors.As(err, &e) {
+		res := *e
+		res.source = p.src
+		return res.Localize(p.locale)
+	}
+	return err
+}
+
+func (c *checker) report(ec ECodes, n ast.Node) {
+	c.errs = append(c.errs, c.prog.nodeErr(ec, n))
+}
+
+func (c *checker) lookup(name string) (*checkSymbol, bool) {
+	for i := len(c.scopes) - 1; i >= 0; i-- {
+		if sym, ok := c.scopes[i][name]; ok {
+			return sym, true
+		}
+	}
+	return nil, false
+}
+
+func (c *checker) isResultKey(name string) bool {
+	if name == c.prog.defResultKey {
+		return true
+	}
+	for _, key := range c.prog.resultKeys {
+		if key == name {
+			return true
+		}
+	}
+	return false
+}
+
+// declare adds name to the innermost scope, like EvaluatorContext.declared it fails on any visible
+// name except the builtins of DefConst, which the declaration shadows.
+func (c *checker) declare(name *ast.Ident, arity int) {
+	_, local := c.lookup(name.Name)
+	_, isConst := c.prog.consts[name.Name]
+	if local || isConst || c.env[name.Name] || c.isResultKey(name.Name) {
+		c.report(VariableAlreadyExistsErr.SetMessagef(name.Name), name)
+		return
+	}
+	c.scopes[len(c.scopes)-1][name.Name] = &checkSymbol{arity: arity}
+}
+
+func (c *checker) stmts(list []ast.Stmt) {
+	for _, s := range list {
+		c.stmt(s)
+	}
+}
+
+func (c *checker) stmt(s ast.Stmt) {
+	switch n := s.(type) {
+	case *ast.ExprStmt:
+		c.expr(n.X)
+	case *ast.AssignStmt:
+		c.expr(n.Value)
+		c.assign(n.Target)
+	case *ast.ValStmt:
+		c.expr(n.Value)
+		for _, name := range n.Names {
+			c.declare(name, -1)
+		}
+	case *ast.IfStmt:
+		c.ifStmt(n)
+	case *ast.ForStmt:
+		c.expr(n.X)
+		// the loop variables live in the scope of the loop and shadow any outer name, like at run time
+		vars := map[string]*checkSymbol{n.Key.Name: {arity: -1}}
+		if n.Value != nil {
+			vars[n.Value.Name] = &checkSymbol{arity: -1}
+		}
+		c.scopes = append(c.scopes, vars)
+		c.stmts(n.Body.Stmts)
+		c.scopes = c.scopes[:len(c.scopes)-1]
+	case *ast.FuncStmt:
+		c.declare(n.Name, len(n.Params))
+		params := make(map[string]*checkSymbol, len(n.Params))
+		for _, param := range n.Params {
+			params[param.Name] = &checkSymbol{arity: -1}
+		}
+		c.scopes = append(c.scopes, params)
+		c.stmts(n.Body.Stmts)
+		c.scopes = c.scopes[:len(c.scopes)-1]
+	case *ast.ReturnStmt:
+		c.expr(n.Result)
+	case *ast.BlockStmt:
+		c.stmts(n.Stmts)
+	}
+}
+
+// ifStmt checks the branches of n one after another. Only one branch runs,
+// so a name may be declared by several of them.
+func (c *checker) ifStmt(n *ast.IfStmt) {
+	scope := c.scopes[len(c.scopes)-1]
+	declared := make(map[string]*checkSymbol)
+	var branch ast.Stmt = n
+	for branch != nil {
+		saved := make(map[string]*checkSymbol, len(scope))
+		for k, v := range scope {
+			saved[k] = v
+		}
+		switch b := branch.(type) {
+		case *ast.IfStmt:
+			c.expr(b.Cond)
+			c.stmts(b.Body.Stmts)
+			branch = b.Else
+		default:
+			c.stmt(b)
+			branch = nil
+		}
+		for k, v := range scope {
+			if _, ok := saved[k]; !ok {
+				declared[k] = v
+				delete(scope, k)
+			}
+		}
+	}
+	for k, v := range declared {
+		scope[k] = v
+	}
+}
+
+func (c *checker) assign(v *ast.Var) {
+	for _, e := range v.Elems {
+		c.expr(e.Index)
+		for _, arg := range e.Args {
+			c.expr(arg)
+		}
+	}
+	root := v.Root()
+	if _, ok := c.lookup(root); ok || c.env[root] || c.isResultKey(root) {
+		return
+	}
+	if _, ok := c.prog.lookupConst(root); ok {
+		c.report(VariableCannotSetValueErr.SetMessagef(root), v)
+		return
+	}
+	if c.checkEnv {
+		c.report(AssignObjectErr.SetMessagef(root), v)
+	}
+}
+
+func (c *checker) expr(x ast.Expr) {
+	if x == nil {
+		return
+	}
+	ast.Inspect(x, func(node ast.Node) bool {
+		if v, ok := node.(*ast.Var); ok {
+			c.variable(v)
+		}
+		return true
+	})
+}
+
+func (c *checker) variable(v *ast.Var) {
+	root := v.Elems[0]
+	if sym, ok := c.lookup(root.Name); ok {
+		if root.Call && sym.arity < 0 {
+			c.report(VariableNotFunctionErr.SetMessagef(root.Name), v)
+		} else if root.Call && sym.arity != len(root.Args) {
+			c.report(ArgumentNotEnoughErr.SetMessagef(root.Name, fmt.Sprintf("=%d", sym.arity), len(root.Args)), v)
+		}
+		return
+	}
+	if c.env[root.Name] || c.isResultKey(root.Name) {
+		return
+	}
+	ele, ok := c.prog.lookupConst(root.Name)
+	switch {
+	case !ok && (root.Call || c.checkEnv):
+		c.report(UndefinedErr.SetMessagef(root.Name), v)
+	case ok && root.Call:
+		c.call(v, ele)
+	}
+}
+
+// call checks the argument count of a call of a registered function, like variableResolver.Evaluate does.
+func (c *checker) call(v *ast.Var, ele *ValElement) {
+	root := v.Elems[0]
+	fn := reflect.ValueOf(ele.Val)
+	if ele.Val != nil && fn.Type() == TypeOfValuePtr {
+		fn = ele.Val.(*Value).Val
+	}
+	if fn.Kind() != reflect.Func {
+		c.report(VariableNotFunctionErr.SetMessagef(root.Name), v)
+		return
+	}
+	funcT := fn.Type()
+	numIn := funcT.NumIn()
+	ind := 0
+	if numIn > 0 && funcT.In(0) == TypeOfEvaluatorContext {
+		ind = 1
+	}
+	currLen := len(root.Args)
+	if currLen != numIn-ind && !(currLen >= numIn-ind-1 && funcT.IsVariadic()) {
+		argl := "="
+		count := numIn - ind
+		if funcT.IsVariadic() {
+			argl = ">="
+			count--
+		}
+		c.report(ArgumentNotEnoughErr.SetMessagef(root.Name, fmt.Sprintf("%s%v", argl, count), currLen), v)
+	}
+}
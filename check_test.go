@@ -0,0 +1,48 @@
+package mathxf
+
+import (
+	"context"
+	"testing"
+)
+
+// TestCheckLoopVariables checks that loop variables shadow outer names without a report, as they do at run time.
+func TestCheckLoopVariables(t *testing.T) {
+	tests := []string{
+		"for i in [1] { res.a = i }\nfor i in [2] { res.b = i }",
+		"for i in [1] { for i in [2] { res.b = i } }",
+		"val i = 1\nfor i, v in [1] { res.a = i + v }",
+		"for k, v in m { res.a = v }\nfor v in [1] { res.b = v }",
+	}
+	env := map[string]any{"i": 5, "m": map[string]int{"x": 1}}
+	for _, src := range tests {
+		p, err := Compile(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if errs := p.Check(); len(errs) > 0 {
+			t.Errorf("%q: Check() = %v, want no errors", src, errs)
+		}
+		if errs := p.Check("m"); len(errs) > 0 {
+			t.Errorf("%q: Check(\"m\") = %v, want no errors", src, errs)
+		}
+	}
+	// an env variable named like a loop variable
+	p, err := Compile("for i in [1, 2] { res.a = i }")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs := p.Check("i"); len(errs) > 0 {
+		t.Errorf("Check(\"i\") = %v, want no errors", errs)
+	}
+	if _, err := p.Run(context.Background(), env); err != nil {
+		t.Errorf("Run: %v", err)
+	}
+	// a val in the loop body still collides with the loop variable
+	p, err = Compile("for i in [1] { val i = 2 }")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs := p.Check(); len(errs) != 1 || errCode(errs[0]) != VariableAlreadyExistsErr.Code() {
+		t.Errorf("Check() = %v, want VariableAlreadyExistsErr", errs)
+	}
+}
@@ -0,0 +1,446 @@
+package mathxf
+
+import (
+	"reflect"
+
+	"github.com/xslasd/mathxf/ast"
+)
+
+// CheckTypes infers the types of all expressions from schema and reports operators applied to
+// operands of the wrong type, e.g. a string compared to a number or a bool added to a number,
+// conditions that are not bool and arguments that do not match the Go signature of a function.
+// Unknown variables and function results are of any type and never reported, see Check for them.
+func (p *Program) CheckTypes(schema Schema) ErrorList {
+	c := &typeChecker{
+		prog:   p,
+		schema: schema,
+		scopes: []map[string]*Type{{}},
+	}
+	if p.root != nil {
+		c.stmts(p.AST().Stmts)
+	}
+	return c.errs
+}
+
+type typeChecker struct {
+	prog   *Program
+	schema Schema
+	scopes []map[string]*Type // val locals, loop variables and parameters, innermost last
+	funcs  map[string]bool    // functions defined by func
+	errs   ErrorList
+}
+
+func (c *typeChecker) report(ec ECodes, n ast.Node) {
+	c.errs = append(c.errs, c.prog.nodeErr(ec, n))
+}
+
+func (c *typeChecker) lookup(name string) (*Type, bool) {
+	for i := len(c.scopes) - 1; i >= 0; i-- {
+		if t, ok := c.scopes[i][name]; ok {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+func (c *typeChecker) declare(name string, t *Type) {
+	c.scopes[len(c.scopes)-1][name] = t
+}
+
+func (c *typeChecker) stmts(list []ast.Stmt) {
+	for _, s := range list {
+		c.stmt(s)
+	}
+}
+
+func (c *typeChecker) stmt(s ast.Stmt) {
+	switch n := s.(type) {
+	case *ast.ExprStmt:
+		c.expr(n.X)
+	case *ast.AssignStmt:
+		vt := c.expr(n.Value)
+		if tt := c.variable(n.Target); !vt.assignableTo(tt) {
+			c.report(CannotUseTypeErr.SetMessagef(vt, tt, "assignment"), n.Value)
+		}
+	case *ast.ValStmt:
+		t := NumberType
+		if n.Value != nil {
+			t = c.expr(n.Value)
+		}
+		for _, name := range n.Names {
+			c.declare(name.Name, t)
+		}
+	case *ast.IfStmt:
+		c.cond(n.Cond, "condition of "+c.prog.keyword(KeywordIf))
+		c.stmts(n.Body.Stmts)
+		if n.Else != nil {
+			c.stmt(n.Else)
+		}
+	case *ast.BlockStmt:
+		c.stmts(n.Stmts)
+	case *ast.ForStmt:
+		xt := c.expr(n.X)
+		keyType, valType := AnyType, AnyType
+		switch xt.Kind {
+		case KindList:
+			keyType, valType = NumberType, xt.elem()
+		case KindMap:
+			keyType, valType = StringType, xt.elem()
+		case KindAny:
+		default:
+			c.report(CannotUseTypeErr.SetMessagef(xt, "list or map", c.prog.keyword(KeywordFor)), n.X)
+		}
+		c.scopes = append(c.scopes, map[string]*Type{})
+		if n.Value == nil {
+			if xt.Kind == KindList {
+				keyType = valType
+			}
+			c.declare(n.Key.Name, keyType)
+		} else {
+			c.declare(n.Key.Name, keyType)
+			c.declare(n.Value.Name, valType)
+		}
+		c.stmts(n.Body.Stmts)
+		c.scopes = c.scopes[:len(c.scopes)-1]
+	case *ast.FuncStmt:
+		if c.funcs == nil {
+			c.funcs = make(map[string]bool)
+		}
+		c.funcs[n.Name.Name] = true
+		c.declare(n.Name.Name, AnyType)
+		params := make(map[string]*Type, len(n.Params))
+		for _, param := range n.Params {
+			params[param.Name] = AnyType
+		}
+		c.scopes = append(c.scopes, params)
+		c.stmts(n.Body.Stmts)
+		c.scopes = c.scopes[:len(c.scopes)-1]
+	case *ast.ReturnStmt:
+		if n.Result != nil {
+			c.expr(n.Result)
+		}
+	}
+}
+
+// cond checks that x, used as what, is a bool.
+func (c *typeChecker) cond(x ast.Expr, what string) {
+	if t := c.expr(x); !t.assignableTo(BoolType) {
+		c.report(CannotUseTypeErr.SetMessagef(t, BoolType, what), x)
+	}
+}
+
+func (c *typeChecker) expr(x ast.Expr) *Type {
+	switch n := x.(type) {
+	case *ast.BasicLit:
+		switch n.Kind {
+		case ast.String:
+			return StringType
+		case ast.Bool:
+			return BoolType
+		case ast.Money:
+			return MoneyType
+		case ast.Duration:
+			return DurationType
+		}
+		return NumberType
+	case *ast.ArrayLit:
+		var elem *Type
+		for _, e := range n.Elems {
+			t := c.expr(e)
+			if elem == nil {
+				elem = t
+			} else if elem.Kind != t.Kind {
+				elem = AnyType
+			}
+		}
+		return ListOf(elem)
+	case *ast.Var:
+		return c.variable(n)
+	case *ast.UnaryExpr:
+		t := c.expr(n.X)
+		if n.Op == "!" || n.Op == KeywordNot {
+			if !t.assignableTo(BoolType) {
+				c.report(InvalidOperationErr.SetMessagef(c.prog.keyword(n.Op), t), n)
+			}
+			return BoolType
+		}
+		if t.Kind == KindMoney || t.Kind == KindDuration {
+			return t
+		}
+		if !t.assignableTo(NumberType) {
+			c.report(InvalidOperationErr.SetMessagef(c.prog.keyword(n.Op), t), n)
+		}
+		return NumberType
+	case *ast.CondExpr:
+		c.cond(n.Cond, "condition of ?")
+		t1, t2 := c.expr(n.X), c.expr(n.Y)
+		if t1.Kind == t2.Kind {
+			return t1
+		}
+		return AnyType
+	case *ast.BinaryExpr:
+		return c.binary(n)
+	}
+	return AnyType
+}
+
+func (c *typeChecker) binary(n *ast.BinaryExpr) *Type {
+	t1, t2 := c.expr(n.X), c.expr(n.Y)
+	any1, any2 := t1.Kind == KindAny, t2.Kind == KindAny
+	switch n.Op {
+	case "&&", "||", KeywordAnd, KeywordOr:
+		if !t1.assignableTo(BoolType) {
+			c.report(CannotUseTypeErr.SetMessagef(t1, BoolType, "operand of "+c.prog.keyword(n.Op)), n.X)
+		}
+		if !t2.assignableTo(BoolType) {
+			c.report(CannotUseTypeErr.SetMessagef(t2, BoolType, "operand of "+c.prog.keyword(n.Op)), n.Y)
+		}
+		return BoolType
+	case "==", "!=", "<>":
+		if !any1 && !any2 && t1.Kind != t2.Kind && t1.Kind != KindNil && t2.Kind != KindNil {
+			c.report(MismatchedTypesErr.SetMessagef(c.prog.keyword(n.Op), t1, t2), n)
+		}
+		return BoolType
+	case "<", "<=", ">", ">=":
+		switch {
+		case !t1.ordered():
+			c.report(InvalidOperationErr.SetMessagef(c.prog.keyword(n.Op), t1), n.X)
+		case !t2.ordered():
+			c.report(InvalidOperationErr.SetMessagef(c.prog.keyword(n.Op), t2), n.Y)
+		case !any1 && !any2 && t1.Kind != t2.Kind:
+			c.report(MismatchedTypesErr.SetMessagef(c.prog.keyword(n.Op), t1, t2), n)
+		}
+		return BoolType
+	case KeywordIn:
+		switch t2.Kind {
+		case KindList, KindMap, KindString, KindAny:
+		default:
+			c.report(InvalidOperationErr.SetMessagef(c.prog.keyword(n.Op), t2), n.Y)
+		}
+		return BoolType
+	case "+":
+		if t1.Kind == KindString || t2.Kind == KindString {
+			// + concatenates as soon as one operand is a string
+			return StringType
+		}
+		if any1 && any2 {
+			return AnyType
+		}
+	}
+	if t1.Kind == KindMoney || t2.Kind == KindMoney {
+		return c.money(n, t1, t2)
+	}
+	if t1.Kind == KindTime || t2.Kind == KindTime || t1.Kind == KindDuration || t2.Kind == KindDuration {
+		return c.temporal(n, t1, t2)
+	}
+	// arithmetic
+	for _, operand := range []struct {
+		t *Type
+		x ast.Expr
+	}{{t1, n.X}, {t2, n.Y}} {
+		if !operand.t.assignableTo(NumberType) || operand.t.Kind == KindNil {
+			c.report(InvalidOperationErr.SetMessagef(c.prog.keyword(n.Op), operand.t), operand.x)
+		}
+	}
+	return NumberType
+}
+
+// money checks the arithmetic operator n with a Money operand, see evalMoney.
+func (c *typeChecker) money(n *ast.BinaryExpr, t1, t2 *Type) *Type {
+	op := c.prog.keyword(n.Op)
+	number1, number2 := t1.assignableTo(NumberType) && t1.Kind != KindNil, t2.assignableTo(NumberType) && t2.Kind != KindNil
+	money1, money2 := t1.assignableTo(MoneyType) && t1.Kind != KindNil, t2.assignableTo(MoneyType) && t2.Kind != KindNil
+	switch n.Op {
+	case "+", "-":
+		if money1 && money2 {
+			return MoneyType
+		}
+	case "*":
+		if t1.Kind == KindMoney && t2.Kind == KindMoney {
+			c.report(InvalidOperationErr.SetMessagef(op, MoneyType), n)
+			return MoneyType
+		}
+		if money1 && number2 || number1 && money2 {
+			return MoneyType
+		}
+	case "/", "%":
+		if !money1 {
+			c.report(InvalidOperationErr.SetMessagef(op, MoneyType), n)
+			return AnyType
+		}
+		if n.Op == "/" && t2.Kind == KindMoney {
+			return NumberType
+		}
+		if number2 || money2 {
+			return MoneyType
+		}
+	default:
+		c.report(InvalidOperationErr.SetMessagef(op, MoneyType), n)
+		return AnyType
+	}
+	c.report(MismatchedTypesErr.SetMessagef(op, t1, t2), n)
+	return MoneyType
+}
+
+// temporalOps are the arithmetic operators defined on times and durations and their results, see evalTime.
+var temporalOps = []struct {
+	op     string
+	x, y   Kind
+	result *Type
+}{
+	{"+", KindTime, KindDuration, TimeType}, {"+", KindDuration, KindTime, TimeType},
+	{"+", KindDuration, KindDuration, DurationType}, {"-", KindTime, KindDuration, TimeType},
+	{"-", KindTime, KindTime, DurationType}, {"-", KindDuration, KindDuration, DurationType},
+	{"*", KindDuration, KindNumber, DurationType}, {"*", KindNumber, KindDuration, DurationType},
+	{"/", KindDuration, KindNumber, DurationType}, {"/", KindDuration, KindDuration, NumberType},
+	{"%", KindDuration, KindDuration, DurationType},
+}
+
+// temporal checks the arithmetic operator n with a time or duration operand. An operand of any type
+// matches every kind, the result is any if the matching operators have different results.
+func (c *typeChecker) temporal(n *ast.BinaryExpr, t1, t2 *Type) *Type {
+	var res *Type
+	defined := false
+	for _, o := range temporalOps {
+		defined = defined || o.op == n.Op
+		if o.op == n.Op && (t1.Kind == KindAny || t1.Kind == o.x) && (t2.Kind == KindAny || t2.Kind == o.y) {
+			if res != nil && res != o.result {
+				return AnyType
+			}
+			res = o.result
+		}
+	}
+	if res != nil {
+		return res
+	}
+	op := c.prog.keyword(n.Op)
+	if defined && t1.Kind != t2.Kind && t1.Kind != KindAny && t2.Kind != KindAny {
+		c.report(MismatchedTypesErr.SetMessagef(op, t1, t2), n)
+	} else if t1.Kind != KindTime && t1.Kind != KindDuration {
+		c.report(InvalidOperationErr.SetMessagef(op, t2), n)
+	} else {
+		c.report(InvalidOperationErr.SetMessagef(op, t1), n)
+	}
+	return AnyType
+}
+
+// variable resolves the type of the path v.
+func (c *typeChecker) variable(v *ast.Var) *Type {
+	t := c.root(v)
+	for _, e := range v.Elems[1:] {
+		if e.Index != nil {
+			it := c.expr(e.Index)
+			switch t.Kind {
+			case KindList:
+				if !it.assignableTo(NumberType) {
+					c.report(CannotUseTypeErr.SetMessagef(it, NumberType, "index"), e.Index)
+				}
+				t = t.elem()
+			case KindMap:
+				if t.Fields != nil {
+					t = AnyType
+				} else {
+					t = t.elem()
+				}
+			case KindAny:
+			default:
+				c.report(NoFieldErr.SetMessagef(t, "[...]"), v)
+				return AnyType
+			}
+		} else {
+			switch {
+			case t.Kind == KindAny:
+			case t.Fields != nil:
+				ft, ok := t.Fields[e.Name]
+				if !ok {
+					c.report(NoFieldErr.SetMessagef(t, e.Name), v)
+					return AnyType
+				}
+				t = ft
+			case t.Kind == KindMap:
+				t = t.elem()
+			default:
+				c.report(NoFieldErr.SetMessagef(t, e.Name), v)
+				return AnyType
+			}
+		}
+		for _, arg := range e.Args {
+			c.expr(arg)
+		}
+		if e.Call {
+			// methods of Go values are not described by Type
+			t = AnyType
+		}
+	}
+	return t
+}
+
+// root returns the type of the first element of v, for a call the type of the result.
+func (c *typeChecker) root(v *ast.Var) *Type {
+	root := v.Elems[0]
+	if t, ok := c.lookup(root.Name); ok {
+		for _, arg := range root.Args {
+			c.expr(arg)
+		}
+		if root.Call && c.funcs[root.Name] {
+			return AnyType
+		}
+		return t
+	}
+	if t, ok := c.schema[root.Name]; ok && !root.Call {
+		return t
+	}
+	ele, ok := c.prog.lookupConst(root.Name)
+	if !ok || ele.Val == nil {
+		for _, arg := range root.Args {
+			c.expr(arg)
+		}
+		return AnyType
+	}
+	if !root.Call {
+		return typeOfValue(AsValue(ele.Val))
+	}
+	fn := reflect.ValueOf(ele.Val)
+	if fn.Kind() != reflect.Func {
+		return AnyType
+	}
+	funcT := fn.Type()
+	numIn := funcT.NumIn()
+	ind := 0
+	if numIn > 0 && funcT.In(0) == TypeOfEvaluatorContext {
+		ind = 1
+	}
+	first := AnyType
+	for i, arg := range root.Args {
+		at := c.expr(arg)
+		if i == 0 {
+			first = at
+		}
+		inds := ind + i
+		var param reflect.Type
+		switch {
+		case funcT.IsVariadic() && inds >= numIn-1:
+			param = funcT.In(numIn - 1).Elem()
+		case inds < numIn:
+			param = funcT.In(inds)
+		default:
+			continue
+		}
+		if param == TypeOfValuePtr || param.Kind() == reflect.Interface {
+			continue
+		}
+		if pt := TypeOf(param); !at.assignableTo(pt) {
+			c.report(CannotUseTypeErr.SetMessagef(at, pt, "argument to "+root.Name), arg)
+		}
+	}
+	if t, ok := ele.results[first.Kind]; ok {
+		return t
+	}
+	if t, ok := ele.results[KindAny]; ok {
+		return t
+	}
+	if funcT.NumOut() == 0 {
+		return NilType
+	}
+	return TypeOf(funcT.Out(0))
+}
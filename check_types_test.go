@@ -0,0 +1,41 @@
+package mathxf
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestBuiltinsDeclareResultTypes checks that no function of DefConst is registered without a result type.
+func TestBuiltinsDeclareResultTypes(t *testing.T) {
+	for name, ele := range DefConst {
+		if ele.IsFunc && ele.results[KindAny] == nil {
+			t.Errorf("builtin %s has no result type, register it with newBuiltin", name)
+		}
+	}
+}
+
+func TestCheckTypesOfBuiltins(t *testing.T) {
+	tests := []struct {
+		cond string
+		want string // the type in the error message of the if condition
+	}{
+		{`sqrt(2)`, "number"},
+		{`upper("a")`, "string"},
+		{`sum(1 CNY, 2 CNY)`, "money"},
+		{`sum(1, 2)`, "number"},
+		{`split(1 CNY, 2)`, "list[money]"},
+		{`split("a,b", ",")`, "list[string]"},
+		{`date("2026-10-18")`, "time"},
+		{`range(0, 3)`, "list[number]"},
+	}
+	for _, tt := range tests {
+		p, err := Compile("if " + tt.cond + " { res.x = 1 }")
+		if err != nil {
+			t.Fatal(err)
+		}
+		errs := p.CheckTypes(nil)
+		if len(errs) != 1 || errCode(errs[0]) != CannotUseTypeErr.Code() || !strings.Contains(errs[0].Error(), tt.want) {
+			t.Errorf("if %s: CheckTypes() = %v, want %s used as bool", tt.cond, errs, tt.want)
+		}
+	}
+}
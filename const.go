@@ -2,39 +2,106 @@ package mathxf
 
 import (
 	"math"
+	"strings"
+	"time"
 
 	"github.com/shopspring/decimal"
 )
 
 var DefConst = map[string]*ValElement{
-	"e":  NewConstValElement(decimal.NewFromFloat(math.E), false),
-	"pi": NewConstValElement(decimal.NewFromFloat(math.Pi), false),
+	"e":  NewConstValElement(mathConst(math.E), false),
+	"pi": NewConstValElement(mathConst(math.Pi), false),
 
-	"sum":   NewConstValElement(defSum, true),
-	"avg":   NewConstValElement(defAvg, true),
-	"max":   NewConstValElement(defMax, true),
-	"min":   NewConstValElement(defMin, true),
-	"cbrt":  NewConstValElement(defCbrt, true),
-	"sqrt":  NewConstValElement(defSqrt, true),
-	"round": NewConstValElement(defRound, true),
-	"floor": NewConstValElement(defFloor, true),
-	"ceil":  NewConstValElement(defCeil, true),
-	"abs":   NewConstValElement(defAbs, true),
-	"sin":   NewConstValElement(defSin, true),
-	"cos":   NewConstValElement(defCos, true),
-	"tan":   NewConstValElement(defTan, true),
-	"asin":  NewConstValElement(defAsin, true),
-	"acos":  NewConstValElement(defAcos, true),
-	"atan":  NewConstValElement(defAtan, true),
-	"atan2": NewConstValElement(defAtan2, true),
-	"sinh":  NewConstValElement(defSinh, true),
-	"cosh":  NewConstValElement(defCosh, true),
-	"tanh":  NewConstValElement(defTanh, true),
-	"asinh": NewConstValElement(defAsinh, true),
+	"sum":   newBuiltin(defSum, NumberType, moneyResult),
+	"avg":   newBuiltin(defAvg, NumberType),
+	"max":   newBuiltin(defMax, NumberType, moneyResult),
+	"min":   newBuiltin(defMin, NumberType, moneyResult),
+	"cbrt":  newBuiltin(mathFunc("cbrt", Numeric.Cbrt, math.Cbrt), NumberType),
+	"sqrt":  newBuiltin(mathFunc("sqrt", Numeric.Sqrt, math.Sqrt), NumberType),
+	"round": newBuiltin(defRound, NumberType, moneyResult),
+	"floor": newBuiltin(defFloor, NumberType),
+	"ceil":  newBuiltin(defCeil, NumberType),
+	"abs":   newBuiltin(defAbs, NumberType, moneyResult),
+	"sin":   newBuiltin(mathFunc("sin", Numeric.Sin, math.Sin), NumberType),
+	"cos":   newBuiltin(mathFunc("cos", Numeric.Cos, math.Cos), NumberType),
+	"tan":   newBuiltin(mathFunc("tan", Numeric.Tan, math.Tan), NumberType),
+	"asin":  newBuiltin(mathFunc("asin", Numeric.Asin, math.Asin), NumberType),
+	"acos":  newBuiltin(mathFunc("acos", Numeric.Acos, math.Acos), NumberType),
+	"atan":  newBuiltin(mathFunc("atan", Numeric.Atan, math.Atan), NumberType),
+	"atan2": newBuiltin(mathFunc2("atan2", Numeric.Atan2, math.Atan2), NumberType),
+	"sinh":  newBuiltin(mathFunc("sinh", Numeric.Sinh, math.Sinh), NumberType),
+	"cosh":  newBuiltin(mathFunc("cosh", Numeric.Cosh, math.Cosh), NumberType),
+	"tanh":  newBuiltin(mathFunc("tanh", Numeric.Tanh, math.Tanh), NumberType),
+	"asinh": newBuiltin(mathFunc("asinh", Numeric.Asinh, math.Asinh), NumberType),
+	"acosh": newBuiltin(mathFunc("acosh", Numeric.Acosh, math.Acosh), NumberType),
+	"atanh": newBuiltin(mathFunc("atanh", Numeric.Atanh, math.Atanh), NumberType),
+	"exp":   newBuiltin(mathFunc("exp", Numeric.Exp, math.Exp), NumberType),
+	"ln":    newBuiltin(mathFunc("ln", Numeric.Ln, math.Log), NumberType),
+	"log10": newBuiltin(mathFunc("log10", Numeric.Log10, math.Log10), NumberType),
+	"log":   newBuiltin(mathFunc2("log", Numeric.Log, logFloat), NumberType),
+	"pow":   newBuiltin(mathFunc2("pow", Numeric.Pow, math.Pow), NumberType),
+	"range": newBuiltin(defRange, ListOf(NumberType)),
+
+	"money":        newBuiltin(defMoney, MoneyType),
+	"amount":       newBuiltin(defAmount, NumberType),
+	"currency":     newBuiltin(defCurrency, StringType),
+	"allocate":     newBuiltin(defAllocate, ListOf(MoneyType)),
+	"split":        newBuiltin(defSplit, ListOf(AnyType), map[Kind]*Type{KindMoney: ListOf(MoneyType), KindString: ListOf(StringType)}),
+	"format_money": newBuiltin(defFormatMoney, StringType),
+
+	"now":      newBuiltin(defNow, TimeType),
+	"date":     newBuiltin(defDate, TimeType),
+	"duration": newBuiltin(defDuration, DurationType),
+	"year":     newBuiltin(timeField("year", time.Time.Year), NumberType),
+	"month":    newBuiltin(timeField("month", func(t time.Time) int { return int(t.Month()) }), NumberType),
+	"day":      newBuiltin(timeField("day", time.Time.Day), NumberType),
+	"hour":     newBuiltin(timeField("hour", time.Time.Hour), NumberType),
+	"minute":   newBuiltin(timeField("minute", time.Time.Minute), NumberType),
+	"second":   newBuiltin(timeField("second", time.Time.Second), NumberType),
+	"weekday":  newBuiltin(timeField("weekday", func(t time.Time) int { return int(t.Weekday()) }), NumberType),
+
+	"start_of_day":   newBuiltin(timeFunc("start_of_day", startOfDay), TimeType),
+	"start_of_month": newBuiltin(timeFunc("start_of_month", startOfMonth), TimeType),
+	"days_between":   newBuiltin(defDaysBetween, NumberType),
+	"add_months":     newBuiltin(defAddMonths, TimeType),
+
+	"len":         newBuiltin(defLen, NumberType),
+	"upper":       newBuiltin(stringFunc("upper", strings.ToUpper), StringType),
+	"lower":       newBuiltin(stringFunc("lower", strings.ToLower), StringType),
+	"trim":        newBuiltin(defTrim, StringType),
+	"substr":      newBuiltin(defSubstr, StringType),
+	"replace":     newBuiltin(defReplace, StringType),
+	"join":        newBuiltin(defJoin, StringType),
+	"starts_with": newBuiltin(stringPredicate("starts_with", strings.HasPrefix), BoolType),
+	"ends_with":   newBuiltin(stringPredicate("ends_with", strings.HasSuffix), BoolType),
+	"index_of":    newBuiltin(defIndexOf, NumberType),
+	"pad_left":    newBuiltin(defPadLeft, StringType),
+	"format":      newBuiltin(defFormat, StringType),
+	"to_number":   newBuiltin(defToNumber, NumberType),
+	"to_string":   newBuiltin(defToString, StringType),
+}
+
+// moneyResult is the overload of the builtins that return Money for a first argument of Money.
+var moneyResult = map[Kind]*Type{KindMoney: MoneyType}
+
+// newBuiltin returns the element of a function of DefConst. Its functions return *Value, so the
+// result type is declared for CheckTypes, overloads override it by the kind of the first argument.
+func newBuiltin(fn any, result *Type, overloads ...map[Kind]*Type) *ValElement {
+	ele := NewConstValElement(fn, true)
+	ele.results = map[Kind]*Type{KindAny: result}
+	for _, overload := range overloads {
+		for kind, t := range overload {
+			ele.results[kind] = t
+		}
+	}
+	return ele
 }
 
 func defSum(ctx *EvaluatorContext, args ...*Value) (*Value, error) {
 	alen := len(args)
+	if alen > 0 && args[0].IsMoney() {
+		return reduceMoney("sum", args, decimal.Decimal.Add)
+	}
 	if ctx.IsHighPrecision {
 		var sumV decimal.Decimal
 		for _, item := range args {
@@ -101,6 +168,11 @@ func defMax(ctx *EvaluatorContext, args ...*Value) (*Value, error) {
 	if alen == 0 {
 		return nil, ArgumentNotEnoughErr.SetMessagef("max", ">=1", 0)
 	}
+	if args[0].IsMoney() {
+		return reduceMoney("max", args, func(d1, d2 decimal.Decimal) decimal.Decimal {
+			return decimal.Max(d1, d2)
+		})
+	}
 	if ctx.IsHighPrecision {
 		var rest []decimal.Decimal
 		for ind, item := range args {
@@ -136,6 +208,11 @@ func defMin(ctx *EvaluatorContext, args ...*Value) (*Value, error) {
 	if alen == 0 {
 		return nil, ArgumentNotNumberErr.SetMessagef("min")
 	}
+	if args[0].IsMoney() {
+		return reduceMoney("min", args, func(d1, d2 decimal.Decimal) decimal.Decimal {
+			return decimal.Min(d1, d2)
+		})
+	}
 	if ctx.IsHighPrecision {
 		var rest []decimal.Decimal
 		for ind, item := range args {
@@ -165,28 +242,31 @@ func defMin(ctx *EvaluatorContext, args ...*Value) (*Value, error) {
 	}
 	return AsValue(minV), nil
 }
-func defCbrt(arg *Value) (*Value, error) {
-	if !arg.IsNumber() {
-		return nil, ArgumentNotNumberErr.SetMessagef("cbrt")
-	}
-	return AsValue(math.Cbrt(arg.Float())), nil
-}
-func defSqrt(arg *Value) (*Value, error) {
-	if !arg.IsNumber() {
-		return nil, ArgumentNotNumberErr.SetMessagef("cbrt")
-	}
-	return AsValue(math.Sqrt(arg.Float())), nil
-}
 
-func defRound(ctx *EvaluatorContext, arg *Value, n *Value) (*Value, error) {
-	if !arg.IsNumber() || !n.IsNumber() {
+// defRound round(x, n) or round(x, n, "half_even"), the default mode is the rounding of Numeric.
+// x may be Money, whose amount is rounded.
+func defRound(ctx *EvaluatorContext, arg *Value, n *Value, args ...*Value) (*Value, error) {
+	if !arg.IsNumber() && !arg.IsMoney() || !n.IsNumber() {
 		return nil, ArgumentNotNumberErr.SetMessagef("round")
 	}
+	mode := ctx.Numeric.Rounding
+	if len(args) > 1 {
+		return nil, ArgumentNotEnoughErr.SetMessagef("round", "2-3", len(args)+2)
+	}
+	if len(args) == 1 {
+		var ok bool
+		if mode, ok = ParseRoundingMode(args[0].String()); !ok {
+			return nil, ArgumentInvalidErr.SetMessagef("round", 3).SetCol(2)
+		}
+	}
+	if m, ok := arg.Interface().(Money); ok {
+		m.Amount = mode.Round(m.Amount, int32(n.Integer()))
+		return AsValue(m), nil
+	}
 	if ctx.IsHighPrecision {
-		return AsValue(arg.Decimal().Round(int32(n.Integer()))), nil
+		return AsValue(mode.Round(arg.Decimal(), int32(n.Integer()))), nil
 	}
-	p := math.Pow10(n.Integer())
-	return AsValue(math.Round(arg.Float()*p) / p), nil
+	return AsValue(mode.RoundFloat(arg.Float(), n.Integer())), nil
 }
 func defFloor(ctx *EvaluatorContext, arg *Value) (*Value, error) {
 	if !arg.IsNumber() {
@@ -207,6 +287,10 @@ func defCeil(ctx *EvaluatorContext, arg *Value) (*Value, error) {
 	return AsValue(math.Ceil(arg.Float())), nil
 }
 func defAbs(ctx *EvaluatorContext, arg *Value) (*Value, error) {
+	if m, ok := arg.Interface().(Money); ok {
+		m.Amount = m.Amount.Abs()
+		return AsValue(m), nil
+	}
 	if !arg.IsNumber() {
 		return nil, ArgumentNotNumberErr.SetMessagef("abs")
 	}
@@ -215,78 +299,96 @@ func defAbs(ctx *EvaluatorContext, arg *Value) (*Value, error) {
 	}
 	return AsValue(math.Abs(arg.Float())), nil
 }
-func defSin(ctx *EvaluatorContext, arg *Value) (*Value, error) {
-	if !arg.IsNumber() {
-		return nil, ArgumentNotNumberErr.SetMessagef("sin")
-	}
-	if ctx.IsHighPrecision {
-		return AsValue(arg.Decimal().Sin()), nil
-	}
-	return AsValue(math.Sin(arg.Float())), nil
-}
-func defCos(ctx *EvaluatorContext, arg *Value) (*Value, error) {
-	if !arg.IsNumber() {
-		return nil, ArgumentNotNumberErr.SetMessagef("cos")
-	}
-	if ctx.IsHighPrecision {
-		return AsValue(arg.Decimal().Cos()), nil
-	}
-	return AsValue(math.Cos(arg.Float())), nil
-}
-func defTan(ctx *EvaluatorContext, arg *Value) (*Value, error) {
-	if !arg.IsNumber() {
-		return nil, ArgumentNotNumberErr.SetMessagef("tan")
-	}
-	if ctx.IsHighPrecision {
-		return AsValue(arg.Decimal().Tan()), nil
-	}
-	return AsValue(math.Tan(arg.Float())), nil
-}
-func defAsin(arg *Value) (*Value, error) {
-	if !arg.IsNumber() {
-		return nil, ArgumentNotNumberErr.SetMessagef("asin")
+
+// mathConst is pi or e. Its value is computed with the MathScale of a run in high-precision
+// mode and is the float64 otherwise.
+type mathConst float64
+
+func (c mathConst) value(ctx *EvaluatorContext) any {
+	if !ctx.IsHighPrecision {
+		return float64(c)
 	}
-	return AsValue(math.Asin(arg.Float())), nil
-}
-func defAcos(arg *Value) (*Value, error) {
-	if !arg.IsNumber() {
-		return nil, ArgumentNotNumberErr.SetMessagef("acos")
+	switch float64(c) {
+	case math.Pi:
+		return ctx.Numeric.Pi()
+	case math.E:
+		return ctx.Numeric.E()
 	}
-	return AsValue(math.Acos(arg.Float())), nil
+	return decimal.NewFromFloat(float64(c))
 }
-func defAtan(arg *Value) (*Value, error) {
-	if !arg.IsNumber() {
-		return nil, ArgumentNotNumberErr.SetMessagef("atan")
-	}
-	return AsValue(math.Atan(arg.Float())), nil
-}
-func defAtan2(arg1, arg2 *Value) (*Value, error) {
-	if !arg1.IsNumber() || !arg2.IsNumber() {
-		return nil, ArgumentNotNumberErr.SetMessagef("atan2")
+
+// mathFunc returns a function of one number, computed by dec with the Numeric settings in
+// high-precision mode and by f otherwise.
+func mathFunc(name string, dec func(Numeric, decimal.Decimal) (decimal.Decimal, error), f func(float64) float64) func(*EvaluatorContext, *Value) (*Value, error) {
+	return func(ctx *EvaluatorContext, arg *Value) (*Value, error) {
+		if !arg.IsNumber() {
+			return nil, ArgumentNotNumberErr.SetMessagef(name, arg.Interface())
+		}
+		if ctx.IsHighPrecision {
+			res, err := dec(ctx.Numeric, arg.Decimal())
+			if err != nil {
+				return nil, err
+			}
+			return AsValue(res), nil
+		}
+		return AsValue(f(arg.Float())), nil
 	}
-	return AsValue(math.Atan2(arg1.Float(), arg2.Float())), nil
 }
-func defSinh(arg *Value) (*Value, error) {
-	if !arg.IsNumber() {
-		return nil, ArgumentNotNumberErr.SetMessagef("sinh")
+
+// mathFunc2 is mathFunc for functions of two numbers.
+func mathFunc2(name string, dec func(Numeric, decimal.Decimal, decimal.Decimal) (decimal.Decimal, error), f func(float64, float64) float64) func(*EvaluatorContext, *Value, *Value) (*Value, error) {
+	return func(ctx *EvaluatorContext, arg1, arg2 *Value) (*Value, error) {
+		if !arg1.IsNumber() {
+			return nil, ArgumentNotNumberErr.SetMessagef(name, arg1.Interface())
+		}
+		if !arg2.IsNumber() {
+			return nil, ArgumentNotNumberErr.SetMessagef(name, arg2.Interface()).SetCol(1)
+		}
+		if ctx.IsHighPrecision {
+			res, err := dec(ctx.Numeric, arg1.Decimal(), arg2.Decimal())
+			if err != nil {
+				return nil, err
+			}
+			return AsValue(res), nil
+		}
+		return AsValue(f(arg1.Float(), arg2.Float())), nil
 	}
-	return AsValue(math.Sinh(arg.Float())), nil
 }
-func defCosh(arg *Value) (*Value, error) {
-	if !arg.IsNumber() {
-		return nil, ArgumentNotNumberErr.SetMessagef("cosh")
-	}
-	return AsValue(math.Cosh(arg.Float())), nil
+
+func logFloat(x, base float64) float64 {
+	return math.Log(x) / math.Log(base)
 }
-func defTanh(arg *Value) (*Value, error) {
-	if !arg.IsNumber() {
-		return nil, ArgumentNotNumberErr.SetMessagef("tanh")
+
+// defRange range(end) range(start, end) range(start, end, step) returns the integers in [start, end).
+func defRange(ctx *EvaluatorContext, args ...*Value) (*Value, error) {
+	for _, item := range args {
+		if !item.IsNumber() {
+			return nil, ArgumentNotNumberErr.SetMessagef("range", item.Interface())
+		}
 	}
-	return AsValue(math.Tanh(arg.Float())), nil
-}
-func defAsinh(arg *Value) (*Value, error) {
-	if !arg.IsNumber() {
-		return nil, ArgumentNotNumberErr.SetMessagef("asinh")
+	start, step := 0, 1
+	var end int
+	switch len(args) {
+	case 1:
+		end = args[0].Integer()
+	case 2:
+		start, end = args[0].Integer(), args[1].Integer()
+	case 3:
+		start, end, step = args[0].Integer(), args[1].Integer(), args[2].Integer()
+		if step == 0 {
+			return nil, ArgumentInvalidErr.SetMessagef("range", 2)
+		}
+	default:
+		return nil, ArgumentNotEnoughErr.SetMessagef("range", "1-3", len(args))
+	}
+	res := make([]int, 0)
+	for i := start; (step > 0 && i < end) || (step < 0 && i > end); i += step {
+		if len(res)%4096 == 0 {
+			if err := ctx.step(); err != nil {
+				return nil, err
+			}
+		}
+		res = append(res, i)
 	}
-	return AsValue(math.Asinh(arg.Float())), nil
+	return AsValue(res), nil
 }
@@ -0,0 +1,360 @@
+package mathxf
+
+import (
+	"context"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/shopspring/decimal"
+)
+
+// durationUnits are the units of duration literals like 3d or 1h30m and of duration(s), longer names first.
+var durationUnits = []struct {
+	name string
+	unit time.Duration
+}{
+	{"ns", time.Nanosecond}, {"us", time.Microsecond}, {"µs", time.Microsecond}, {"ms", time.Millisecond},
+	{"s", time.Second}, {"m", time.Minute}, {"h", time.Hour}, {"d", 24 * time.Hour}, {"w", 7 * 24 * time.Hour},
+}
+
+// dateLayouts are the layouts accepted by date(s).
+var dateLayouts = []string{"2006-01-02", "2006-01-02 15:04", "2006-01-02 15:04:05", "2006-01-02T15:04:05", time.RFC3339}
+
+// scanDuration returns the duration at the start of s and the length of its text, e.g. 36h and 5 of
+// "1d12h + x". A duration is an optional sign and numbers with units, n is 0 if s does not start with one.
+func scanDuration(s string) (d time.Duration, n int) {
+	i := 0
+	if i < len(s) && (s[i] == '-' || s[i] == '+') {
+		i++
+	}
+	total, parts := decimal.Zero, 0
+	for i < len(s) && (isDigit(s[i]) || s[i] == '.') {
+		j := i
+		for j < len(s) && isDigit(s[j]) {
+			j++
+		}
+		if j < len(s) && s[j] == '.' {
+			j++
+			for j < len(s) && isDigit(s[j]) {
+				j++
+			}
+		}
+		num, err := decimal.NewFromString(s[i:j])
+		if err != nil {
+			return 0, 0
+		}
+		var unit time.Duration
+		for _, u := range durationUnits {
+			if strings.HasPrefix(s[j:], u.name) {
+				unit = u.unit
+				j += len(u.name)
+				break
+			}
+		}
+		if unit == 0 {
+			return 0, 0
+		}
+		total = total.Add(num.Mul(decimal.NewFromInt(int64(unit))))
+		i, parts = j, parts+1
+	}
+	if parts == 0 {
+		return 0, 0
+	}
+	if r, _ := utf8.DecodeRuneInString(s[i:]); isAlphaNumeric(r) {
+		return 0, 0
+	}
+	if s[0] == '-' {
+		total = total.Neg()
+	}
+	ns := total.Round(0)
+	if !ns.BigInt().IsInt64() {
+		return 0, 0
+	}
+	return time.Duration(ns.IntPart()), i
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+type nowKey struct{}
+type locationKey struct{}
+
+// ContextWithNow returns a copy of ctx that makes now() return now during Program.Run, e.g. in tests.
+func ContextWithNow(ctx context.Context, now time.Time) context.Context {
+	return context.WithValue(ctx, nowKey{}, now)
+}
+
+// ContextWithLocation returns a copy of ctx that makes Program.Run use the time zone loc.
+func ContextWithLocation(ctx context.Context, loc *time.Location) context.Context {
+	return context.WithValue(ctx, locationKey{}, loc)
+}
+
+// location returns the time zone of the run, time.Local if none is set.
+func (ctx *EvaluatorContext) location() *time.Location {
+	if ctx.Location == nil {
+		return time.Local
+	}
+	return ctx.Location
+}
+
+// now returns the current time in the time zone of the run.
+func (ctx *EvaluatorContext) now() time.Time {
+	if ctx.Now == nil {
+		return time.Now().In(ctx.location())
+	}
+	return ctx.Now().In(ctx.location())
+}
+
+// temporal reports whether v1 or v2 is a time or a duration.
+func temporal(v1, v2 *Value) bool {
+	return v1.IsTime() || v2.IsTime() || v1.IsDuration() || v2.IsDuration()
+}
+
+// evalTime applies the arithmetic op to v1 and v2, at least one of them is a time or a duration.
+// A duration is added to or subtracted from a time, two times subtract to a duration, and durations
+// are added, multiplied or divided by a number. A duration divided by a duration is their ratio.
+func evalTime(ctx *EvaluatorContext, op *Token, v1, v2 *Value, pos2 *Token) (*Value, error) {
+	t1, isT1 := v1.Interface().(time.Time)
+	t2, isT2 := v2.Interface().(time.Time)
+	d1, isD1 := v1.Interface().(time.Duration)
+	d2, isD2 := v2.Interface().(time.Duration)
+	number1 := !isD1 && !v1.IsNil() && v1.IsNumber()
+	number2 := !isD2 && !v2.IsNil() && v2.IsNumber()
+	switch op.typ {
+	case TokenAdd:
+		switch {
+		case isT1 && isD2:
+			return AsValue(t1.Add(d2)), nil
+		case isD1 && isT2:
+			return AsValue(t2.Add(d1)), nil
+		case isD1 && isD2:
+			return durationValue(op, decimal.NewFromInt(int64(d1)).Add(decimal.NewFromInt(int64(d2))))
+		}
+	case TokenSub:
+		switch {
+		case isT1 && isD2:
+			return AsValue(t1.Add(-d2)), nil
+		case isT1 && isT2:
+			return AsValue(t1.Sub(t2)), nil
+		case isD1 && isD2:
+			return durationValue(op, decimal.NewFromInt(int64(d1)).Sub(decimal.NewFromInt(int64(d2))))
+		}
+	case TokenMul:
+		switch {
+		case isD1 && number2:
+			return durationValue(op, decimal.NewFromInt(int64(d1)).Mul(v2.Decimal()))
+		case number1 && isD2:
+			return durationValue(op, decimal.NewFromInt(int64(d2)).Mul(v1.Decimal()))
+		}
+	case TokenDiv, TokenMod:
+		if isD1 && (isD2 || number2 && op.typ == TokenDiv) {
+			divisor := v2.Decimal()
+			if divisor.IsZero() {
+				return nil, DivideZeroErr.SetToken(pos2)
+			}
+			switch {
+			case op.typ == TokenMod:
+				return AsValue(d1 % d2), nil
+			case number2:
+				return durationValue(op, decimal.NewFromInt(int64(d1)).DivRound(divisor, 0))
+			case ctx.IsHighPrecision:
+				return AsValue(ctx.Numeric.Div(decimal.NewFromInt(int64(d1)), divisor)), nil
+			}
+			return AsValue(float64(d1) / float64(d2)), nil
+		}
+	}
+	if typ1, typ2 := typeOfValue(v1), typeOfValue(v2); typ1.Kind != typ2.Kind {
+		return nil, MismatchedTypesErr.SetMessagef(op.val, typ1, typ2).SetToken(op)
+	}
+	return nil, InvalidOperationErr.SetMessagef(op.val, typeOfValue(v1)).SetToken(op)
+}
+
+// durationValue returns ns nanoseconds rounded to a whole nanosecond as a duration.
+func durationValue(op *Token, ns decimal.Decimal) (*Value, error) {
+	ns = ns.Round(0)
+	if !ns.BigInt().IsInt64() {
+		return nil, NumberOverflowErr.SetMessagef(op.val, ns.String()+"ns").SetToken(op)
+	}
+	return AsValue(time.Duration(ns.IntPart())), nil
+}
+
+// compareTime compares v1 and v2, at least one of them is a time or a duration. Times are only
+// ordered against times and durations against durations.
+func compareTime(op *Token, v1, v2 *Value) (*Value, error) {
+	typ1, typ2 := typeOfValue(v1), typeOfValue(v2)
+	switch op.typ {
+	case TokenEquals, TokenNotEquals:
+		if typ1.Kind != typ2.Kind && typ1.Kind != KindNil && typ2.Kind != KindNil {
+			return nil, MismatchedTypesErr.SetMessagef(op.val, typ1, typ2).SetToken(op)
+		}
+		equal := typ1.Kind == typ2.Kind && v1.EqualValueTo(v2)
+		return AsValue(equal == (op.typ == TokenEquals)), nil
+	}
+	if typ1.Kind != typ2.Kind {
+		return nil, MismatchedTypesErr.SetMessagef(op.val, typ1, typ2).SetToken(op)
+	}
+	var c int
+	if typ1.Kind == KindTime {
+		t1, t2 := v1.Time(), v2.Time()
+		switch {
+		case t1.Before(t2):
+			c = -1
+		case t1.After(t2):
+			c = 1
+		}
+	} else {
+		d1, d2 := v1.Duration(), v2.Duration()
+		switch {
+		case d1 < d2:
+			c = -1
+		case d1 > d2:
+			c = 1
+		}
+	}
+	return orderResult(op, c)
+}
+
+// orderResult returns the result of the comparison op of two values, c is -1, 0 or 1 like decimal.Cmp.
+func orderResult(op *Token, c int) (*Value, error) {
+	switch op.typ {
+	case TokenLess:
+		return AsValue(c < 0), nil
+	case TokenLessEquals:
+		return AsValue(c <= 0), nil
+	case TokenGreat:
+		return AsValue(c > 0), nil
+	case TokenGreatEquals:
+		return AsValue(c >= 0), nil
+	}
+	return nil, UnknownOperatorErr.SetMessagef(op.val).SetToken(op)
+}
+
+// timeArg returns the argument v of the function name as a time in the time zone of the run, col is
+// the index of the argument.
+func timeArg(ctx *EvaluatorContext, name string, v *Value, col int) (time.Time, error) {
+	t, ok := v.Interface().(time.Time)
+	if !ok {
+		return time.Time{}, CannotUseTypeErr.SetMessagef(typeOfValue(v), TimeType, "argument to "+name).SetCol(col)
+	}
+	return t.In(ctx.location()), nil
+}
+
+// timeField returns a function that returns a field of a time in the time zone of the run, e.g. year(t).
+func timeField(name string, field func(time.Time) int) func(*EvaluatorContext, *Value) (*Value, error) {
+	return func(ctx *EvaluatorContext, arg *Value) (*Value, error) {
+		t, err := timeArg(ctx, name, arg, 0)
+		if err != nil {
+			return nil, err
+		}
+		return AsValue(field(t)), nil
+	}
+}
+
+// timeFunc returns a function that maps a time in the time zone of the run to another time, e.g. start_of_month(t).
+func timeFunc(name string, fn func(time.Time) time.Time) func(*EvaluatorContext, *Value) (*Value, error) {
+	return func(ctx *EvaluatorContext, arg *Value) (*Value, error) {
+		t, err := timeArg(ctx, name, arg, 0)
+		if err != nil {
+			return nil, err
+		}
+		return AsValue(fn(t)), nil
+	}
+}
+
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+func startOfMonth(t time.Time) time.Time {
+	y, m, _ := t.Date()
+	return time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+}
+
+// addMonths adds n months to t, the day is limited to the last day of the month, e.g. 01-31 + 1 is 02-28.
+func addMonths(t time.Time, n int) time.Time {
+	y, m, d := t.Date()
+	first := time.Date(y, m+time.Month(n), 1, 0, 0, 0, 0, t.Location())
+	if last := first.AddDate(0, 1, -1).Day(); d > last {
+		d = last
+	}
+	return time.Date(first.Year(), first.Month(), d, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+}
+
+// defNow now() returns the current time, see WithNow and ContextWithNow.
+func defNow(ctx *EvaluatorContext) (*Value, error) {
+	return AsValue(ctx.now()), nil
+}
+
+// defDate date("2026-10-18"), date("2026-10-18 08:30:00") or date(2026, 10, 18, 8, 30, 0) returns a time
+// in the time zone of the run. Strings with an offset like RFC 3339 keep their instant.
+func defDate(ctx *EvaluatorContext, args ...*Value) (*Value, error) {
+	loc := ctx.location()
+	if len(args) == 1 {
+		if t, ok := args[0].Interface().(time.Time); ok {
+			return AsValue(t.In(loc)), nil
+		}
+		s := strings.TrimSpace(args[0].String())
+		for _, layout := range dateLayouts {
+			if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+				return AsValue(t.In(loc)), nil
+			}
+		}
+		return nil, ArgumentInvalidErr.SetMessagef("date", 1)
+	}
+	if len(args) < 3 || len(args) > 6 {
+		return nil, ArgumentNotEnoughErr.SetMessagef("date", "1 or 3-6", len(args))
+	}
+	var parts [6]int
+	for i, arg := range args {
+		if !arg.IsNumber() {
+			return nil, ArgumentNotNumberErr.SetMessagef("date", arg.Interface()).SetCol(i)
+		}
+		parts[i] = arg.Integer()
+	}
+	return AsValue(time.Date(parts[0], time.Month(parts[1]), parts[2], parts[3], parts[4], parts[5], 0, loc)), nil
+}
+
+// defDuration duration("72h") or duration("1d12h") returns a duration, the units are those of duration literals.
+func defDuration(arg *Value) (*Value, error) {
+	if d, ok := arg.Interface().(time.Duration); ok {
+		return AsValue(d), nil
+	}
+	s := strings.TrimSpace(arg.String())
+	d, n := scanDuration(s)
+	if n == 0 || n != len(s) {
+		return nil, ArgumentInvalidErr.SetMessagef("duration", 1)
+	}
+	return AsValue(d), nil
+}
+
+// defDaysBetween days_between(t1, t2) returns the number of calendar days from t1 to t2 in the
+// time zone of the run, negative if t2 is before t1.
+func defDaysBetween(ctx *EvaluatorContext, arg1, arg2 *Value) (*Value, error) {
+	t1, err := timeArg(ctx, "days_between", arg1, 0)
+	if err != nil {
+		return nil, err
+	}
+	t2, err := timeArg(ctx, "days_between", arg2, 1)
+	if err != nil {
+		return nil, err
+	}
+	y1, m1, d1 := t1.Date()
+	y2, m2, d2 := t2.Date()
+	days := time.Date(y2, m2, d2, 0, 0, 0, 0, time.UTC).Sub(time.Date(y1, m1, d1, 0, 0, 0, 0, time.UTC)) / (24 * time.Hour)
+	return AsValue(int(days)), nil
+}
+
+// defAddMonths add_months(t, n) adds n months to t, see addMonths.
+func defAddMonths(ctx *EvaluatorContext, arg, n *Value) (*Value, error) {
+	t, err := timeArg(ctx, "add_months", arg, 0)
+	if err != nil {
+		return nil, err
+	}
+	if !n.IsNumber() || n.IsDuration() {
+		return nil, ArgumentNotNumberErr.SetMessagef("add_months", n.Interface()).SetCol(1)
+	}
+	return AsValue(addMonths(t, n.Integer())), nil
+}
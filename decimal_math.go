@@ -0,0 +1,585 @@
+package mathxf
+
+import (
+	"math"
+	"math/big"
+	"sync"
+
+	"github.com/shopspring/decimal"
+)
+
+// The functions of this file compute transcendental functions of decimals to a number of digits
+// after the decimal point, intermediate results carry guardDigits more.
+const guardDigits = 10
+
+// maxExpDigits bounds the integer digits of exp, larger results are reported as an overflow.
+const maxExpDigits = 10000
+
+var (
+	decOne   = decimal.NewFromInt(1)
+	decTwo   = decimal.NewFromInt(2)
+	decHalf  = decimal.New(5, -1)
+	decTenth = decimal.New(1, -1)
+	log10E   = decimal.RequireFromString("0.43429448190325182765")
+)
+
+// mathConsts caches pi, e and ln(10) by precision.
+var mathConsts = struct {
+	sync.Mutex
+	pi, e, ln10 map[int32]decimal.Decimal
+}{pi: map[int32]decimal.Decimal{}, e: map[int32]decimal.Decimal{}, ln10: map[int32]decimal.Decimal{}}
+
+// Pi returns pi with MathScale digits.
+func (n Numeric) Pi() decimal.Decimal {
+	return n.result(piDecimal(n.precision()))
+}
+
+// E returns e with MathScale digits.
+func (n Numeric) E() decimal.Decimal {
+	return n.result(eDecimal(n.precision()))
+}
+
+// Sqrt returns the square root of d with MathScale digits.
+func (n Numeric) Sqrt(d decimal.Decimal) (decimal.Decimal, error) {
+	if d.Sign() < 0 {
+		return decimal.Zero, DomainErr.SetMessagef("sqrt", d)
+	}
+	return n.result(rootDecimal(d, 2, n.precision())), nil
+}
+
+// Cbrt returns the cube root of d with MathScale digits.
+func (n Numeric) Cbrt(d decimal.Decimal) (decimal.Decimal, error) {
+	return n.result(rootDecimal(d, 3, n.precision())), nil
+}
+
+// Exp returns e raised to the power d with MathScale digits.
+func (n Numeric) Exp(d decimal.Decimal) (decimal.Decimal, error) {
+	res, ok := expDecimal(d, n.precision())
+	if !ok {
+		return decimal.Zero, NumberOverflowErr.SetMessagef("exp", d)
+	}
+	return n.result(res), nil
+}
+
+// Ln returns the natural logarithm of d with MathScale digits.
+func (n Numeric) Ln(d decimal.Decimal) (decimal.Decimal, error) {
+	if d.Sign() <= 0 {
+		return decimal.Zero, DomainErr.SetMessagef("ln", d)
+	}
+	return n.result(lnDecimal(d, n.precision())), nil
+}
+
+// Log10 returns the decimal logarithm of d with MathScale digits.
+func (n Numeric) Log10(d decimal.Decimal) (decimal.Decimal, error) {
+	return n.Log(d, decimal.NewFromInt(10))
+}
+
+// Log returns the logarithm of d to base with MathScale digits.
+func (n Numeric) Log(d, base decimal.Decimal) (decimal.Decimal, error) {
+	if d.Sign() <= 0 {
+		return decimal.Zero, DomainErr.SetMessagef("log", d)
+	}
+	if base.Sign() <= 0 || base.Equal(decOne) {
+		return decimal.Zero, DomainErr.SetMessagef("log base", base).SetCol(1)
+	}
+	if e, ok := exactLog(d, base); ok {
+		return e, nil
+	}
+	wp := n.precision()
+	lb := lnDecimal(base, wp)
+	if k := numDigits(lb); k < 0 {
+		// the errors grow like 1/ln(base)^2 for a base close to 1
+		wp -= 2 * k
+		lb = lnDecimal(base, wp)
+	}
+	return n.result(lnDecimal(d, wp).DivRound(lb, wp)), nil
+}
+
+// Pow returns d1 raised to the power d2. An integer exponent is computed by repeated squaring,
+// rounded to MathScale digits, or with Div for a negative one, the result is exact if it has no
+// more digits. A fractional exponent is computed as exp(d2 * ln(d1)) with MathScale digits.
+func (n Numeric) Pow(d1, d2 decimal.Decimal) (decimal.Decimal, error) {
+	integer := d2.Equal(d2.Truncate(0))
+	switch {
+	case d1.IsZero():
+		if d2.Sign() < 0 {
+			return decimal.Zero, DivideZeroErr
+		}
+		if d2.IsZero() {
+			return decOne, nil
+		}
+		return decimal.Zero, nil
+	case d1.Sign() < 0 && !integer:
+		return decimal.Zero, DomainErr.SetMessagef("^", d1.String()+" ^ "+d2.String())
+	}
+	// the integer digits of the result, the relative error of the exponent becomes the relative error of the result
+	estimate := d2.InexactFloat64() * math.Log10(d1.Abs().InexactFloat64())
+	if math.IsInf(estimate, 0) || math.IsNaN(estimate) || estimate > maxExpDigits {
+		return decimal.Zero, NumberOverflowErr.SetMessagef("^", d1.String()+" ^ "+d2.String())
+	}
+	if integer && d2.Abs().BigInt().IsInt64() {
+		scale := n.MathScale
+		if d2.Sign() < 0 {
+			scale = n.DivScale
+		}
+		// the significant digits of the result down to the last digit of scale
+		sig := scale + int32(math.Floor(estimate)) + 2
+		if sig <= 0 {
+			return decimal.Zero, nil
+		}
+		res := powInt(d1, d2.Abs().IntPart(), sig)
+		if d2.Sign() < 0 {
+			return n.Div(decOne, res), nil
+		}
+		return n.result(res), nil
+	}
+	wp := n.precision() + max32(0, int32(estimate)+1)
+	res, ok := expDecimal(d2.Mul(lnDecimal(d1.Abs(), wp)), wp)
+	if !ok {
+		return decimal.Zero, NumberOverflowErr.SetMessagef("^", d1.String()+" ^ "+d2.String())
+	}
+	if d1.Sign() < 0 && d2.BigInt().Bit(0) == 1 {
+		res = res.Neg()
+	}
+	return n.result(res), nil
+}
+
+// Sin returns the sine of d radians with MathScale digits.
+func (n Numeric) Sin(d decimal.Decimal) (decimal.Decimal, error) {
+	sin, _ := sinCosDecimal(d, n.precision())
+	return n.result(sin), nil
+}
+
+// Cos returns the cosine of d radians with MathScale digits.
+func (n Numeric) Cos(d decimal.Decimal) (decimal.Decimal, error) {
+	_, cos := sinCosDecimal(d, n.precision())
+	return n.result(cos), nil
+}
+
+// Tan returns the tangent of d radians with MathScale digits.
+func (n Numeric) Tan(d decimal.Decimal) (decimal.Decimal, error) {
+	wp := n.precision()
+	sin, cos := sinCosDecimal(d, wp)
+	if cos.IsZero() {
+		return decimal.Zero, DomainErr.SetMessagef("tan", d)
+	}
+	if k := numDigits(cos); k < 0 {
+		// the quotient grows like 1/cos
+		wp -= 2 * k
+		sin, cos = sinCosDecimal(d, wp)
+	}
+	return n.result(sin.DivRound(cos, wp)), nil
+}
+
+// Asin returns the arcsine of d in radians with MathScale digits.
+func (n Numeric) Asin(d decimal.Decimal) (decimal.Decimal, error) {
+	if d.Abs().GreaterThan(decOne) {
+		return decimal.Zero, DomainErr.SetMessagef("asin", d)
+	}
+	return n.result(asinDecimal(d, n.precision())), nil
+}
+
+// Acos returns the arccosine of d in radians with MathScale digits.
+func (n Numeric) Acos(d decimal.Decimal) (decimal.Decimal, error) {
+	if d.Abs().GreaterThan(decOne) {
+		return decimal.Zero, DomainErr.SetMessagef("acos", d)
+	}
+	wp := n.precision()
+	return n.result(piDecimal(wp).Mul(decHalf).Sub(asinDecimal(d, wp))), nil
+}
+
+// Atan returns the arctangent of d in radians with MathScale digits.
+func (n Numeric) Atan(d decimal.Decimal) (decimal.Decimal, error) {
+	return n.result(atanDecimal(d, n.precision())), nil
+}
+
+// Atan2 returns the arctangent of y/x in radians with MathScale digits, the signs of both
+// determine the quadrant like math.Atan2.
+func (n Numeric) Atan2(y, x decimal.Decimal) (decimal.Decimal, error) {
+	wp := n.precision()
+	switch x.Sign() {
+	case 1:
+		wp += max32(0, -numDigits(x))
+		return n.result(atanDecimal(y.DivRound(x, wp+guardDigits), wp)), nil
+	case 0:
+		halfPi := piDecimal(wp).Mul(decHalf)
+		switch y.Sign() {
+		case 1:
+			return n.result(halfPi), nil
+		case -1:
+			return n.result(halfPi.Neg()), nil
+		}
+		return decimal.Zero, nil
+	}
+	wp += max32(0, -numDigits(x))
+	res := atanDecimal(y.DivRound(x, wp+guardDigits), wp)
+	if y.Sign() < 0 {
+		return n.result(res.Sub(piDecimal(wp))), nil
+	}
+	return n.result(res.Add(piDecimal(wp))), nil
+}
+
+// Sinh returns the hyperbolic sine of d with MathScale digits.
+func (n Numeric) Sinh(d decimal.Decimal) (decimal.Decimal, error) {
+	ep, em, ok := expPair(d, n.precision())
+	if !ok {
+		return decimal.Zero, NumberOverflowErr.SetMessagef("sinh", d)
+	}
+	return n.result(ep.Sub(em).Mul(decHalf)), nil
+}
+
+// Cosh returns the hyperbolic cosine of d with MathScale digits.
+func (n Numeric) Cosh(d decimal.Decimal) (decimal.Decimal, error) {
+	ep, em, ok := expPair(d, n.precision())
+	if !ok {
+		return decimal.Zero, NumberOverflowErr.SetMessagef("cosh", d)
+	}
+	return n.result(ep.Add(em).Mul(decHalf)), nil
+}
+
+// Tanh returns the hyperbolic tangent of d with MathScale digits.
+func (n Numeric) Tanh(d decimal.Decimal) (decimal.Decimal, error) {
+	wp := n.precision()
+	ad := d.Abs()
+	// 1 - tanh(x) < 2e^(-2x), which is below 10^-wp for x > 1.16wp
+	if ad.GreaterThan(decimal.NewFromInt(int64(wp)*6/5 + 1)) {
+		return decimal.NewFromInt(int64(d.Sign())), nil
+	}
+	e2, _ := expDecimal(ad.Mul(decTwo), wp)
+	res := e2.Sub(decOne).DivRound(e2.Add(decOne), wp)
+	if d.Sign() < 0 {
+		res = res.Neg()
+	}
+	return n.result(res), nil
+}
+
+// Asinh returns the inverse hyperbolic sine of d with MathScale digits.
+func (n Numeric) Asinh(d decimal.Decimal) (decimal.Decimal, error) {
+	wp := n.precision()
+	ad := d.Abs()
+	res := lnDecimal(ad.Add(rootDecimal(ad.Mul(ad).Add(decOne), 2, wp)), wp)
+	if d.Sign() < 0 {
+		res = res.Neg()
+	}
+	return n.result(res), nil
+}
+
+// Acosh returns the inverse hyperbolic cosine of d with MathScale digits.
+func (n Numeric) Acosh(d decimal.Decimal) (decimal.Decimal, error) {
+	if d.LessThan(decOne) {
+		return decimal.Zero, DomainErr.SetMessagef("acosh", d)
+	}
+	wp := n.precision()
+	return n.result(lnDecimal(d.Add(rootDecimal(d.Mul(d).Sub(decOne), 2, wp)), wp)), nil
+}
+
+// Atanh returns the inverse hyperbolic tangent of d with MathScale digits.
+func (n Numeric) Atanh(d decimal.Decimal) (decimal.Decimal, error) {
+	if d.Abs().GreaterThanOrEqual(decOne) {
+		return decimal.Zero, DomainErr.SetMessagef("atanh", d)
+	}
+	wp := n.precision()
+	// the quotient grows like 1/(1-|d|)
+	wp += max32(0, -numDigits(decOne.Sub(d.Abs())))
+	q := decOne.Add(d).DivRound(decOne.Sub(d), wp+guardDigits)
+	return n.result(lnDecimal(q, wp).Mul(decHalf)), nil
+}
+
+// precision is the working precision of MathScale.
+func (n Numeric) precision() int32 {
+	return n.MathScale + guardDigits
+}
+
+// result rounds a result of a math function to MathScale digits.
+func (n Numeric) result(d decimal.Decimal) decimal.Decimal {
+	return n.scale(d, n.MathScale)
+}
+
+// numDigits returns the number of integer digits of d, e.g. 3 for 123.4 and -2 for 0.0012.
+func numDigits(d decimal.Decimal) int32 {
+	if d.IsZero() {
+		return 0
+	}
+	return int32(len(new(big.Int).Abs(d.Coefficient()).String())) + d.Exponent()
+}
+
+func unit(p int32) decimal.Decimal {
+	return decimal.New(1, -p)
+}
+
+func max32(a, b int32) int32 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// exactLog returns the integer logarithm of d to base if there is one, e.g. log10(1000).
+func exactLog(d, base decimal.Decimal) (decimal.Decimal, bool) {
+	if !base.Equal(base.Truncate(0)) || base.LessThan(decTwo) {
+		return decimal.Zero, false
+	}
+	var e int64
+	x := d
+	for x.GreaterThan(decOne) && x.Equal(x.Truncate(0)) {
+		q, r := x.QuoRem(base, 0)
+		if !r.IsZero() {
+			return decimal.Zero, false
+		}
+		x = q
+		e++
+	}
+	return decimal.NewFromInt(e), x.Equal(decOne)
+}
+
+// rootDecimal returns the k-th root of d with p digits by Newton's method, d must not be
+// negative if k is even.
+func rootDecimal(d decimal.Decimal, k int64, p int32) decimal.Decimal {
+	if d.IsZero() {
+		return decimal.Zero
+	}
+	ad := d.Abs()
+	// start with the float root of the mantissa
+	digits := numDigits(ad)
+	h := digits / int32(k)
+	if digits < 0 && digits%int32(k) != 0 {
+		h--
+	}
+	guess := math.Pow(ad.Shift(-h*int32(k)).InexactFloat64(), 1/float64(k))
+	y := decimal.NewFromFloat(guess).Shift(h)
+	wp := p + guardDigits
+	kd := decimal.NewFromInt(k)
+	for i := 0; i < 100; i++ {
+		yk := y
+		for j := int64(2); j < k; j++ {
+			yk = yk.Mul(y).Truncate(wp)
+		}
+		next := y.Mul(kd.Sub(decOne)).Add(ad.DivRound(yk, wp)).DivRound(kd, wp)
+		done := next.Sub(y).Abs().LessThanOrEqual(unit(wp - 1))
+		y = next
+		if done {
+			break
+		}
+	}
+	if d.Sign() < 0 {
+		return y.Neg()
+	}
+	return y
+}
+
+// powInt returns d^k for k > 0 with sig significant digits. Every squaring at most doubles the
+// relative error, so the products keep the digits of k more.
+func powInt(d decimal.Decimal, k int64, sig int32) decimal.Decimal {
+	wp := sig + numDigits(decimal.NewFromInt(k)) + guardDigits
+	res, base := decOne, d
+	for {
+		if k&1 == 1 {
+			res = roundSig(res.Mul(base), wp)
+		}
+		if k >>= 1; k == 0 {
+			return res
+		}
+		base = roundSig(base.Mul(base), wp)
+	}
+}
+
+// roundSig rounds d to p significant digits.
+func roundSig(d decimal.Decimal, p int32) decimal.Decimal {
+	return d.Round(p - numDigits(d))
+}
+
+// expDecimal returns e^d with p digits, ok is false if the result has more than maxExpDigits digits.
+func expDecimal(d decimal.Decimal, p int32) (decimal.Decimal, bool) {
+	if d.Sign() < 0 {
+		y, ok := expDecimal(d.Neg(), p)
+		if !ok {
+			// far below the precision
+			return decimal.Zero, true
+		}
+		return decOne.DivRound(y, p), true
+	}
+	digits := d.Mul(log10E).IntPart() + 1
+	if digits > maxExpDigits {
+		return decimal.Zero, false
+	}
+	// e^d = (e^(d/2^k))^(2^k) with d/2^k < 1/256, every squaring doubles the relative error
+	k := 8 + d.BigInt().BitLen()
+	wp := p + int32(digits) + int32(k)/3 + guardDigits
+	r := d.DivRound(decimal.NewFromBigInt(new(big.Int).Lsh(big.NewInt(1), uint(k)), 0), wp)
+	sum, term := decOne, decOne
+	for i := int64(1); ; i++ {
+		term = term.Mul(r).DivRound(decimal.NewFromInt(i), wp)
+		if term.IsZero() {
+			break
+		}
+		sum = sum.Add(term)
+	}
+	for ; k > 0; k-- {
+		sum = sum.Mul(sum).Truncate(wp)
+	}
+	return sum, true
+}
+
+// expPair returns e^d and e^-d with p digits.
+func expPair(d decimal.Decimal, p int32) (decimal.Decimal, decimal.Decimal, bool) {
+	ep, ok := expDecimal(d.Abs(), p)
+	if !ok {
+		return decimal.Zero, decimal.Zero, false
+	}
+	em := decOne.DivRound(ep, p)
+	if d.Sign() < 0 {
+		ep, em = em, ep
+	}
+	return ep, em, true
+}
+
+// lnDecimal returns the natural logarithm of d > 0 with p digits, ln(m * 10^e) = ln(m) + e*ln(10).
+func lnDecimal(d decimal.Decimal, p int32) decimal.Decimal {
+	e := numDigits(d) - 1
+	wp := p + numDigits(decimal.NewFromInt(int64(e)))
+	res := lnMantissa(d.Shift(-e), wp)
+	if e != 0 {
+		res = res.Add(ln10(wp).Mul(decimal.NewFromInt(int64(e))))
+	}
+	return res
+}
+
+// lnMantissa returns ln(m) for 1 <= m <= 10 with p digits by Halley's method on exp.
+func lnMantissa(m decimal.Decimal, p int32) decimal.Decimal {
+	if m.Equal(decOne) {
+		return decimal.Zero
+	}
+	wp := p + guardDigits
+	y := decimal.NewFromFloat(math.Log(m.InexactFloat64()))
+	for i := 0; i < 100; i++ {
+		ey, _ := expDecimal(y, wp)
+		delta := m.Sub(ey).Mul(decTwo).DivRound(m.Add(ey), wp)
+		y = y.Add(delta)
+		if delta.Abs().LessThanOrEqual(unit(wp - 2)) {
+			break
+		}
+	}
+	return y
+}
+
+func ln10(p int32) decimal.Decimal {
+	mathConsts.Lock()
+	defer mathConsts.Unlock()
+	if v, ok := mathConsts.ln10[p]; ok {
+		return v
+	}
+	v := lnMantissa(decimal.NewFromInt(10), p)
+	mathConsts.ln10[p] = v
+	return v
+}
+
+// eDecimal returns e with p digits.
+func eDecimal(p int32) decimal.Decimal {
+	mathConsts.Lock()
+	defer mathConsts.Unlock()
+	if v, ok := mathConsts.e[p]; ok {
+		return v
+	}
+	v, _ := expDecimal(decOne, p)
+	mathConsts.e[p] = v
+	return v
+}
+
+// piDecimal returns pi with p digits by Machin's formula pi = 16 atan(1/5) - 4 atan(1/239).
+func piDecimal(p int32) decimal.Decimal {
+	mathConsts.Lock()
+	defer mathConsts.Unlock()
+	if v, ok := mathConsts.pi[p]; ok {
+		return v
+	}
+	wp := p + guardDigits
+	v := atanInv(5, wp).Mul(decimal.NewFromInt(16)).Sub(atanInv(239, wp).Mul(decimal.NewFromInt(4)))
+	mathConsts.pi[p] = v
+	return v
+}
+
+// atanInv returns atan(1/x) with p digits.
+func atanInv(x int64, p int32) decimal.Decimal {
+	xd := decimal.NewFromInt(x)
+	x2 := xd.Mul(xd)
+	pow := decOne.DivRound(xd, p)
+	sum := pow
+	for i := int64(1); ; i++ {
+		pow = pow.DivRound(x2, p)
+		if pow.IsZero() {
+			break
+		}
+		term := pow.DivRound(decimal.NewFromInt(2*i+1), p)
+		if i%2 == 1 {
+			sum = sum.Sub(term)
+		} else {
+			sum = sum.Add(term)
+		}
+	}
+	return sum
+}
+
+// atanDecimal returns atan(d) with p digits. Arguments above 1 use atan(d) = pi/2 - atan(1/d),
+// atan(d) = 2 atan(d / (1 + sqrt(1 + d^2))) then reduces d below 0.1 for the series.
+func atanDecimal(d decimal.Decimal, p int32) decimal.Decimal {
+	if d.Sign() < 0 {
+		return atanDecimal(d.Neg(), p).Neg()
+	}
+	wp := p + guardDigits
+	if d.GreaterThan(decOne) {
+		return piDecimal(wp).Mul(decHalf).Sub(atanDecimal(decOne.DivRound(d, wp), wp))
+	}
+	halvings := int64(0)
+	for d.GreaterThan(decTenth) {
+		d = d.DivRound(decOne.Add(rootDecimal(decOne.Add(d.Mul(d)), 2, wp)), wp)
+		halvings++
+	}
+	d2 := d.Mul(d).Truncate(wp)
+	pow, sum := d, d
+	for i := int64(1); ; i++ {
+		pow = pow.Mul(d2).Truncate(wp)
+		if pow.IsZero() {
+			break
+		}
+		term := pow.DivRound(decimal.NewFromInt(2*i+1), wp)
+		if i%2 == 1 {
+			sum = sum.Sub(term)
+		} else {
+			sum = sum.Add(term)
+		}
+	}
+	return sum.Mul(decimal.NewFromInt(1 << halvings))
+}
+
+// asinDecimal returns asin(d) = atan(d / sqrt(1 - d^2)) for |d| <= 1 with p digits.
+func asinDecimal(d decimal.Decimal, p int32) decimal.Decimal {
+	if d.Abs().Equal(decOne) {
+		return piDecimal(p).Mul(decHalf).Mul(decimal.NewFromInt(int64(d.Sign())))
+	}
+	wp := p + guardDigits
+	s := rootDecimal(decOne.Sub(d.Mul(d)), 2, wp)
+	// the quotient grows like 1/s
+	wp += max32(0, -numDigits(s))
+	s = rootDecimal(decOne.Sub(d.Mul(d)), 2, wp)
+	return atanDecimal(d.DivRound(s, wp), p)
+}
+
+// sinCosDecimal returns sin(d) and cos(d) with p digits, d is reduced to [-pi, pi] first.
+func sinCosDecimal(d decimal.Decimal, p int32) (decimal.Decimal, decimal.Decimal) {
+	wp := p + guardDigits
+	twoPi := piDecimal(wp + max32(0, numDigits(d))).Mul(decTwo)
+	r := d.Sub(d.DivRound(twoPi, 0).Mul(twoPi)).Truncate(wp)
+	r2 := r.Mul(r).Truncate(wp)
+	sin, cos := r, decOne
+	sinTerm, cosTerm := r, decOne
+	for i := int64(1); ; i++ {
+		sinTerm = sinTerm.Mul(r2).DivRound(decimal.NewFromInt(-(2*i)*(2*i+1)), wp)
+		cosTerm = cosTerm.Mul(r2).DivRound(decimal.NewFromInt(-(2*i-1)*(2*i)), wp)
+		if sinTerm.IsZero() && cosTerm.IsZero() {
+			break
+		}
+		sin = sin.Add(sinTerm)
+		cos = cos.Add(cosTerm)
+	}
+	return sin, cos
+}
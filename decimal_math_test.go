@@ -0,0 +1,103 @@
+package mathxf
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// TestMathConstsAtMathScale checks that pi and e have the digits of MathScale, not those of a float64.
+func TestMathConstsAtMathScale(t *testing.T) {
+	for _, scale := range []int32{16, 40} {
+		limit := decimal.New(1, -scale+1)
+		for _, expr := range []string{`sin(pi)`, `exp(1) - e`, `cos(pi) + 1`, `ln(e) - 1`} {
+			for _, res := range runSrc(t, "res.x = "+expr, nil, WithMathScale(scale)) {
+				if got := res[DefResultKey]["x"].Decimal(); got.Abs().GreaterThan(limit) {
+					t.Errorf("%s at MathScale %d = %s, want 0", expr, scale, got)
+				}
+			}
+		}
+	}
+	res := runSrc(t, "res.pi = pi\nres.e = e", nil, WithMathScale(40))
+	for _, r := range res {
+		if got, want := r[DefResultKey]["pi"].String(), "3.1415926535897932384626433832795028841972"; got != want {
+			t.Errorf("pi = %s, want %s", got, want)
+		}
+		if got, want := r[DefResultKey]["e"].String(), "2.7182818284590452353602874713526624977572"; got != want {
+			t.Errorf("e = %s, want %s", got, want)
+		}
+	}
+	// pi folded at compile time is recomputed for the Numeric of a run
+	for _, bytecode := range []bool{false, true} {
+		p, err := Compile("res.x = sin(pi)", WithBytecode(bytecode))
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := DefNumeric
+		n.MathScale = 40
+		r, err := p.Run(ContextWithNumeric(context.Background(), n), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := r[DefResultKey]["x"].Decimal(); !got.IsZero() {
+			t.Errorf("sin(pi) with a MathScale of 40 per run = %s, want 0", got)
+		}
+	}
+	// the float mode keeps the float64 constants
+	for _, r := range runSrc(t, "res.pi = pi", nil, WithHighPrecision(false)) {
+		if got := r[DefResultKey]["pi"].Float(); got != 3.141592653589793 {
+			t.Errorf("pi = %v in float mode", got)
+		}
+	}
+}
+
+func TestPowIntegerExponent(t *testing.T) {
+	tests := []struct {
+		d1, d2 string
+		want   string
+	}{
+		{"1.05", "2", "1.1025"},
+		{"2", "100", "1267650600228229401496703205376"},
+		{"-2", "3", "-8"},
+		{"0", "0", "1"},
+		{"2", "-2", "0.25"},
+		{"3", "-1", "0.3333333333333333"},
+		{"0.1", "20", "0"}, // below MathScale
+		{"0.5", "1000000000", "0"},
+		{"-1", "100000000000000000000001", "-1"},
+	}
+	for _, tt := range tests {
+		got, err := DefNumeric.Pow(decimal.RequireFromString(tt.d1), decimal.RequireFromString(tt.d2))
+		if err != nil || got.String() != tt.want {
+			t.Errorf("%s ^ %s = %s, %v, want %s", tt.d1, tt.d2, got, err, tt.want)
+		}
+	}
+	// a long exponent is rounded on the way instead of computing the exact 700000000 digits
+	d := decimal.RequireFromString("1.0000001")
+	got, err := DefNumeric.Pow(d, decimal.NewFromInt(100000000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := DefNumeric
+	n.MathScale = 30
+	ln, _ := n.Ln(d)
+	want, _ := n.Exp(ln.Mul(decimal.NewFromInt(100000000)))
+	if want = want.Round(DefNumeric.MathScale); !got.Equal(want) {
+		t.Errorf("1.0000001 ^ 100000000 = %s, want %s", got, want)
+	}
+	if _, err := DefNumeric.Pow(decimal.NewFromInt(2), decimal.NewFromInt(1000000000)); Cause(err).Code() != NumberOverflowErr.Code() {
+		t.Errorf("2 ^ 1000000000: err = %v, want NumberOverflowErr", err)
+	}
+	// the same inputs in a rule return before the timeout
+	for _, src := range []string{"res.x = 1.0000001 ^ 100000000", "res.x = 2 ^ 1000000000"} {
+		p, err := Compile(src, WithTimeout(time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := p.Run(context.Background(), nil); err != nil && errCode(err) != NumberOverflowErr.Code() {
+			t.Errorf("%s: %v", src, err)
+		}
+	}
+}
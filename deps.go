@@ -0,0 +1,176 @@
+package mathxf
+
+import (
+	"sort"
+
+	"github.com/xslasd/mathxf/ast"
+)
+
+// Deps lists what a program reads, writes, calls and declares, see Program.Deps.
+// All lists are sorted and free of duplicates.
+type Deps struct {
+	Env    []string // env variables the program reads or writes, the roots of Reads and of env Writes
+	Reads  []string // env paths the program reads, e.g. user.level, items[...].price
+	Writes []string // paths the program writes: result keys such as res.aa or res.res1, and env fields
+	Funcs  []string // functions called, registered or not
+	Consts []string // constants read
+	Locals []string // names declared by val
+}
+
+// Deps analyses the program without running it. Loop variables, function parameters,
+// functions defined by func and val locals are never reported as env variables.
+func (p *Program) Deps() *Deps {
+	d := &depsCollector{
+		prog:   p,
+		scopes: []map[string]bool{{}},
+		sets:   make(map[*[]string]map[string]bool),
+		res:    new(Deps),
+	}
+	d.stmts(p.AST().Stmts)
+	for _, list := range []*[]string{&d.res.Env, &d.res.Reads, &d.res.Writes, &d.res.Funcs, &d.res.Consts, &d.res.Locals} {
+		sort.Strings(*list)
+	}
+	return d.res
+}
+
+type depsCollector struct {
+	prog   *Program
+	scopes []map[string]bool // names declared in the template, innermost last
+	sets   map[*[]string]map[string]bool
+	res    *Deps
+}
+
+func (d *depsCollector) add(list *[]string, s string) {
+	set := d.sets[list]
+	if set == nil {
+		set = make(map[string]bool)
+		d.sets[list] = set
+	}
+	if !set[s] {
+		set[s] = true
+		*list = append(*list, s)
+	}
+}
+
+func (d *depsCollector) declare(name string) {
+	d.scopes[len(d.scopes)-1][name] = true
+}
+
+func (d *depsCollector) isLocal(name string) bool {
+	for i := len(d.scopes) - 1; i >= 0; i-- {
+		if d.scopes[i][name] {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *depsCollector) isResultKey(name string) bool {
+	if name == d.prog.defResultKey {
+		return true
+	}
+	for _, key := range d.prog.resultKeys {
+		if key == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *depsCollector) stmts(list []ast.Stmt) {
+	for _, s := range list {
+		d.stmt(s)
+	}
+}
+
+func (d *depsCollector) stmt(s ast.Stmt) {
+	switch n := s.(type) {
+	case *ast.ExprStmt:
+		d.expr(n.X)
+		d.add(&d.res.Writes, d.prog.defResultKey+"."+n.Name)
+	case *ast.AssignStmt:
+		d.expr(n.Value)
+		for _, e := range n.Target.Elems {
+			d.expr(e.Index)
+		}
+		root := n.Target.Root()
+		if !d.isLocal(root) {
+			if !d.isResultKey(root) {
+				d.add(&d.res.Env, root)
+			}
+			d.add(&d.res.Writes, n.Target.String())
+		}
+	case *ast.ValStmt:
+		d.expr(n.Value)
+		for _, name := range n.Names {
+			d.declare(name.Name)
+			d.add(&d.res.Locals, name.Name)
+		}
+	case *ast.IfStmt:
+		d.expr(n.Cond)
+		d.stmts(n.Body.Stmts)
+		if n.Else != nil {
+			d.stmt(n.Else)
+		}
+	case *ast.BlockStmt:
+		d.stmts(n.Stmts)
+	case *ast.ForStmt:
+		d.expr(n.X)
+		d.scopes = append(d.scopes, map[string]bool{n.Key.Name: true})
+		if n.Value != nil {
+			d.declare(n.Value.Name)
+		}
+		d.stmts(n.Body.Stmts)
+		d.scopes = d.scopes[:len(d.scopes)-1]
+	case *ast.FuncStmt:
+		d.declare(n.Name.Name)
+		params := make(map[string]bool, len(n.Params))
+		for _, param := range n.Params {
+			params[param.Name] = true
+		}
+		d.scopes = append(d.scopes, params)
+		d.stmts(n.Body.Stmts)
+		d.scopes = d.scopes[:len(d.scopes)-1]
+	case *ast.ReturnStmt:
+		d.expr(n.Result)
+	}
+}
+
+func (d *depsCollector) expr(x ast.Expr) {
+	if x == nil {
+		return
+	}
+	ast.Inspect(x, func(node ast.Node) bool {
+		if v, ok := node.(*ast.Var); ok {
+			d.variable(v)
+		}
+		return true
+	})
+}
+
+func (d *depsCollector) variable(v *ast.Var) {
+	root := v.Root()
+	switch {
+	case d.isLocal(root), d.isResultKey(root):
+	case v.Elems[0].Call:
+		d.add(&d.res.Funcs, root)
+	default:
+		if _, ok := d.prog.lookupConst(root); ok {
+			d.add(&d.res.Consts, root)
+			return
+		}
+		d.add(&d.res.Env, root)
+		d.add(&d.res.Reads, v.String())
+	}
+}
+
+// Missing returns the env variables of d that env does not provide, e.g. to validate env before Run.
+func (d *Deps) Missing(env map[string]any) []string {
+	var res []string
+	for _, name := range d.Env {
+		if _, ok := env[name]; !ok {
+			res = append(res, name)
+		}
+	}
+	return res
+}
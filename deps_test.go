@@ -0,0 +1,57 @@
+package mathxf
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDeps(t *testing.T) {
+	tests := []struct {
+		src  string
+		opts []Option
+		want string
+	}{
+		{
+			src:  "if TotalOrders > 5 && user.level >= 2 {\n  res.aa = max(OrderTotalAmount * 0.3, 0)\n}",
+			want: "env=[OrderTotalAmount TotalOrders user] reads=[OrderTotalAmount TotalOrders user.level] writes=[res.aa] funcs=[max] consts=[] locals=[]",
+		},
+		{
+			src:  "val total = 0\nfor i, item in items {\n  total = total + item.price * i\n}\nres.total = total * rate",
+			opts: []Option{WithFuncOrConst("rate", 0.9)},
+			want: "env=[items] reads=[items] writes=[res.total] funcs=[] consts=[rate] locals=[total]",
+		},
+		{
+			src:  "func f(a) { return a + k }\nuser.score = f(base)\nf(1)",
+			want: "env=[base k user] reads=[base k] writes=[res.res3 user.score] funcs=[] consts=[] locals=[]",
+		},
+		{
+			src:  "res.x = items[0].price + items[i].qty",
+			want: "env=[i items] reads=[i items[...].price items[...].qty] writes=[res.x] funcs=[] consts=[] locals=[]",
+		},
+		{
+			src:  "res.x = pi * r ^ 2\nlog(r)",
+			want: "env=[r] reads=[r] writes=[res.res2 res.x] funcs=[log] consts=[pi] locals=[]",
+		},
+	}
+	for _, tt := range tests {
+		p, err := Compile(tt.src, tt.opts...)
+		if err != nil {
+			t.Fatalf("Compile(%q): %v", tt.src, err)
+		}
+		d := p.Deps()
+		got := fmt.Sprintf("env=%v reads=%v writes=%v funcs=%v consts=%v locals=%v", d.Env, d.Reads, d.Writes, d.Funcs, d.Consts, d.Locals)
+		if got != tt.want {
+			t.Errorf("%q:\ngot  %s\nwant %s", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestDepsMissing(t *testing.T) {
+	p, err := Compile("res.x = a + b.c + len(d)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(p.Deps().Missing(map[string]any{"b": 1})); got != "[a d]" {
+		t.Errorf("Missing = %s, want [a d]", got)
+	}
+}
@@ -20,9 +20,13 @@ type ECodes interface {
 	Details() []any
 
 	Position() (line int, col int)
+	// Span get the byte offsets [start, end) of the token that caused the error, end is 0 if unknown.
+	Span() (start int, end int)
 
 	SetCol(col int) ECodes
 	SetPosition(line int, col int) ECodes
+	// SetToken set position and span from t, a nil t keeps the current position.
+	SetToken(t *Token) ECodes
 	SetMessage(msg string) ECodes
 	SetMessagef(a ...any) ECodes
 	WithDetails(msg any) ECodes
@@ -32,6 +36,8 @@ type ECode struct {
 	id     int
 	col    int
 	line   int
+	start  int
+	end    int
 	msg    string
 	f      []any
 	detail []any
@@ -63,23 +69,32 @@ func (e *ECode) Details() []any {
 func (e *ECode) Position() (line int, col int) {
 	return e.line, e.col
 }
+func (e *ECode) Span() (start int, end int) {
+	return e.start, e.end
+}
 func (e *ECode) SetCol(col int) ECodes {
-	return &ECode{id: e.id, col: col, line: e.line, msg: e.msg, f: e.f, detail: e.detail}
+	return &ECode{id: e.id, col: col, line: e.line, start: e.start, end: e.end, msg: e.msg, f: e.f, detail: e.detail}
 }
 func (e *ECode) SetPosition(line int, col int) ECodes {
 	return &ECode{id: e.id, col: col, line: line, msg: e.msg, f: e.f, detail: e.detail}
 }
+func (e *ECode) SetToken(t *Token) ECodes {
+	if t == nil {
+		return e
+	}
+	return &ECode{id: e.id, col: t.col, line: t.line, start: t.pos, end: t.end, msg: e.msg, f: e.f, detail: e.detail}
+}
 
 func (e *ECode) SetMessage(msg string) ECodes {
-	return &ECode{id: e.id, col: e.col, line: e.line, msg: msg, f: nil, detail: e.detail}
+	return &ECode{id: e.id, col: e.col, line: e.line, start: e.start, end: e.end, msg: msg, f: nil, detail: e.detail}
 }
 
 func (e *ECode) SetMessagef(f ...any) ECodes {
-	return &ECode{id: e.id, col: e.col, line: e.line, msg: e.msg, f: f, detail: e.detail}
+	return &ECode{id: e.id, col: e.col, line: e.line, start: e.start, end: e.end, msg: e.msg, f: f, detail: e.detail}
 }
 
 func (e *ECode) WithDetails(msg any) ECodes {
-	return &ECode{id: e.id, col: e.col, line: e.line, msg: e.msg, f: e.f, detail: append(e.detail, msg)}
+	return &ECode{id: e.id, col: e.col, line: e.line, start: e.start, end: e.end, msg: e.msg, f: e.f, detail: append(e.detail, msg)}
 }
 
 var (
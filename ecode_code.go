@@ -31,4 +31,24 @@ var (
 	TagRegisteredErr       = New(-525, "tag '%s' is already registered")
 	ConstRegisteredErr     = New(-526, "const '%s' is already exists")
 	ResultKeyRegisteredErr = New(-527, "result key '%s' is already exists")
+	VariableNotIterableErr = New(-528, "variable '%s' is not iterable")
+	NotInLoopErr           = New(-529, "'%s' is not in a loop")
+	NotInFuncErr           = New(-530, "'%s' is not in a function")
+	CallDepthExceededErr   = New(-531, "function '%s' exceeds the maximum call depth %d")
+	StepLimitExceededErr   = New(-532, "execution exceeds the maximum steps %d")
+	ExecutionTimeoutErr    = New(-533, "execution timeout")
+	ExecutionCanceledErr   = New(-534, "execution canceled")
+	DepthExceededErr       = New(-535, "execution exceeds the maximum nesting depth %d")
+	UndefinedErr           = New(-536, "undefined: '%s'")
+	InvalidSchemaErr       = New(-537, "invalid schema: %s")
+	InvalidOperationErr    = New(-538, "invalid operation: operator %s not defined on %s")
+	MismatchedTypesErr     = New(-539, "invalid operation: %s mismatched types %s and %s")
+	NoFieldErr             = New(-540, "%s has no field or index %s")
+	CannotUseTypeErr       = New(-541, "cannot use %s as %s in %s")
+	AmbiguousReplaceStrErr = New(-542, "display strings '%s' and '%s' have the same code '%s'")
+	InvalidKeywordErr      = New(-543, "invalid keyword alias '%s' for '%s'")
+	DomainErr              = New(-544, "%s is not defined for %v")
+	NumberOverflowErr      = New(-545, "%s overflows for %v")
+	CurrencyMismatchErr    = New(-546, "invalid operation: %s mismatched currencies %s and %s")
+	UnknownCurrencyErr     = New(-547, "unknown currency '%s'")
 )
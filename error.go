@@ -0,0 +1,173 @@
+package mathxf
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Error is the error returned by Compile, Program.Run and template.Execute.
+// It keeps the ECode, so errors.As(err, new(*mathxf.ECode)) and Cause work on it.
+type Error struct {
+	Code    int
+	Line    int
+	Column  int
+	Start   int // byte offset of the start of the failing token, valid when End > 0
+	End     int // byte offset of the end of the failing token, 0 if unknown
+	Message string
+	Args    []any
+	Details []any
+
+	source string
+	ecode  ECodes
+}
+
+func newError(ec ECodes) *Error {
+	line, col := ec.Position()
+	start, end := ec.Span()
+	return &Error{
+		Code:    ec.Code(),
+		Line:    line,
+		Column:  col,
+		Start:   start,
+		End:     end,
+		Message: ec.Message(),
+		Args:    ec.Values(),
+		Details: ec.Details(),
+		ecode:   ec,
+	}
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("line: %d, col: %d, %s", e.Line, e.Column, e.Message)
+}
+
+// Unwrap returns the underlying ECodes.
+func (e *Error) Unwrap() error {
+	return e.ecode
+}
+
+// Cause returns the underlying ECodes, it makes github.com/pkg/errors.Cause work on Error.
+func (e *Error) Cause() error {
+	return e.ecode
+}
+
+// ECode returns the underlying ECodes.
+func (e *Error) ECode() ECodes {
+	return e.ecode
+}
+
+// Source returns the template source the error refers to, it is empty if unknown.
+func (e *Error) Source() string {
+	return e.source
+}
+
+// Snippet renders the source line of the error with a caret line under the failing token:
+//
+//	2 | if 用户A.总订单数 > 5 && x <= 3 {
+//	  |    ^^^^^^^^^^^^^^^^
+func (e *Error) Snippet() string {
+	if e.source == "" || e.Line <= 0 {
+		return ""
+	}
+	lines := strings.Split(e.source, "\n")
+	if e.Line > len(lines) {
+		return ""
+	}
+	text := strings.TrimRight(lines[e.Line-1], "\r")
+	lineStart := 0
+	for _, l := range lines[:e.Line-1] {
+		lineStart += len(l) + 1
+	}
+	from, to := e.Column-1, e.Column
+	if e.End > 0 {
+		from, to = e.Start-lineStart, e.End-lineStart
+	}
+	from = clamp(from, 0, len(text))
+	to = clamp(to, from, len(text))
+
+	var caret strings.Builder
+	for _, r := range text[:from] {
+		if r == '\t' {
+			caret.WriteRune('\t')
+			continue
+		}
+		caret.WriteString(strings.Repeat(" ", runeWidth(r)))
+	}
+	width := 0
+	for _, r := range text[from:to] {
+		width += runeWidth(r)
+	}
+	if width == 0 {
+		width = 1
+	}
+	caret.WriteString(strings.Repeat("^", width))
+
+	num := fmt.Sprint(e.Line)
+	pad := strings.Repeat(" ", len(num))
+	return fmt.Sprintf("%s | %s\n%s | %s", num, text, pad, caret.String())
+}
+
+// withSource returns a copy of e that refers to the source src, line and column are
+// recomputed from the byte offsets.
+func (e *Error) withSource(src string) *Error {
+	res := *e
+	res.source = src
+	if res.End > 0 {
+		res.Line = strings.Count(src[:clamp(res.Start, 0, len(src))], "\n") + 1
+		res.Column = res.End - (strings.LastIndex(src[:clamp(res.End, 0, len(src))], "\n") + 1)
+	}
+	return &res
+}
+
+// ParseErr converts err into an *Error, it is the default ParseECodeFn.
+func ParseErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return newError(Cause(err))
+}
+
+// ErrorList is the list of errors reported by CompileAll, in source order.
+type ErrorList []error
+
+func (l ErrorList) Error() string {
+	msgs := make([]string, 0, len(l))
+	for _, err := range l {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Unwrap makes errors.Is and errors.As look at every error of the list.
+func (l ErrorList) Unwrap() []error {
+	return l
+}
+
+func clamp(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
+
+// runeWidth returns the number of terminal columns of r, East Asian wide characters take two.
+func runeWidth(r rune) int {
+	switch {
+	case r == utf8.RuneError:
+		return 1
+	case r >= 0x1100 && r <= 0x115F, r >= 0x2E80 && r <= 0xA4CF, r >= 0xAC00 && r <= 0xD7A3,
+		r >= 0xF900 && r <= 0xFAFF, r >= 0xFE30 && r <= 0xFE4F, r >= 0xFF00 && r <= 0xFF60,
+		r >= 0xFFE0 && r <= 0xFFE6, r >= 0x20000 && r <= 0x3FFFD:
+		return 2
+	}
+	return 1
+}
@@ -0,0 +1,50 @@
+package mathxf
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestError(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		env     map[string]any
+		opts    []Option
+		code    int
+		line    int
+		col     int
+		snippet string
+	}{
+		{name: "parse", src: "val 1 = 2", code: TokenNotIdentifierErr.Code(), line: 1, col: 5,
+			snippet: "1 | val 1 = 2\n  |     ^"},
+		{name: "run", src: "val a = 1\nres.x = a / (b - 2)", env: map[string]any{"b": 2}, code: DivideZeroErr.Code(), line: 2, col: 14,
+			snippet: "2 | res.x = a / (b - 2)\n  |              ^"},
+		{name: "unknown", src: "res.x = 1 + 总数", code: VariableInvalidErr.Code(), line: 1, col: 18,
+			snippet: "1 | res.x = 1 + 总数\n  |             ^^^^"},
+		{name: "alias", src: "if 用户A.总订单数 > 5 { res.x = 1 }", code: VariableInvalidErr.Code(), line: 1, col: 23,
+			opts:    []Option{WithReplaceStrMap(map[string]string{"用户A.总订单数": "TotalOrders"})},
+			snippet: "1 | if 用户A.总订单数 > 5 { res.x = 1 }\n  |    ^^^^^^^^^^^^^^"},
+	}
+	for _, tt := range tests {
+		p, err := Compile(tt.src, tt.opts...)
+		if err == nil {
+			_, err = p.Run(context.Background(), tt.env)
+		}
+		var e *Error
+		if !errors.As(err, &e) {
+			t.Errorf("%s: err = %v (%T), want *Error", tt.name, err, err)
+			continue
+		}
+		if e.Code != tt.code || e.Line != tt.line || e.Column != tt.col {
+			t.Errorf("%s: error %d at %d:%d, want %d at %d:%d", tt.name, e.Code, e.Line, e.Column, tt.code, tt.line, tt.col)
+		}
+		if e.ECode() == nil || e.ECode().Code() != e.Code {
+			t.Errorf("%s: ECode() = %v, want code %d", tt.name, e.ECode(), e.Code)
+		}
+		if got := e.Snippet(); got != tt.snippet {
+			t.Errorf("%s: snippet\n%s\nwant\n%s", tt.name, got, tt.snippet)
+		}
+	}
+}
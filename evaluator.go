@@ -3,6 +3,7 @@ package mathxf
 import (
 	"github.com/shopspring/decimal"
 	"math"
+	"time"
 )
 
 type IEvaluator interface {
@@ -26,6 +27,9 @@ func (e Expression) GetPositionToken() *Token {
 }
 
 func (e Expression) Evaluate(ctx *EvaluatorContext) (*Value, error) {
+	if err := ctx.step(); err != nil {
+		return nil, err.SetToken(e.GetPositionToken())
+	}
 	v1, err := e.expr1.Evaluate(ctx)
 	if err != nil {
 		return nil, err
@@ -33,6 +37,11 @@ func (e Expression) Evaluate(ctx *EvaluatorContext) (*Value, error) {
 	if e.expr2 == nil {
 		return v1, nil
 	}
+	if ctx.IsStrict {
+		if err := strictBool(e.opToken, v1, "operand of "+e.opToken.spelling()); err != nil {
+			return nil, err
+		}
+	}
 	switch e.opToken.typ {
 	case TokenAnd:
 		if !v1.IsTrue() {
@@ -42,6 +51,11 @@ func (e Expression) Evaluate(ctx *EvaluatorContext) (*Value, error) {
 			if err != nil {
 				return nil, err
 			}
+			if ctx.IsStrict {
+				if err := strictBool(e.opToken, v2, "operand of "+e.opToken.spelling()); err != nil {
+					return nil, err
+				}
+			}
 			return AsValue(v2.IsTrue()), nil
 		}
 	case TokenOr:
@@ -52,15 +66,51 @@ func (e Expression) Evaluate(ctx *EvaluatorContext) (*Value, error) {
 			if err != nil {
 				return nil, err
 			}
+			if ctx.IsStrict {
+				if err := strictBool(e.opToken, v2, "operand of "+e.opToken.spelling()); err != nil {
+					return nil, err
+				}
+			}
 			return AsValue(v2.IsTrue()), nil
 		}
 	default:
 		pos := e.opToken
-		return nil, UnknownOperatorErr.SetMessagef(pos.val).SetPosition(pos.line, pos.col)
+		return nil, UnknownOperatorErr.SetMessagef(pos.val).SetToken(pos)
 	}
 
 }
 
+// ternaryExpression 处理 cond ? expr1 : expr2, 只计算被选中的分支
+type ternaryExpression struct {
+	cond    IEvaluator
+	expr1   IEvaluator
+	expr2   IEvaluator
+	opToken *Token // the ?
+}
+
+func (t ternaryExpression) GetPositionToken() *Token {
+	return t.cond.GetPositionToken()
+}
+
+func (t ternaryExpression) Evaluate(ctx *EvaluatorContext) (*Value, error) {
+	if err := ctx.step(); err != nil {
+		return nil, err.SetToken(t.GetPositionToken())
+	}
+	c, err := t.cond.Evaluate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if ctx.IsStrict {
+		if err := strictBool(t.cond.GetPositionToken(), c, "condition of "+t.opToken.spelling()); err != nil {
+			return nil, err
+		}
+	}
+	if c.IsTrue() {
+		return t.expr1.Evaluate(ctx)
+	}
+	return t.expr2.Evaluate(ctx)
+}
+
 // relationalExpression 处理  TokenEqual  TokenNotEqual  TokenLess  TokenLessEqual  TokenGreater  TokenGreaterEqual
 type relationalExpression struct {
 	expr1   IEvaluator
@@ -82,6 +132,9 @@ func (r relationalExpression) GetPositionToken() *Token {
 }
 
 func (r relationalExpression) Evaluate(ctx *EvaluatorContext) (*Value, error) {
+	if err := ctx.step(); err != nil {
+		return nil, err.SetToken(r.GetPositionToken())
+	}
 	v1, err := r.expr1.Evaluate(ctx)
 	if err != nil {
 		return nil, err
@@ -93,7 +146,23 @@ func (r relationalExpression) Evaluate(ctx *EvaluatorContext) (*Value, error) {
 	if err != nil {
 		return nil, err
 	}
-	switch r.opToken.typ {
+	return evalRelational(ctx, r.opToken, v1, v2)
+}
+
+// evalRelational applies the comparison op to v1 and v2, it is shared by the tree-walker and the VM.
+func evalRelational(ctx *EvaluatorContext, op *Token, v1, v2 *Value) (*Value, error) {
+	if (v1.IsMoney() || v2.IsMoney()) && op.typ != TokenIn {
+		return compareMoney(op, v1, v2)
+	}
+	if temporal(v1, v2) && op.typ != TokenIn {
+		return compareTime(op, v1, v2)
+	}
+	if ctx.IsStrict {
+		if res, err := strictRelational(op, v1, v2); res != nil || err != nil {
+			return res, err
+		}
+	}
+	switch op.typ {
 	case TokenLessEquals:
 		if ctx.IsHighPrecision {
 			return AsValue(v1.Decimal().Cmp(v2.Decimal()) <= 0), nil
@@ -101,10 +170,6 @@ func (r relationalExpression) Evaluate(ctx *EvaluatorContext) (*Value, error) {
 		if v1.IsFloat() || v2.IsFloat() {
 			return AsValue(v1.Float() <= v2.Float()), nil
 		}
-		if v1.IsTime() && v2.IsTime() {
-			tm1, tm2 := v1.Time(), v2.Time()
-			return AsValue(tm1.Before(tm2) || tm1.Equal(tm2)), nil
-		}
 		return AsValue(v1.Integer() <= v2.Integer()), nil
 	case TokenGreatEquals:
 		if ctx.IsHighPrecision {
@@ -113,10 +178,6 @@ func (r relationalExpression) Evaluate(ctx *EvaluatorContext) (*Value, error) {
 		if v1.IsFloat() || v2.IsFloat() {
 			return AsValue(v1.Float() >= v2.Float()), nil
 		}
-		if v1.IsTime() && v2.IsTime() {
-			tm1, tm2 := v1.Time(), v2.Time()
-			return AsValue(tm1.After(tm2) || tm1.Equal(tm2)), nil
-		}
 		return AsValue(v1.Integer() >= v2.Integer()), nil
 	case TokenEquals:
 		if ctx.IsHighPrecision {
@@ -130,9 +191,6 @@ func (r relationalExpression) Evaluate(ctx *EvaluatorContext) (*Value, error) {
 		if v1.IsFloat() || v2.IsFloat() {
 			return AsValue(v1.Float() > v2.Float()), nil
 		}
-		if v1.IsTime() && v2.IsTime() {
-			return AsValue(v1.Time().After(v2.Time())), nil
-		}
 		return AsValue(v1.Integer() > v2.Integer()), nil
 	case TokenLess:
 		if ctx.IsHighPrecision {
@@ -141,9 +199,6 @@ func (r relationalExpression) Evaluate(ctx *EvaluatorContext) (*Value, error) {
 		if v1.IsFloat() || v2.IsFloat() {
 			return AsValue(v1.Float() < v2.Float()), nil
 		}
-		if v1.IsTime() && v2.IsTime() {
-			return AsValue(v1.Time().Before(v2.Time())), nil
-		}
 		return AsValue(v1.Integer() < v2.Integer()), nil
 	case TokenNotEquals:
 		if ctx.IsHighPrecision {
@@ -153,8 +208,7 @@ func (r relationalExpression) Evaluate(ctx *EvaluatorContext) (*Value, error) {
 	case TokenIn:
 		return AsValue(v2.Contains(v1)), nil
 	default:
-		pos := r.opToken
-		return nil, UnknownOperatorErr.SetMessagef(pos.val).SetPosition(pos.line, pos.col)
+		return nil, UnknownOperatorErr.SetMessagef(op.val).SetToken(op)
 	}
 }
 
@@ -170,6 +224,9 @@ func (s simpleExpression) GetPositionToken() *Token {
 }
 
 func (s simpleExpression) Evaluate(ctx *EvaluatorContext) (*Value, error) {
+	if err := ctx.step(); err != nil {
+		return nil, err.SetToken(s.GetPositionToken())
+	}
 	t1, err := s.term1.Evaluate(ctx)
 	if err != nil {
 		return nil, err
@@ -181,12 +238,28 @@ func (s simpleExpression) Evaluate(ctx *EvaluatorContext) (*Value, error) {
 	if err != nil {
 		return nil, err
 	}
-	switch s.opToken.typ {
+	return evalSimple(ctx, s.opToken, t1, t2)
+}
+
+// evalSimple applies + or - to t1 and t2.
+func evalSimple(ctx *EvaluatorContext, op *Token, t1, t2 *Value) (*Value, error) {
+	switch op.typ {
 	case TokenAdd:
 		if t1.IsString() || t2.IsString() {
 			// ResultMap will be a string
 			return AsValue(t1.String() + t2.String()), nil
 		}
+		if t1.IsMoney() || t2.IsMoney() {
+			return evalMoney(ctx, op, t1, t2, nil)
+		}
+		if temporal(t1, t2) {
+			return evalTime(ctx, op, t1, t2, nil)
+		}
+		if ctx.IsStrict {
+			if err := strictNumbers(op, t1, t2); err != nil {
+				return nil, err
+			}
+		}
 		if ctx.IsHighPrecision {
 			return AsValue(t1.Decimal().Add(t2.Decimal())), nil
 		}
@@ -195,6 +268,17 @@ func (s simpleExpression) Evaluate(ctx *EvaluatorContext) (*Value, error) {
 		}
 		return AsValue(t1.Integer() + t2.Integer()), nil
 	case TokenSub:
+		if t1.IsMoney() || t2.IsMoney() {
+			return evalMoney(ctx, op, t1, t2, nil)
+		}
+		if temporal(t1, t2) {
+			return evalTime(ctx, op, t1, t2, nil)
+		}
+		if ctx.IsStrict {
+			if err := strictNumbers(op, t1, t2); err != nil {
+				return nil, err
+			}
+		}
 		if ctx.IsHighPrecision {
 			return AsValue(t1.Decimal().Sub(t2.Decimal())), nil
 		}
@@ -203,8 +287,7 @@ func (s simpleExpression) Evaluate(ctx *EvaluatorContext) (*Value, error) {
 		}
 		return AsValue(t1.Integer() - t2.Integer()), nil
 	default:
-		pos := s.opToken
-		return nil, UnknownOperatorErr.SetMessagef(pos.val).SetPosition(pos.line, pos.col)
+		return nil, UnknownOperatorErr.SetMessagef(op.val).SetToken(op)
 	}
 }
 
@@ -220,6 +303,9 @@ func (t termExpression) GetPositionToken() *Token {
 }
 
 func (t termExpression) Evaluate(ctx *EvaluatorContext) (*Value, error) {
+	if err := ctx.step(); err != nil {
+		return nil, err.SetToken(t.GetPositionToken())
+	}
 	f1, err := t.factor1.Evaluate(ctx)
 	if err != nil {
 		return nil, err
@@ -231,7 +317,23 @@ func (t termExpression) Evaluate(ctx *EvaluatorContext) (*Value, error) {
 	if err != nil {
 		return nil, err
 	}
-	switch t.opToken.typ {
+	return evalTerm(ctx, t.opToken, f1, f2, t.factor2.GetPositionToken())
+}
+
+// evalTerm applies *, / or % to f1 and f2, pos2 is the position of f2 reported on a division by zero.
+func evalTerm(ctx *EvaluatorContext, op *Token, f1, f2 *Value, pos2 *Token) (*Value, error) {
+	if f1.IsMoney() || f2.IsMoney() {
+		return evalMoney(ctx, op, f1, f2, pos2)
+	}
+	if temporal(f1, f2) {
+		return evalTime(ctx, op, f1, f2, pos2)
+	}
+	if ctx.IsStrict {
+		if err := strictNumbers(op, f1, f2); err != nil {
+			return nil, err
+		}
+	}
+	switch op.typ {
 	case TokenMul:
 		if ctx.IsHighPrecision {
 			return AsValue(f1.Decimal().Mul(f2.Decimal())), nil
@@ -244,53 +346,116 @@ func (t termExpression) Evaluate(ctx *EvaluatorContext) (*Value, error) {
 		if ctx.IsHighPrecision {
 			divisor := f2.Decimal()
 			if divisor.Cmp(decimal.Zero) == 0 {
-				pos := t.factor2.GetPositionToken()
-				return nil, DivideZeroErr.SetPosition(pos.line, pos.col)
+				return nil, DivideZeroErr.SetToken(pos2)
 			}
-			return AsValue(f1.Decimal().Div(divisor)), nil
+			return AsValue(ctx.Numeric.Div(f1.Decimal(), divisor)), nil
 		}
 
 		if f1.IsFloat() || f2.IsFloat() {
 			divisor := f2.Float()
 			if divisor == 0 {
-				pos := t.factor2.GetPositionToken()
-				return nil, DivideZeroErr.SetPosition(pos.line, pos.col)
+				return nil, DivideZeroErr.SetToken(pos2)
 			}
 			return AsValue(f1.Float() / divisor), nil
 		}
 		divisor := f2.Integer()
 		if divisor == 0 {
-			pos := t.factor2.GetPositionToken()
-			return nil, DivideZeroErr.SetPosition(pos.line, pos.col)
+			return nil, DivideZeroErr.SetToken(pos2)
 		}
 		return AsValue(f1.Integer() / divisor), nil
 	case TokenMod:
 		if ctx.IsHighPrecision {
 			divisor := f2.Decimal()
-			//todo 精度控制
 			if divisor.Cmp(decimal.Zero) == 0 {
-				pos := t.factor2.GetPositionToken()
-				return nil, DivideZeroErr.SetPosition(pos.line, pos.col)
+				return nil, DivideZeroErr.SetToken(pos2)
 			}
-			return AsValue(f1.Decimal().Mod(divisor)), nil
+			return AsValue(ctx.Numeric.Mod(f1.Decimal(), divisor)), nil
 		}
 		divisor := f2.Integer()
 		if divisor == 0 {
-			pos := t.factor2.GetPositionToken()
-			return nil, DivideZeroErr.SetPosition(pos.line, pos.col)
+			return nil, DivideZeroErr.SetToken(pos2)
 		}
 		return AsValue(f1.Integer() % divisor), nil
 	default:
-		pos := t.opToken
-		return nil, UnknownOperatorErr.SetMessagef(pos.val).SetPosition(pos.line, pos.col)
+		return nil, UnknownOperatorErr.SetMessagef(op.val).SetToken(op)
 	}
 
 }
 
+// unaryExpression 处理 TokenNot TokenSub TokenAdd
+type unaryExpression struct {
+	expr    IEvaluator
+	opToken *Token
+}
+
+func (u unaryExpression) GetPositionToken() *Token {
+	return u.opToken
+}
+
+func (u unaryExpression) Evaluate(ctx *EvaluatorContext) (*Value, error) {
+	if err := ctx.step(); err != nil {
+		return nil, err.SetToken(u.GetPositionToken())
+	}
+	v, err := u.expr.Evaluate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return evalUnary(ctx, u.opToken, v, u.expr.GetPositionToken())
+}
+
+// evalUnary applies op to v, pos is the position of the operand.
+func evalUnary(ctx *EvaluatorContext, op *Token, v *Value, pos *Token) (*Value, error) {
+	if op.typ == TokenNot {
+		if ctx.IsStrict {
+			if err := strictBool(op, v, "operand of "+op.spelling()); err != nil {
+				return nil, err
+			}
+		}
+		return AsValue(!v.IsTrue()), nil
+	}
+	if m, ok := v.Interface().(Money); ok {
+		if op.typ == TokenSub {
+			m.Amount = m.Amount.Neg()
+		}
+		return AsValue(m), nil
+	}
+	if d, ok := v.Interface().(time.Duration); ok {
+		if op.typ == TokenSub {
+			d = -d
+		}
+		return AsValue(d), nil
+	}
+	if v.IsNil() || !v.IsNumber() {
+		return nil, ArgumentNotNumberErr.SetMessagef(op.val, v.Interface()).SetToken(pos)
+	}
+	switch op.typ {
+	case TokenSub:
+		if ctx.IsHighPrecision {
+			return AsValue(v.Decimal().Neg()), nil
+		}
+		if d, ok := v.Interface().(decimal.Decimal); ok {
+			return AsValue(d.Neg()), nil
+		}
+		if v.IsFloat() {
+			// 0 - f and not -f, a negated zero is 0 and not -0
+			return AsValue(0 - v.Float()), nil
+		}
+		return AsValue(-v.Integer()), nil
+	case TokenAdd:
+		if ctx.IsHighPrecision {
+			return AsValue(v.Decimal()), nil
+		}
+		return v, nil
+	default:
+		return nil, UnknownOperatorErr.SetMessagef(op.val).SetToken(op)
+	}
+}
+
 // powerExpression 处理 returns x**y, the base-x exponential of y.
 type powerExpression struct {
-	power1 IEvaluator
-	power2 IEvaluator
+	power1  IEvaluator
+	power2  IEvaluator
+	opToken *Token
 }
 
 func (p powerExpression) GetPositionToken() *Token {
@@ -298,6 +463,9 @@ func (p powerExpression) GetPositionToken() *Token {
 }
 
 func (p powerExpression) Evaluate(ctx *EvaluatorContext) (*Value, error) {
+	if err := ctx.step(); err != nil {
+		return nil, err.SetToken(p.GetPositionToken())
+	}
 	p1, err := p.power1.Evaluate(ctx)
 	if err != nil {
 		return nil, err
@@ -309,8 +477,32 @@ func (p powerExpression) Evaluate(ctx *EvaluatorContext) (*Value, error) {
 	if err != nil {
 		return nil, err
 	}
+	return evalPower(ctx, p.opToken, p1, p2)
+}
+
+// evalPower returns p1 raised to the power p2.
+func evalPower(ctx *EvaluatorContext, op *Token, p1, p2 *Value) (*Value, error) {
+	if p1.IsMoney() || p2.IsMoney() {
+		return nil, InvalidOperationErr.SetMessagef(op.val, MoneyType).SetToken(op)
+	}
+	if temporal(p1, p2) {
+		typ := typeOfValue(p1)
+		if typ.Kind != KindTime && typ.Kind != KindDuration {
+			typ = typeOfValue(p2)
+		}
+		return nil, InvalidOperationErr.SetMessagef(op.val, typ).SetToken(op)
+	}
+	if ctx.IsStrict {
+		if err := strictNumbers(op, p1, p2); err != nil {
+			return nil, err
+		}
+	}
 	if ctx.IsHighPrecision {
-		return AsValue(p1.Decimal().Pow(p2.Decimal())), nil
+		res, err := ctx.Numeric.Pow(p1.Decimal(), p2.Decimal())
+		if err != nil {
+			return nil, Cause(err).SetToken(op)
+		}
+		return AsValue(res), nil
 	}
 	return AsValue(math.Pow(p1.Float(), p2.Float())), nil
 }
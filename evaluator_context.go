@@ -3,9 +3,12 @@ package mathxf
 import (
 	"context"
 	"errors"
-	"fmt"
+	"time"
 )
 
+// DefMaxDepth is the default maximum nesting depth of blocks and user-defined function calls.
+const DefMaxDepth = 100
+
 type ValMap map[string]*Value
 type ValElementMap map[string]*ValElement
 
@@ -14,6 +17,7 @@ type ValElement struct {
 	IsSet   bool
 	IsFunc  bool
 	Val     any
+	results map[Kind]*Type // result types of a builtin by the kind of the first argument, KindAny for the others
 }
 
 func NewConstValElement(val any, isFunc bool) *ValElement {
@@ -49,33 +53,129 @@ const (
 	ResultVal
 )
 
+// scope is a local symbol layer, e.g. the body of a loop.
+type scope struct {
+	parent *scope
+	vals   ValElementMap
+}
+
+// EvaluatorContext holds the state of a single run. Symbols are resolved layer by layer:
+// local scopes -> ValMap (env and top-level val) -> template constants -> DefConst.
+// Only the local scopes and ValMap are written during a run, constants and builtins are read-only.
 type EvaluatorContext struct {
 	context.Context
 	IsHighPrecision bool
+	IsStrict        bool             // report operands of the wrong type instead of coercing them, see WithStrictTypes
+	Numeric         Numeric          // decimal settings of high-precision mode
+	Location        *time.Location   // time zone of date, now and the calendar functions, nil means time.Local
+	Now             func() time.Time // clock of now(), nil means time.Now
 	ValMap          ValElementMap
 	ResultMap       map[string]ValMap
 
+	constMap     ValElementMap
+	scope        *scope
+	maxSteps     int // 0 means unlimited
+	steps        int
+	writes       int // assignments so far, the VM caches the values of env variables between them
+	maxDepth     int
+	depth        int
 	defResultKey string
 	parseErrFn   ParseECodeFn
+	vm           *machine // set when the program runs as bytecode
 }
 
 func NewEvaluatorContext(ctx context.Context) *EvaluatorContext {
 	res := EvaluatorContext{
 		Context:         ctx,
 		IsHighPrecision: true,
-		ValMap:          DefConst,
+		Numeric:         DefNumeric,
+		ValMap:          make(ValElementMap),
 		ResultMap:       make(map[string]ValMap),
-		defResultKey:    "res",
+		maxDepth:        DefMaxDepth,
+		defResultKey:    DefResultKey,
 		parseErrFn:      ParseErr,
 	}
+	res.ResultMap[res.defResultKey] = make(ValMap)
 	return &res
 }
 
-func ParseErr(err error) error {
-	if err == nil {
+// Lookup resolves name from the innermost local scope up to the builtin constants.
+func (ctx *EvaluatorContext) Lookup(name string) (*ValElement, bool) {
+	for s := ctx.scope; s != nil; s = s.parent {
+		if ele, ok := s.vals[name]; ok {
+			return ele, true
+		}
+	}
+	if ele, ok := ctx.ValMap[name]; ok {
+		return ele, true
+	}
+	if ele, ok := ctx.constMap[name]; ok {
+		return ele, true
+	}
+	ele, ok := DefConst[name]
+	return ele, ok
+}
+
+// declared reports whether a val or func declaration of name collides with a visible name: a local,
+// an env variable, a top-level val or a template constant. Builtins of DefConst are not included,
+// a declaration shadows them.
+func (ctx *EvaluatorContext) declared(name string) bool {
+	for s := ctx.scope; s != nil; s = s.parent {
+		if _, ok := s.vals[name]; ok {
+			return true
+		}
+	}
+	if _, ok := ctx.ValMap[name]; ok {
+		return true
+	}
+	_, ok := ctx.constMap[name]
+	return ok
+}
+
+// Define declares name in the innermost local scope, or in ValMap when there is no local scope.
+func (ctx *EvaluatorContext) Define(name string, ele *ValElement) {
+	if ctx.scope != nil {
+		ctx.scope.vals[name] = ele
+		return
+	}
+	ctx.ValMap[name] = ele
+}
+
+func (ctx *EvaluatorContext) pushScope() {
+	ctx.scope = &scope{parent: ctx.scope, vals: make(ValElementMap)}
+}
+
+func (ctx *EvaluatorContext) popScope() {
+	ctx.scope = ctx.scope.parent
+}
+
+// step counts one evaluation step and checks the step limit and the cancellation of the context.
+// The returned error has no position, callers attach it with SetToken.
+func (ctx *EvaluatorContext) step() ECodes {
+	ctx.steps++
+	if ctx.maxSteps > 0 && ctx.steps > ctx.maxSteps {
+		return StepLimitExceededErr.SetMessagef(ctx.maxSteps)
+	}
+	select {
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return ExecutionTimeoutErr
+		}
+		return ExecutionCanceledErr
+	default:
 		return nil
 	}
-	e := Cause(err)
-	line, col := e.Position()
-	return errors.New(fmt.Sprintf("line: %d, col: %d, %s", line, col, e.Message()))
+}
+
+// enter increases the nesting depth, every successful enter must be followed by leave.
+func (ctx *EvaluatorContext) enter(pos *Token) error {
+	if ctx.maxDepth > 0 && ctx.depth >= ctx.maxDepth {
+		return DepthExceededErr.SetMessagef(ctx.maxDepth).SetToken(pos)
+	}
+	ctx.depth++
+	return nil
+}
+
+func (ctx *EvaluatorContext) leave() {
+	ctx.depth--
 }
@@ -0,0 +1,67 @@
+package mathxf
+
+import "testing"
+
+// TestTernary runs in both precisions, numbers are true unless they are zero.
+func TestTernary(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{`0 ? "a" : "b"`, "b"},
+		{`zero ? "a" : "b"`, "b"},
+		{`0.0 ? "a" : "b"`, "b"},
+		{`-1 ? "a" : "b"`, "a"},
+		{`0.5 - 0.5 ? "a" : "b"`, "b"},
+		{`0 ? 1 / 0 : 7`, "7"},
+		{`1 ? 7 : 1 / 0`, "7"},
+		{`level > 3 ? 0.3 : 0.1`, "0.1"},
+		{`level > 1 ? level > 2 ? "c" : "b" : "a"`, "b"},
+		{`"" ? "a" : "b"`, "b"},
+		{`0 CNY ? "a" : "b"`, "b"},
+	}
+	env := map[string]any{"level": 2, "zero": 0}
+	for _, tt := range tests {
+		for _, hp := range []bool{true, false} {
+			for _, res := range runSrc(t, "res.x = "+tt.expr, env, WithHighPrecision(hp)) {
+				if got := toString(res[DefResultKey]["x"]); got != tt.want {
+					t.Errorf("%s (hp=%v) = %s, want %s", tt.expr, hp, got, tt.want)
+				}
+			}
+		}
+	}
+}
+
+// TestUnary runs in both precisions, '-' is a unary operator and binds looser than '^'.
+func TestUnary(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{`!0`, "true"},
+		{`not 0`, "true"},
+		{`!zero`, "true"},
+		{`not 0.5`, "false"},
+		{`-0`, "0"},
+		{`-0 ? "a" : "b"`, "b"},
+		{`-2 ^ 2`, "-4"},
+		{`(-2) ^ 2`, "4"},
+		{`2 ^ -1`, "0.5"},
+		{`-x`, "-3"},
+		{`- -x`, "3"},
+		{`1 - -x`, "4"},
+		{`x-1`, "2"},
+		{`+x`, "3"},
+		{`-1.5 CNY`, "-1.50 CNY"},
+	}
+	env := map[string]any{"x": 3, "zero": 0}
+	for _, tt := range tests {
+		for _, hp := range []bool{true, false} {
+			for _, res := range runSrc(t, "res.x = "+tt.expr, env, WithHighPrecision(hp)) {
+				if got := toString(res[DefResultKey]["x"]); got != tt.want {
+					t.Errorf("%s (hp=%v) = %s, want %s", tt.expr, hp, got, tt.want)
+				}
+			}
+		}
+	}
+}
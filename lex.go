@@ -11,10 +11,13 @@ const eof = -1
 // lexer holds the state of the scanner.
 type lexer struct {
 	input           string            // the string being scanned
-	replaceKeywords map[string]string // todo replace keywords
+	replaceKeywords map[string]string // localized keywords of WithKeywords and their keyword
+	aliases         map[string]string // display strings of WithReplaceStrMap and their code
+	aliasOrder      []string          // keys of aliases, longer keys first
 
-	lastTokenType tokenType  // last Token type
-	tokens        chan Token // channel of scanned tokens
+	lastTokenType tokenType // last Token type
+	state         stateFn   // the next state, nil once the input is scanned
+	items         []Token   // scanned tokens not yet returned by nextToken
 
 	start int
 	pos   int
@@ -25,28 +28,29 @@ type lexer struct {
 
 func lex(input string) *lexer {
 	l := &lexer{
-		input:  input,
-		line:   1,
-		col:    0,
-		tokens: make(chan Token),
+		input: input,
+		line:  1,
+		col:   0,
+		state: baseStateFn,
 	}
 	return l
 }
 
-// run runs the state machine for the lexer.
-func (l *lexer) run() {
-	go func() {
-		state := baseStateFn
-		for state != nil {
-			state = state(l)
-		}
-		l.emit(TokenEOF)
-		close(l.tokens)
-	}()
-}
-
+// nextToken runs the state machine until it emits the next token. The scanner runs in the
+// goroutine of the parser and only as far as the parser reads.
 func (l *lexer) nextToken() Token {
-	item := <-l.tokens
+	for len(l.items) == 0 {
+		if l.state == nil {
+			// the scanner is done, keep reporting EOF
+			return Token{typ: TokenEOF, line: l.line, col: l.col, pos: len(l.input), end: len(l.input)}
+		}
+		l.state = l.state(l)
+		if l.state == nil {
+			l.emit(TokenEOF)
+		}
+	}
+	item := l.items[0]
+	l.items = l.items[1:]
 	return item
 }
 
@@ -102,15 +106,79 @@ func (l *lexer) value() string {
 // emit passes an item back to the client.
 func (l *lexer) emit(t tokenType) {
 	l.lastTokenType = t
-	l.tokens <- Token{t, l.line, l.col, l.value()}
+	l.items = append(l.items, Token{typ: t, line: l.line, col: l.col, val: l.value(), pos: l.start, end: l.pos})
 	l.start = l.pos
 }
 
+// alias emits the tokens of the code of a display string that starts at the current position.
+// Strings and comments are never scanned here, and a display string is not matched as
+// part of a longer identifier. The tokens span the display string in the source.
+func (l *lexer) alias() bool {
+	rest := l.input[l.pos:]
+	for _, k := range l.aliasOrder {
+		if k == "" || !strings.HasPrefix(rest, k) {
+			continue
+		}
+		if last, _ := utf8.DecodeLastRuneInString(k); isAlphaNumeric(last) {
+			if next, _ := utf8.DecodeRuneInString(rest[len(k):]); isAlphaNumeric(next) {
+				continue
+			}
+		}
+		l.pos += len(k)
+		l.col += len(k)
+		code := lex(l.aliases[k])
+		for {
+			t := code.nextToken()
+			if t.typ == TokenEOF {
+				break
+			}
+			t.line, t.col, t.pos, t.end = l.line, l.col, l.start, l.pos
+			l.items = append(l.items, t)
+			l.lastTokenType = t.typ
+		}
+		l.start = l.pos
+		return true
+	}
+	return false
+}
+
 func (l *lexer) emitError(format string, args ...interface{}) stateFn {
-	l.tokens <- Token{TokenError, l.line, l.col, fmt.Sprintf(format, args...)}
+	l.items = append(l.items, Token{typ: TokenError, line: l.line, col: l.col, val: fmt.Sprintf(format, args...), pos: l.start, end: l.pos})
 	return nil
 }
 
+// currency consumes the blanks and the currency code after a number, e.g. " CNY" of "100.00 CNY".
+// A code is a registered currency that is not a localized keyword or a display string, other
+// letters after a number are left to the identifier that follows it.
+func (l *lexer) currency() bool {
+	rest := l.input[l.pos:]
+	code := strings.TrimLeft(rest, " \t")
+	if len(code) == len(rest) || len(code) < 3 {
+		return false
+	}
+	for i := 0; i < 3; i++ {
+		if code[i] < 'A' || code[i] > 'Z' {
+			return false
+		}
+	}
+	if next, _ := utf8.DecodeRuneInString(code[3:]); isAlphaNumeric(next) {
+		return false
+	}
+	if _, ok := LookupCurrency(code[:3]); !ok {
+		return false
+	}
+	if _, ok := l.replaceKeywords[code[:3]]; ok {
+		return false
+	}
+	if _, ok := l.aliases[code[:3]]; ok {
+		return false
+	}
+	n := len(rest) - len(code) + 3
+	l.pos += n
+	l.col += n
+	return true
+}
+
 func (l *lexer) scanNumber() (bool, bool) {
 	// Is it hex?
 	digits := "0123456789"
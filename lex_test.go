@@ -0,0 +1,150 @@
+package mathxf
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestInvalidInputsLeakNoGoroutines compiles many invalid templates, the lexer must not leave a
+// goroutine behind for a template whose parse stopped early.
+func TestInvalidInputsLeakNoGoroutines(t *testing.T) {
+	inputs := []string{
+		`res.a = (1 + `,
+		`res.a = "unterminated`,
+		`res.a = 1 +* 2`,
+		`if a > { }`,
+		`res.a = 1 | 2`,
+		`val = 3`,
+		`for in items {}`,
+		`res.a = 3dd`,
+		`/* unclosed`,
+		`func f( { }`,
+		`res.a = [1, 2`,
+		`res.a = 1 ? 2`,
+	}
+	runtime.GC()
+	before := runtime.NumGoroutine()
+	for i := 0; i < 500; i++ {
+		for _, in := range inputs {
+			if _, err := Compile(in); err == nil {
+				t.Fatalf("Compile(%q) succeeded, want an error", in)
+			}
+			CompileAll(in)
+		}
+	}
+	// give goroutines that are about to exit a chance to do so before counting
+	var after int
+	for i := 0; i < 10; i++ {
+		runtime.GC()
+		if after = runtime.NumGoroutine(); after <= before {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("goroutines: %d before, %d after compiling %d invalid templates", before, after, 500*len(inputs))
+}
+
+func TestAliases(t *testing.T) {
+	aliases := WithReplaceStrMap(map[string]string{
+		"订单数":      "Orders",
+		"用户A.总订单数": "TotalOrders",
+		"比率":       "rate",
+	})
+	env := map[string]any{"Orders": 4, "TotalOrders": 10, "rate": 0.5, "订单数量": 7}
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`res.x = 订单数 * 比率`, "2"},
+		{`res.x = 用户A.总订单数 + 订单数`, "14"},
+		{`res.x = "订单数"`, "订单数"},
+		{"// 订单数 * 比率\nres.x = 订单数", "4"},
+		{`res.x = 订单数量`, "7"},
+	}
+	for _, tt := range tests {
+		p, err := Compile(tt.src, aliases)
+		if err != nil {
+			t.Fatalf("Compile(%q): %v", tt.src, err)
+		}
+		res, err := p.Run(context.Background(), env)
+		if err != nil {
+			t.Fatalf("Run(%q): %v", tt.src, err)
+		}
+		if got := res[DefResultKey]["x"].String(); got != tt.want {
+			t.Errorf("%q: res.x = %s, want %s", tt.src, got, tt.want)
+		}
+	}
+}
+
+// TestAliasErrorPosition checks that errors point into the source as written, not into its code form.
+func TestAliasErrorPosition(t *testing.T) {
+	p, err := Compile("res.x = 1\nres.y = 用户A.总订单数 / 订单数", WithReplaceStrMap(map[string]string{"订单数": "Orders", "用户A.总订单数": "TotalOrders"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.Run(context.Background(), map[string]any{"TotalOrders": 1, "Orders": 0})
+	var e *Error
+	if !errors.As(err, &e) || e.Code != DivideZeroErr.Code() {
+		t.Fatalf("err = %v, want divide zero", err)
+	}
+	if e.Line != 2 || e.Column != 40 || e.Snippet() != "2 | res.y = 用户A.总订单数 / 订单数\n  |                          ^^^^^^" {
+		t.Errorf("error at %d:%d\n%s", e.Line, e.Column, e.Snippet())
+	}
+}
+
+func TestKeywords(t *testing.T) {
+	keywords := WithKeywords(map[string]string{
+		"如果": "if", "否则": "else", "并且": "and", "或者": "or", "非": "not", "遍历": "for", "在": "in", "跳出": "break",
+	})
+	env := map[string]any{"等级": 5, "冻结": false, "items": []int{1, 2, 3}}
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"如果 等级 > 3 并且 非 冻结 { res.x = 1 } 否则 { res.x = 0 }", "1"},
+		{"如果 等级 > 9 或者 冻结 { res.x = 1 } 否则 如果 等级 > 3 { res.x = 2 }", "2"},
+		{"if 等级 > 3 and not 冻结 { res.x = 1 } else { res.x = 0 }", "1"},
+		{"val s = 0\n遍历 i 在 items { 如果 i > 2 { 跳出 } s = s + i }\nres.x = s", "3"},
+		{"res.x = \"如果\"", "如果"},
+	}
+	for _, tt := range tests {
+		p, err := Compile(tt.src, keywords)
+		if err != nil {
+			t.Fatalf("Compile(%q): %v", tt.src, err)
+		}
+		res, err := p.Run(context.Background(), env)
+		if err != nil {
+			t.Fatalf("Run(%q): %v", tt.src, err)
+		}
+		if got := res[DefResultKey]["x"].String(); got != tt.want {
+			t.Errorf("%q: res.x = %s, want %s", tt.src, got, tt.want)
+		}
+	}
+	// the message spells the keyword as written
+	p, err := Compile("val a = 1\n跳出", keywords)
+	if err == nil {
+		_, err = p.Run(context.Background(), nil)
+	}
+	if err == nil || !strings.Contains(err.Error(), "'跳出' is not in a loop") {
+		t.Errorf("err = %v, want '跳出' is not in a loop", err)
+	}
+}
+
+func TestKeywordsInvalid(t *testing.T) {
+	for _, keywords := range []map[string]string{
+		{"如 果": "if"},
+		{"1如果": "if"},
+		{"如果": "iff"},
+		{"else": "if"},
+	} {
+		_, err := Compile("res.x = 1", WithKeywords(keywords))
+		var e *Error
+		if !errors.As(err, &e) || e.Code != InvalidKeywordErr.Code() {
+			t.Errorf("WithKeywords(%v): err = %v, want %v", keywords, err, InvalidKeywordErr)
+		}
+	}
+}
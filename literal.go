@@ -5,6 +5,7 @@ import (
 	"github.com/shopspring/decimal"
 	"reflect"
 	"strings"
+	"time"
 )
 
 type numberResolver struct {
@@ -16,12 +17,47 @@ func (f numberResolver) GetPositionToken() *Token {
 	return f.locationToken
 }
 func (f numberResolver) Evaluate(ctx *EvaluatorContext) (*Value, error) {
+	if err := ctx.step(); err != nil {
+		return nil, err.SetToken(f.GetPositionToken())
+	}
 	if ctx.IsHighPrecision {
 		return AsValue(decimal.NewFromFloat(f.val)), nil
 	}
 	return AsValue(f.val), nil
 }
 
+// moneyResolver is a Money literal like 100.00 CNY.
+type moneyResolver struct {
+	locationToken *Token
+	val           Money
+}
+
+func (m moneyResolver) GetPositionToken() *Token {
+	return m.locationToken
+}
+func (m moneyResolver) Evaluate(ctx *EvaluatorContext) (*Value, error) {
+	if err := ctx.step(); err != nil {
+		return nil, err.SetToken(m.GetPositionToken())
+	}
+	return AsValue(m.val), nil
+}
+
+// durationResolver is a duration literal like 3d or 1h30m.
+type durationResolver struct {
+	locationToken *Token
+	val           time.Duration
+}
+
+func (d durationResolver) GetPositionToken() *Token {
+	return d.locationToken
+}
+func (d durationResolver) Evaluate(ctx *EvaluatorContext) (*Value, error) {
+	if err := ctx.step(); err != nil {
+		return nil, err.SetToken(d.GetPositionToken())
+	}
+	return AsValue(d.val), nil
+}
+
 type boolResolver struct {
 	locationToken *Token
 	val           bool
@@ -31,6 +67,9 @@ func (b boolResolver) GetPositionToken() *Token {
 	return b.locationToken
 }
 func (b boolResolver) Evaluate(ctx *EvaluatorContext) (*Value, error) {
+	if err := ctx.step(); err != nil {
+		return nil, err.SetToken(b.GetPositionToken())
+	}
 	return AsValue(b.val), nil
 }
 
@@ -43,12 +82,16 @@ func (s stringResolver) GetPositionToken() *Token {
 	return s.locationToken
 }
 func (s stringResolver) Evaluate(ctx *EvaluatorContext) (*Value, error) {
+	if err := ctx.step(); err != nil {
+		return nil, err.SetToken(s.GetPositionToken())
+	}
 	return AsValue(s.val), nil
 }
 
 type variableResolver struct {
 	locationToken *Token
 	parts         []*variablePart
+	end           int // byte offset of the end of the last part
 }
 
 func (v variableResolver) GetPositionToken() *Token {
@@ -73,27 +116,26 @@ func (v variableResolver) SetPartValue(ctx *EvaluatorContext, valueEvaluator IEv
 		keyName = part.name
 		if part.isFunctionCall {
 			pos := v.locationToken
-			return VariableCannotFunctionErr.SetMessagef(keyName).SetPosition(pos.line, pos.col)
+			return VariableCannotFunctionErr.SetMessagef(keyName).SetToken(pos)
 		}
 		if index == 0 {
-			if valEle, ok := ctx.ValMap[keyName]; ok {
+			if valEle, ok := ctx.Lookup(keyName); ok {
 				switch valEle.ValType {
 				case ConstVal:
-					return VariableCannotSetValueErr.SetMessagef(keyName).SetPosition(v.locationToken.line, v.locationToken.col)
+					return VariableCannotSetValueErr.SetMessagef(keyName).SetToken(v.locationToken)
 				case PublicVal:
 					isPublicVal = true
 				case ResultVal:
 					isResultVal = true
 				}
-				varData = reflect.ValueOf(&ctx.ValMap).Elem()
-				varData = varData.MapIndex(reflect.ValueOf(keyName)).Elem()
+				varData = reflect.ValueOf(valEle).Elem()
 			} else {
 				if val, ok := ctx.ResultMap[keyName]; ok {
 					isResultVal = true
 					varData = reflect.ValueOf(&val).Elem()
 				} else {
 					pos := v.locationToken
-					return AssignObjectErr.SetMessagef(keyName).SetPosition(pos.line, pos.col)
+					return AssignObjectErr.SetMessagef(keyName).SetToken(pos)
 				}
 			}
 		} else {
@@ -112,7 +154,7 @@ func (v variableResolver) SetPartValue(ctx *EvaluatorContext, valueEvaluator IEv
 				varData = varData.Elem()
 				if !varData.IsValid() {
 					pos := v.locationToken
-					return VariableCannotSetValueErr.SetMessagef(v.String()).SetPosition(pos.line, pos.col)
+					return VariableCannotSetValueErr.SetMessagef(v.String()).SetToken(pos)
 				}
 			}
 			valM, ok := varData.Interface().(ValMap)
@@ -125,7 +167,7 @@ func (v variableResolver) SetPartValue(ctx *EvaluatorContext, valueEvaluator IEv
 				case reflect.Interface:
 					if index != pLen-1 {
 						pos := v.locationToken
-						return VariableNotAccessErr.SetMessagef("reflect.Struct or reflect.Map", varData.Kind().String()).SetPosition(pos.line, pos.col)
+						return VariableNotAccessErr.SetMessagef("reflect.Struct or reflect.Map", varData.Kind().String()).SetToken(pos)
 					}
 				case reflect.Struct:
 					if varData.Type() == TypeOfValElementPrt.Elem() {
@@ -145,7 +187,7 @@ func (v variableResolver) SetPartValue(ctx *EvaluatorContext, valueEvaluator IEv
 					if !partVal.IsValid() {
 						if !isResultVal {
 							pos := v.locationToken
-							return VariableInvalidErr.SetMessagef(v.String()).SetPosition(pos.line, pos.col)
+							return VariableInvalidErr.SetMessagef(v.String()).SetToken(pos)
 						} else {
 							if index != pLen-1 {
 								valEle := reflect.ValueOf(reflect.ValueOf(make(ValMap)))
@@ -160,7 +202,7 @@ func (v variableResolver) SetPartValue(ctx *EvaluatorContext, valueEvaluator IEv
 					}
 				default:
 					pos := v.locationToken
-					return VariableNotAccessErr.SetMessagef("reflect.Struct or reflect.Map", varData.Kind().String()).SetPosition(pos.line, pos.col)
+					return VariableNotAccessErr.SetMessagef("reflect.Struct or reflect.Map", varData.Kind().String()).SetToken(pos)
 				}
 			case VariablePartTypeSubscript:
 				switch varData.Kind() {
@@ -175,7 +217,7 @@ func (v variableResolver) SetPartValue(ctx *EvaluatorContext, valueEvaluator IEv
 						varData = varData.Index(ind)
 					} else {
 						pos := part.subscript.GetPositionToken()
-						return ArgumentOutBoundsErr.SetMessagef(part.name, varData.Len(), ind).SetPosition(pos.line, pos.col)
+						return ArgumentOutBoundsErr.SetMessagef(part.name, varData.Len(), ind).SetToken(pos)
 					}
 				case reflect.Struct:
 					eVal, err := part.subscript.Evaluate(ctx)
@@ -193,11 +235,11 @@ func (v variableResolver) SetPartValue(ctx *EvaluatorContext, valueEvaluator IEv
 					}
 					if eVal.IsNil() {
 						pos := part.subscript.GetPositionToken()
-						return VariableCannotSetValueErr.SetMessagef(pos.val).SetPosition(pos.line, pos.col)
+						return VariableCannotSetValueErr.SetMessagef(pos.val).SetToken(pos)
 					}
 					if !eVal.Val.Type().AssignableTo(varData.Type().Key()) {
 						pos := part.subscript.GetPositionToken()
-						return VariableNotAccessErr.SetMessagef(varData.Type().Key(), eVal.Val.Type()).SetPosition(pos.line, pos.col)
+						return VariableNotAccessErr.SetMessagef(varData.Type().Key(), eVal.Val.Type()).SetToken(pos)
 					}
 					keyName = eVal.String()
 					if index != pLen-1 {
@@ -205,24 +247,25 @@ func (v variableResolver) SetPartValue(ctx *EvaluatorContext, valueEvaluator IEv
 					}
 				default:
 					pos := v.locationToken
-					return VariableNotAccessErr.SetMessagef(varData.Kind().String(), v.String()).SetPosition(pos.line, pos.col)
+					return VariableNotAccessErr.SetMessagef(varData.Kind().String(), v.String()).SetToken(pos)
 				}
 			default:
 				pos := v.locationToken
-				return VariableCannotSetValueErr.SetMessagef(v.String()).SetPosition(pos.line, pos.col)
+				return VariableCannotSetValueErr.SetMessagef(v.String()).SetToken(pos)
 			}
 		}
 	}
 
 	if !varData.IsValid() {
 		pos := v.locationToken
-		return VariableInvalidErr.SetMessagef(v.String()).SetPosition(pos.line, pos.col)
+		return VariableInvalidErr.SetMessagef(v.String()).SetToken(pos)
 	}
 
 	val, err := valueEvaluator.Evaluate(ctx)
 	if err != nil {
 		return err
 	}
+	ctx.writes++
 	switch varData.Kind() {
 	case reflect.Struct:
 		if varData.Type() == TypeOfValElementPrt.Elem() {
@@ -250,38 +293,47 @@ func (v variableResolver) SetPartValue(ctx *EvaluatorContext, valueEvaluator IEv
 	case reflect.Float32, reflect.Float64:
 		if !varData.CanSet() {
 			pos := v.locationToken
-			return VariableCannotSetValueErr.SetMessagef(v.String()).SetPosition(pos.line, pos.col)
+			return VariableCannotSetValueErr.SetMessagef(v.String()).SetToken(pos)
 		}
 		varData.SetFloat(val.Float())
 	case reflect.Bool:
 		if !varData.CanSet() {
 			pos := v.locationToken
-			return VariableCannotSetValueErr.SetMessagef(v.String()).SetPosition(pos.line, pos.col)
+			return VariableCannotSetValueErr.SetMessagef(v.String()).SetToken(pos)
 		}
 		varData.SetBool(val.IsTrue())
 	default:
 		pos := v.locationToken
-		return VariableCannotSetValueErr.SetMessagef(v.String()).SetPosition(pos.line, pos.col)
+		return VariableCannotSetValueErr.SetMessagef(v.String()).SetToken(pos)
 	}
 	return nil
 }
 func (v variableResolver) Evaluate(ctx *EvaluatorContext) (*Value, error) {
+	if err := ctx.step(); err != nil {
+		return nil, err.SetToken(v.GetPositionToken())
+	}
+	name := v.parts[0].name
+	valEle, ok := ctx.Lookup(name)
+	if !ok {
+		pos := v.locationToken
+		return nil, VariableInvalidErr.SetMessagef(name).SetToken(pos)
+	}
+	return v.resolve(ctx, valEle)
+}
+
+// resolve evaluates the path of v starting at valEle, the element of the first part.
+func (v variableResolver) resolve(ctx *EvaluatorContext, valEle *ValElement) (*Value, error) {
 	var varData reflect.Value
 	var isFunc bool
 	//pLen := len(v.parts)
 	for index, part := range v.parts {
 		isFunc = false
 		if index == 0 {
-			var ok bool
-			name := part.name
-			valEle, ok := ctx.ValMap[name]
-			if ok {
-				varData = reflect.ValueOf(valEle.Val)
-				isFunc = valEle.IsFunc
-			} else {
-				pos := v.locationToken
-				return nil, VariableInvalidErr.SetMessagef(name).SetPosition(pos.line, pos.col)
+			varData = reflect.ValueOf(valEle.Val)
+			if c, ok := valEle.Val.(mathConst); ok {
+				varData = reflect.ValueOf(c.value(ctx))
 			}
+			isFunc = valEle.IsFunc
 		} else {
 			if varData.Type() == TypeOfValElementPrt {
 				tmpValue := varData.Interface().(*ValElement)
@@ -313,7 +365,7 @@ func (v variableResolver) Evaluate(ctx *EvaluatorContext) (*Value, error) {
 					varData = varData.MapIndex(reflect.ValueOf(part.name))
 				default:
 					pos := v.locationToken
-					return nil, VariableNotAccessErr.SetMessagef(varData.Kind().String(), v.String()).SetPosition(pos.line, pos.col)
+					return nil, VariableNotAccessErr.SetMessagef(varData.Kind().String(), v.String()).SetToken(pos)
 				}
 
 			case VariablePartTypeSubscript:
@@ -328,7 +380,7 @@ func (v variableResolver) Evaluate(ctx *EvaluatorContext) (*Value, error) {
 						varData = varData.Index(ind)
 					} else {
 						pos := part.subscript.GetPositionToken()
-						return nil, ArgumentOutBoundsErr.SetMessagef(part.name, varData.Len(), ind).SetPosition(pos.line, pos.col)
+						return nil, ArgumentOutBoundsErr.SetMessagef(part.name, varData.Len(), ind).SetToken(pos)
 					}
 				case reflect.Struct:
 					eVal, err := part.subscript.Evaluate(ctx)
@@ -351,11 +403,11 @@ func (v variableResolver) Evaluate(ctx *EvaluatorContext) (*Value, error) {
 					}
 				default:
 					pos := v.locationToken
-					return nil, VariableNotAccessErr.SetMessagef(varData.Kind().String(), v.String()).SetPosition(pos.line, pos.col)
+					return nil, VariableNotAccessErr.SetMessagef(varData.Kind().String(), v.String()).SetToken(pos)
 				}
 			default:
 				pos := v.locationToken
-				return nil, VariableInvalidErr.SetMessagef(v.String()).SetPosition(pos.line, pos.col)
+				return nil, VariableInvalidErr.SetMessagef(v.String()).SetToken(pos)
 			}
 		}
 		if !varData.IsValid() {
@@ -371,7 +423,15 @@ func (v variableResolver) Evaluate(ctx *EvaluatorContext) (*Value, error) {
 		if part.isFunctionCall {
 			if !isFunc {
 				pos := v.locationToken
-				return nil, VariableNotFunctionErr.SetMessagef(v.String()).SetPosition(pos.line, pos.col)
+				return nil, VariableNotFunctionErr.SetMessagef(v.String()).SetToken(pos)
+			}
+			if fn, ok := varData.Interface().(*scriptFunc); ok {
+				rVal, err := fn.call(ctx, part.callingArgs, v.locationToken)
+				if err != nil {
+					return nil, err
+				}
+				varData = rVal.Val
+				continue
 			}
 			funcT := varData.Type()
 			numIn := funcT.NumIn()
@@ -394,11 +454,11 @@ func (v variableResolver) Evaluate(ctx *EvaluatorContext) (*Value, error) {
 					count--
 				}
 				pos := v.locationToken
-				return nil, ArgumentNotEnoughErr.SetMessagef(v.String(), fmt.Sprintf("%s%v", argl, count), len(currArgs)).SetPosition(pos.line, pos.col)
+				return nil, ArgumentNotEnoughErr.SetMessagef(v.String(), fmt.Sprintf("%s%v", argl, count), len(currArgs)).SetToken(pos)
 			}
 			if numOut < 1 && numOut > 2 {
 				pos := v.locationToken
-				return nil, ArgumentsOutPutErr.SetMessagef(v.String()).SetPosition(pos.line, pos.col)
+				return nil, ArgumentsOutPutErr.SetMessagef(v.String()).SetToken(pos)
 			}
 
 			for i, arg := range currArgs {
@@ -417,12 +477,12 @@ func (v variableResolver) Evaluate(ctx *EvaluatorContext) (*Value, error) {
 					if !isVariadic {
 						if fnArg != reflect.TypeOf(pv.Interface()) && fnArg.Kind() != reflect.Interface {
 							pos := arg.GetPositionToken()
-							return nil, ArgumentInputTypeErr.SetMessagef(v.String(), inds, fnArg.String(), pv.Interface()).SetPosition(pos.line, pos.col)
+							return nil, ArgumentInputTypeErr.SetMessagef(v.String(), inds, fnArg.String(), pv.Interface()).SetToken(pos)
 						}
 					} else {
 						if fnArg != reflect.TypeOf(pv.Interface()) && fnArg.Kind() != reflect.Interface {
 							pos := arg.GetPositionToken()
-							return nil, ArgumentVariadicInputTypeErr.SetMessagef(v.String(), inds, fnArg.String(), pv.Interface()).SetPosition(pos.line, pos.col)
+							return nil, ArgumentVariadicInputTypeErr.SetMessagef(v.String(), inds, fnArg.String(), pv.Interface()).SetToken(pos)
 						}
 					}
 					if pv.IsNil() {
@@ -441,16 +501,19 @@ func (v variableResolver) Evaluate(ctx *EvaluatorContext) (*Value, error) {
 				}
 			}
 			results := varData.Call(args)
+			if err := ctx.step(); err != nil {
+				return nil, err.SetToken(v.locationToken)
+			}
 			rVal := results[0]
 			if numOut == 2 {
 				errVal := results[1].Interface()
 				if errVal != nil {
 					code := Cause(errVal.(error))
 					pos := v.locationToken
-					if _, col := code.Position(); col > 0 {
-						pos = currArgs[ind+col].GetPositionToken()
+					if _, col := code.Position(); col > 0 && col < len(currArgs) {
+						pos = currArgs[col].GetPositionToken()
 					}
-					return nil, code.SetPosition(pos.line, pos.col)
+					return nil, code.SetToken(pos)
 				}
 			}
 			if rVal.Type() != TypeOfValuePtr {
@@ -472,9 +535,10 @@ const (
 )
 
 type variablePart struct {
-	typ       VariablePartType
-	name      string
-	subscript IEvaluator
+	typ           VariablePartType
+	name          string
+	subscript     IEvaluator
+	locationToken *Token // identifier or field token, '[' for a subscript
 
 	isFunctionCall bool
 	callingArgs    []IEvaluator // needed for a function call, represents all argument nodes (INode supports nested function calls)
@@ -495,12 +559,16 @@ func (v variablePart) String() string {
 type arrayResolver struct {
 	locationToken *Token
 	parts         []*variablePart
+	end           int // byte offset of the end of ']'
 }
 
 func (a arrayResolver) GetPositionToken() *Token {
 	return a.locationToken
 }
 func (a arrayResolver) Evaluate(ctx *EvaluatorContext) (*Value, error) {
+	if err := ctx.step(); err != nil {
+		return nil, err.SetToken(a.GetPositionToken())
+	}
 	if len(a.parts) == 0 {
 		return &Value{}, nil
 	}
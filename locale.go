@@ -0,0 +1,150 @@
+package mathxf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const (
+	LocaleEN   = "en"    // the messages of ecode_code.go
+	LocaleZhCN = "zh-CN" // Simplified Chinese
+)
+
+var (
+	localeMu sync.RWMutex
+	locales  = map[string]map[int]string{
+		LocaleZhCN: {
+			-500: "内部错误",
+			-501: "函数 '%s' 需要 %s 个参数，实际为 %d 个",
+			-502: "%s:参数 '%v' 不是数字",
+			-503: "函数 '%s' 必须有 1 或 2 个返回值，第二个返回值必须是 error 类型",
+			-504: "函数 '%s' 的第 %d 个参数必须是 %s 或 *mathxf.Value 类型(而不是 %T)",
+			-505: "函数 '%s' 的可变参数必须是 %s 或 *mathxf.Value 类型(而不是 %T)",
+			-506: "函数 '%s' 的第 %d 个参数无效",
+			-507: "索引越界 %s: 0-%d (索引 %d)",
+			-508: "变量 '%s' 无效",
+			-509: "变量 '%s' 不是函数",
+			-510: "不能按名称访问 %s 类型的字段(变量 %s)",
+			-511: "变量 '%s' 不能作为函数使用",
+			-512: "变量 '%s' 不能赋值",
+			-513: "代码块未闭合",
+			-514: "%s:意外的符号 %v",
+			-515: "表达式后缺少 '%s'",
+			-516: "意外的结束",
+			-517: "词法分析错误: %s",
+			-518: "'%s' 不是标识符",
+			-519: "赋值对象错误，只能是 'Public'、'ResultMap'、私有对象；意外的符号 %v",
+			-520: "变量 '%s' 已存在，不能定义",
+			-521: "无效的替换字符串: %s",
+			-522: "除数为零",
+			-523: "未知的运算符 %s",
+			-524: "变量 '%s' 是关键字",
+			-525: "标签 '%s' 已注册",
+			-526: "常量 '%s' 已存在",
+			-527: "结果前缀 '%s' 已存在",
+			-528: "变量 '%s' 不可遍历",
+			-529: "'%s' 不在循环中",
+			-530: "'%s' 不在函数中",
+			-531: "函数 '%s' 超过最大调用深度 %d",
+			-532: "执行超过最大步数 %d",
+			-533: "执行超时",
+			-534: "执行已取消",
+			-535: "执行超过最大嵌套深度 %d",
+			-536: "未定义: '%s'",
+			-537: "无效的类型定义: %s",
+			-538: "无效的运算: 运算符 %s 不能用于 %s",
+			-539: "无效的运算: %s 的类型不匹配 %s 和 %s",
+			-540: "%s 没有字段或索引 %s",
+			-541: "不能将 %s 用作 %s(%s)",
+			-542: "显示字符串 '%s' 和 '%s' 对应同一代码 '%s'",
+			-543: "关键字 '%[2]s' 的本地化写法 '%[1]s' 无效",
+			-544: "%s 对 %v 没有定义",
+			-545: "%s 对 %v 溢出",
+			-546: "无效的运算: %s 的币种不匹配 %s 和 %s",
+			-547: "未知的币种 '%s'",
+		},
+	}
+)
+
+// RegisterMessages adds or replaces the messages of locale, keyed by error code. A message takes
+// the same arguments as the English one, e.g. RegisterMessages("zh-TW", map[int]string{-522: "除數為零"}).
+func RegisterMessages(locale string, msgs map[int]string) {
+	localeMu.Lock()
+	defer localeMu.Unlock()
+	catalog, ok := locales[locale]
+	if !ok {
+		catalog = make(map[int]string, len(msgs))
+		locales[locale] = catalog
+	}
+	for code, msg := range msgs {
+		catalog[code] = msg
+	}
+}
+
+// localeMessage returns the message of code in locale, "zh" is used for "zh-TW" if there is no "zh-TW".
+// English is the message registered by New.
+func localeMessage(locale string, code int) (string, bool) {
+	localeMu.RLock()
+	defer localeMu.RUnlock()
+	for {
+		if msg, ok := locales[locale][code]; ok {
+			return msg, true
+		}
+		if locale == LocaleEN {
+			msg, ok := _codes[code]
+			return msg, ok
+		}
+		i := strings.LastIndexByte(locale, '-')
+		if i < 0 {
+			return "", false
+		}
+		locale = locale[:i]
+	}
+}
+
+// Localize returns a copy of e with its message in locale, e itself if there is no message of its code
+// in locale or the message was replaced by SetMessage.
+func (e *Error) Localize(locale string) *Error {
+	if ec, ok := e.ecode.(*ECode); ok && ec.msg != _codes[ec.id] {
+		return e
+	}
+	msg, ok := localeMessage(locale, e.Code)
+	if !ok {
+		return e
+	}
+	res := *e
+	res.Message = msg
+	if e.Args != nil {
+		res.Message = fmt.Sprintf(msg, e.Args...)
+	}
+	return &res
+}
+
+type localeKey struct{}
+
+// ContextWithLocale returns a copy of ctx that makes Program.Run report errors in locale.
+func ContextWithLocale(ctx context.Context, locale string) context.Context {
+	return context.WithValue(ctx, localeKey{}, locale)
+}
+
+// localizeErr localizes an *Error or the errors of an ErrorList, other errors are returned as they are.
+func localizeErr(err error, locale string) error {
+	if locale == "" {
+		return err
+	}
+	if list, ok := err.(ErrorList); ok {
+		res := make(ErrorList, len(list))
+		for i, err := range list {
+			res[i] = localizeErr(err, locale)
+		}
+		return res
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Localize(locale)
+	}
+	return err
+}
@@ -0,0 +1,56 @@
+package mathxf
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestLocale(t *testing.T) {
+	RegisterMessages("xx", map[int]string{DivideZeroErr.Code(): "xx divide zero", VariableInvalidErr.Code(): "xx variable %s"})
+	RegisterMessages("xx-YY", map[int]string{DivideZeroErr.Code(): "xx-YY divide zero"})
+	fail := func() (int, error) { return 0, ArgumentInvalidErr.SetMessage("custom message") }
+	tests := []struct {
+		src    string
+		locale string // of the template
+		ctx    string // of the run, none if empty
+		want   string
+	}{
+		{"res.x = 1 / 0", "", "", "divide zero"},
+		{"res.x = 1 / 0", LocaleZhCN, "", "除数为零"},
+		{"res.x = a", LocaleZhCN, "", "变量 'a' 无效"},
+		{"res.x = 1 / 0", LocaleZhCN, LocaleEN, "divide zero"},
+		{"res.x = 1 / 0", "", LocaleZhCN, "除数为零"},
+		{"res.x = 1 / 0", "", "xx-YY", "xx-YY divide zero"},
+		{"res.x = a", "", "xx-YY", "xx variable a"},
+		{"res.x = 1 / 0", "", "xx-ZZ", "xx divide zero"},
+		{"res.x = 1 / 0", "", "zh-TW", "divide zero"},
+		{"res.x = fail()", LocaleZhCN, "", "custom message"},
+	}
+	for _, tt := range tests {
+		p, err := Compile(tt.src, WithLocale(tt.locale), WithFuncOrConst("fail", fail))
+		if err != nil {
+			t.Fatalf("Compile(%q): %v", tt.src, err)
+		}
+		ctx := context.Background()
+		if tt.ctx != "" {
+			ctx = ContextWithLocale(ctx, tt.ctx)
+		}
+		_, err = p.Run(ctx, nil)
+		var e *Error
+		if !errors.As(err, &e) || e.Message != tt.want {
+			t.Errorf("%q locale=%q ctx=%q: err = %v, want %q", tt.src, tt.locale, tt.ctx, err, tt.want)
+		}
+	}
+}
+
+func TestLocaleCompileErrors(t *testing.T) {
+	_, err := Compile("val 1 = 2", WithLocale(LocaleZhCN))
+	var e *Error
+	if !errors.As(err, &e) || e.Message != "'1' 不是标识符" {
+		t.Errorf("err = %v, want the message in Chinese", err)
+	}
+	if got := e.Localize(LocaleEN).Message; got != "token '1' is not an identifier" {
+		t.Errorf("Localize(en) = %q", got)
+	}
+}
@@ -0,0 +1,401 @@
+package mathxf
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/shopspring/decimal"
+)
+
+// Money is an amount in a currency, e.g. the literal 100.00 CNY. The arithmetic of Money is always
+// decimal and only combines amounts of the same currency.
+type Money struct {
+	Amount   decimal.Decimal
+	Currency string // ISO 4217 code, e.g. CNY
+}
+
+// Currency describes an ISO 4217 currency.
+type Currency struct {
+	Code   string // e.g. CNY
+	Digits int32  // digits of the minor unit, 2 for CNY and 0 for JPY
+	Symbol string // prefix of Money.Format, the code is used if it is empty
+}
+
+var typeOfMoney = reflect.TypeOf(Money{})
+
+var (
+	currencyMu sync.RWMutex
+	currencies = map[string]Currency{
+		"CNY": {Code: "CNY", Digits: 2, Symbol: "¥"},
+		"USD": {Code: "USD", Digits: 2, Symbol: "$"},
+		"EUR": {Code: "EUR", Digits: 2, Symbol: "€"},
+		"GBP": {Code: "GBP", Digits: 2, Symbol: "£"},
+		"JPY": {Code: "JPY", Digits: 0, Symbol: "JP¥"},
+		"HKD": {Code: "HKD", Digits: 2, Symbol: "HK$"},
+		"TWD": {Code: "TWD", Digits: 2, Symbol: "NT$"},
+		"MOP": {Code: "MOP", Digits: 2, Symbol: "MOP$"},
+		"KRW": {Code: "KRW", Digits: 0, Symbol: "₩"},
+		"SGD": {Code: "SGD", Digits: 2, Symbol: "S$"},
+		"AUD": {Code: "AUD", Digits: 2, Symbol: "A$"},
+		"CAD": {Code: "CAD", Digits: 2, Symbol: "CA$"},
+		"CHF": {Code: "CHF", Digits: 2},
+		"INR": {Code: "INR", Digits: 2, Symbol: "₹"},
+		"RUB": {Code: "RUB", Digits: 2, Symbol: "₽"},
+		"THB": {Code: "THB", Digits: 2, Symbol: "฿"},
+		"VND": {Code: "VND", Digits: 0, Symbol: "₫"},
+		"KWD": {Code: "KWD", Digits: 3, Symbol: "KD"},
+		"BHD": {Code: "BHD", Digits: 3, Symbol: "BD"},
+	}
+)
+
+// RegisterCurrency adds or replaces a currency, its code must be three upper-case letters to be
+// usable in literals like 100.00 CNY.
+func RegisterCurrency(c Currency) {
+	currencyMu.Lock()
+	defer currencyMu.Unlock()
+	currencies[c.Code] = c
+}
+
+// LookupCurrency returns the registered currency of code.
+func LookupCurrency(code string) (Currency, bool) {
+	currencyMu.RLock()
+	defer currencyMu.RUnlock()
+	c, ok := currencies[code]
+	return c, ok
+}
+
+// currencyOf returns the currency of code, a currency with 2 digits if it is not registered.
+func currencyOf(code string) Currency {
+	if c, ok := LookupCurrency(code); ok {
+		return c
+	}
+	return Currency{Code: code, Digits: 2}
+}
+
+// NewMoney returns amount in currency, which must be registered.
+func NewMoney(amount decimal.Decimal, currency string) (Money, error) {
+	if _, ok := LookupCurrency(currency); !ok {
+		return Money{}, UnknownCurrencyErr.SetMessagef(currency)
+	}
+	return Money{Amount: amount, Currency: currency}, nil
+}
+
+// String returns the amount with at least the digits of the minor unit and the code, e.g. 100.00 CNY.
+func (m Money) String() string {
+	digits := currencyOf(m.Currency).Digits
+	s := m.Amount.String()
+	if i := strings.IndexByte(s, '.'); i < 0 || int32(len(s)-i-1) < digits {
+		s = m.Amount.StringFixed(digits)
+	}
+	return s + " " + m.Currency
+}
+
+// Format returns m rounded to the minor unit with mode, with the symbol of its currency and
+// thousands separators, e.g. ¥1,234.50 or -CHF 12.00.
+func (m Money) Format(mode RoundingMode) string {
+	c := currencyOf(m.Currency)
+	rounded := m.Round(mode).Amount
+	s := rounded.Abs().StringFixed(c.Digits)
+	intPart, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		intPart, frac = s[:i], s[i:]
+	}
+	var b strings.Builder
+	if rounded.Sign() < 0 {
+		b.WriteByte('-')
+	}
+	if c.Symbol != "" {
+		b.WriteString(c.Symbol)
+	} else {
+		b.WriteString(c.Code + " ")
+	}
+	for i, r := range intPart {
+		if i > 0 && (len(intPart)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(r)
+	}
+	b.WriteString(frac)
+	return b.String()
+}
+
+// Round rounds the amount of m to the minor unit of its currency.
+func (m Money) Round(mode RoundingMode) Money {
+	return Money{Amount: mode.Round(m.Amount, currencyOf(m.Currency).Digits), Currency: m.Currency}
+}
+
+// Allocate splits m, rounded to the minor unit with mode, by ratios into parts of whole minor units
+// that add up to it. The units left over go one by one to the parts with the largest remainders,
+// to the first of them on ties. Allocate returns nil if a ratio is negative or all of them are zero.
+func (m Money) Allocate(ratios []decimal.Decimal, mode RoundingMode) []Money {
+	total := decimal.Zero
+	for _, r := range ratios {
+		if r.Sign() < 0 {
+			return nil
+		}
+		total = total.Add(r)
+	}
+	if total.IsZero() {
+		return nil
+	}
+	digits := currencyOf(m.Currency).Digits
+	units := m.Round(mode).Amount.Shift(digits)
+	left := units.Abs()
+	shares := make([]decimal.Decimal, len(ratios))
+	rems := make([]decimal.Decimal, len(ratios))
+	for i, r := range ratios {
+		shares[i], rems[i] = units.Abs().Mul(r).QuoRem(total, 0)
+		left = left.Sub(shares[i])
+	}
+	order := make([]int, len(ratios))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return rems[order[i]].GreaterThan(rems[order[j]])
+	})
+	for _, i := range order {
+		if !left.IsPositive() {
+			break
+		}
+		shares[i] = shares[i].Add(decOne)
+		left = left.Sub(decOne)
+	}
+	res := make([]Money, len(ratios))
+	for i, share := range shares {
+		if units.Sign() < 0 {
+			share = share.Neg()
+		}
+		res[i] = Money{Amount: share.Shift(-digits), Currency: m.Currency}
+	}
+	return res
+}
+
+// Split splits m into n parts like Allocate with equal ratios, the first parts get the units left over.
+func (m Money) Split(n int, mode RoundingMode) []Money {
+	if n <= 0 {
+		return nil
+	}
+	ratios := make([]decimal.Decimal, n)
+	for i := range ratios {
+		ratios[i] = decOne
+	}
+	return m.Allocate(ratios, mode)
+}
+
+// evalMoney applies the arithmetic op to v1 and v2, at least one of them is Money. Money adds to and
+// subtracts from Money of the same currency, and is multiplied or divided by a number, the product
+// and the quotient are rounded to the minor unit with the rounding mode of ctx. Money divided by
+// Money is their ratio.
+func evalMoney(ctx *EvaluatorContext, op *Token, v1, v2 *Value, pos2 *Token) (*Value, error) {
+	m1, ok1 := v1.Interface().(Money)
+	m2, ok2 := v2.Interface().(Money)
+	if ok1 && ok2 && m1.Currency != m2.Currency {
+		return nil, CurrencyMismatchErr.SetMessagef(op.val, m1.Currency, m2.Currency).SetToken(op)
+	}
+	mismatch := func() (*Value, error) {
+		return nil, MismatchedTypesErr.SetMessagef(op.val, typeOfValue(v1), typeOfValue(v2)).SetToken(op)
+	}
+	switch op.typ {
+	case TokenAdd, TokenSub:
+		if !ok1 || !ok2 {
+			return mismatch()
+		}
+		if op.typ == TokenAdd {
+			return AsValue(Money{Amount: m1.Amount.Add(m2.Amount), Currency: m1.Currency}), nil
+		}
+		return AsValue(Money{Amount: m1.Amount.Sub(m2.Amount), Currency: m1.Currency}), nil
+	case TokenMul:
+		if ok1 && ok2 {
+			return nil, InvalidOperationErr.SetMessagef(op.val, MoneyType).SetToken(op)
+		}
+		m, n := m1, v2
+		if ok2 {
+			m, n = m2, v1
+		}
+		if n.IsNil() || !n.IsNumber() {
+			return mismatch()
+		}
+		return AsValue(Money{Amount: m.Amount.Mul(n.Decimal()), Currency: m.Currency}.Round(ctx.Numeric.Rounding)), nil
+	case TokenDiv, TokenMod:
+		if !ok1 {
+			return nil, InvalidOperationErr.SetMessagef(op.val, MoneyType).SetToken(op)
+		}
+		divisor := m2.Amount
+		if !ok2 {
+			if v2.IsNil() || !v2.IsNumber() {
+				return mismatch()
+			}
+			divisor = v2.Decimal()
+		}
+		if divisor.IsZero() {
+			return nil, DivideZeroErr.SetToken(pos2)
+		}
+		if op.typ == TokenMod {
+			return AsValue(Money{Amount: ctx.Numeric.Mod(m1.Amount, divisor), Currency: m1.Currency}), nil
+		}
+		q := ctx.Numeric.Div(m1.Amount, divisor)
+		if !ok2 {
+			// split and allocate keep the sum of the shares
+			return AsValue(Money{Amount: q, Currency: m1.Currency}.Round(ctx.Numeric.Rounding)), nil
+		}
+		if ctx.IsHighPrecision {
+			return AsValue(q), nil
+		}
+		return AsValue(q.InexactFloat64()), nil
+	}
+	return nil, InvalidOperationErr.SetMessagef(op.val, MoneyType).SetToken(op)
+}
+
+// compareMoney compares v1 and v2, at least one of them is Money. Money is only ordered against
+// Money of the same currency, Money of different currencies is never equal.
+func compareMoney(op *Token, v1, v2 *Value) (*Value, error) {
+	m1, ok1 := v1.Interface().(Money)
+	m2, ok2 := v2.Interface().(Money)
+	switch op.typ {
+	case TokenEquals, TokenNotEquals:
+		if (!ok1 || !ok2) && !v1.IsNil() && !v2.IsNil() {
+			return nil, MismatchedTypesErr.SetMessagef(op.val, typeOfValue(v1), typeOfValue(v2)).SetToken(op)
+		}
+		equal := ok1 && ok2 && m1.Currency == m2.Currency && m1.Amount.Equal(m2.Amount)
+		return AsValue(equal == (op.typ == TokenEquals)), nil
+	}
+	if !ok1 || !ok2 {
+		return nil, MismatchedTypesErr.SetMessagef(op.val, typeOfValue(v1), typeOfValue(v2)).SetToken(op)
+	}
+	if m1.Currency != m2.Currency {
+		return nil, CurrencyMismatchErr.SetMessagef(op.val, m1.Currency, m2.Currency).SetToken(op)
+	}
+	return orderResult(op, m1.Amount.Cmp(m2.Amount))
+}
+
+// moneyArg returns the argument v of the function name as Money, col is the index of the argument.
+func moneyArg(name string, v *Value, col int) (Money, error) {
+	m, ok := v.Interface().(Money)
+	if !ok {
+		return Money{}, CannotUseTypeErr.SetMessagef(typeOfValue(v), MoneyType, "argument to "+name).SetCol(col)
+	}
+	return m, nil
+}
+
+// reduceMoney folds the arguments of sum, max or min, which must be Money of the currency of the first.
+func reduceMoney(name string, args []*Value, fn func(d1, d2 decimal.Decimal) decimal.Decimal) (*Value, error) {
+	res := args[0].Money()
+	for i, arg := range args[1:] {
+		m, err := moneyArg(name, arg, i+1)
+		if err != nil {
+			return nil, err
+		}
+		if m.Currency != res.Currency {
+			return nil, CurrencyMismatchErr.SetMessagef(name, res.Currency, m.Currency).SetCol(i + 1)
+		}
+		res.Amount = fn(res.Amount, m.Amount)
+	}
+	return AsValue(res), nil
+}
+
+// defMoney money(amount, "CNY") returns amount in a currency.
+func defMoney(amount, currency *Value) (*Value, error) {
+	if !amount.IsNumber() {
+		return nil, ArgumentNotNumberErr.SetMessagef("money", amount.Interface())
+	}
+	m, err := NewMoney(amount.Decimal(), currency.String())
+	if err != nil {
+		return nil, Cause(err).SetCol(1)
+	}
+	return AsValue(m), nil
+}
+
+// defAmount amount(m) returns the amount of m as a number.
+func defAmount(ctx *EvaluatorContext, arg *Value) (*Value, error) {
+	m, err := moneyArg("amount", arg, 0)
+	if err != nil {
+		return nil, err
+	}
+	if ctx.IsHighPrecision {
+		return AsValue(m.Amount), nil
+	}
+	return AsValue(m.Amount.InexactFloat64()), nil
+}
+
+// defCurrency currency(m) returns the currency code of m.
+func defCurrency(arg *Value) (*Value, error) {
+	m, err := moneyArg("currency", arg, 0)
+	if err != nil {
+		return nil, err
+	}
+	return AsValue(m.Currency), nil
+}
+
+// defAllocate allocate(m, 3, 7) or allocate(m, [3, 7]) splits m by ratios, see Money.Allocate.
+func defAllocate(ctx *EvaluatorContext, arg *Value, args ...*Value) (*Value, error) {
+	m, err := moneyArg("allocate", arg, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return nil, ArgumentNotEnoughErr.SetMessagef("allocate", ">=2", 1)
+	}
+	cols := make([]int, 0, len(args))
+	items := args
+	if len(args) == 1 && args[0].CanSlice() && !args[0].IsString() {
+		items = nil
+		args[0].Iterate(func(idx, count int, key, value *Value) bool {
+			items = append(items, key)
+			cols = append(cols, 1)
+			return true
+		}, func() {})
+	} else {
+		for i := range args {
+			cols = append(cols, i+1)
+		}
+	}
+	ratios := make([]decimal.Decimal, len(items))
+	total := decimal.Zero
+	for i, item := range items {
+		if inner, ok := item.Interface().(*Value); ok {
+			item = inner
+		}
+		if item.IsNil() || !item.IsNumber() {
+			return nil, ArgumentNotNumberErr.SetMessagef("allocate", item.Interface()).SetCol(cols[i])
+		}
+		ratios[i] = item.Decimal()
+		if ratios[i].Sign() < 0 {
+			return nil, ArgumentInvalidErr.SetMessagef("allocate", cols[i]+1).SetCol(cols[i])
+		}
+		total = total.Add(ratios[i])
+	}
+	if total.IsZero() {
+		return nil, ArgumentInvalidErr.SetMessagef("allocate", 2).SetCol(1)
+	}
+	return AsValue(m.Allocate(ratios, ctx.Numeric.Rounding)), nil
+}
+
+// defSplit split(m, n) splits m into n parts, see Money.Split.
+func defSplit(ctx *EvaluatorContext, arg, n *Value) (*Value, error) {
+	if arg.IsString() {
+		return splitString(arg, n)
+	}
+	m, err := moneyArg("split", arg, 0)
+	if err != nil {
+		return nil, err
+	}
+	if !n.IsNumber() {
+		return nil, ArgumentNotNumberErr.SetMessagef("split", n.Interface()).SetCol(1)
+	}
+	if n.Integer() <= 0 || !n.Decimal().Equal(decimal.NewFromInt(int64(n.Integer()))) {
+		return nil, ArgumentInvalidErr.SetMessagef("split", 2).SetCol(1)
+	}
+	return AsValue(m.Split(n.Integer(), ctx.Numeric.Rounding)), nil
+}
+
+// defFormatMoney format_money(m) formats m with the rounding of Numeric, see Money.Format.
+func defFormatMoney(ctx *EvaluatorContext, arg *Value) (*Value, error) {
+	m, err := moneyArg("format_money", arg, 0)
+	if err != nil {
+		return nil, err
+	}
+	return AsValue(m.Format(ctx.Numeric.Rounding)), nil
+}
@@ -0,0 +1,55 @@
+package mathxf
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestMoneyTimesAndDividedByNumber(t *testing.T) {
+	tests := []struct {
+		expr string
+		opts []Option
+		want string
+	}{
+		{`10.00 USD * 0.333`, nil, "3.33 USD"},
+		{`0.333 * 10.05 USD`, nil, "3.35 USD"},
+		{`10.05 USD * 0.333`, []Option{WithRounding(RoundDown)}, "3.34 USD"},
+		{`100 JPY * 0.015`, nil, "2 JPY"},
+		{`price * 3`, nil, "0.30 CNY"},
+		{`10 CNY / 3`, nil, "3.33 CNY"},
+		{`20 CNY / 3`, nil, "6.67 CNY"},
+		{`20 CNY / 3`, []Option{WithRounding(RoundDown)}, "6.66 CNY"},
+		{`-20 CNY / 3`, []Option{WithRounding(RoundFloor)}, "-6.67 CNY"},
+		{`1000 JPY / 3`, nil, "333 JPY"},
+		{`0.05 CNY / 2`, []Option{WithRounding(RoundHalfEven)}, "0.02 CNY"},
+		{`10 CNY / n`, nil, "3.33 CNY"},
+		{`10 CNY / 4 CNY`, nil, "2.5"},
+		{`10 CNY % 3`, nil, "1.00 CNY"},
+	}
+	for _, tt := range tests {
+		for _, hp := range []bool{true, false} {
+			for _, res := range runSrc(t, "res.x = "+tt.expr, map[string]any{"n": 3, "price": Money{Amount: decimal.RequireFromString("0.099"), Currency: "CNY"}}, append(tt.opts, WithHighPrecision(hp))...) {
+				if got := fmt.Sprint(res[DefResultKey]["x"].Interface()); got != tt.want {
+					t.Errorf("%s (hp=%v) = %s, want %s", tt.expr, hp, got, tt.want)
+				}
+			}
+		}
+	}
+}
+
+// TestCurrencyLiteral checks that only registered codes after a number make a money literal.
+func TestCurrencyLiteral(t *testing.T) {
+	for _, res := range runSrc(t, "res.x = 5 QQR", map[string]any{"QQR": 1}) {
+		if got := fmt.Sprint(res[DefResultKey]["x"].Interface()); got != "5" {
+			t.Errorf("res.x = %s, want 5", got)
+		}
+	}
+	RegisterCurrency(Currency{Code: "QQQ", Digits: 1, Symbol: "Q"})
+	for _, res := range runSrc(t, "res.x = 5 QQQ", nil) {
+		if got := fmt.Sprint(res[DefResultKey]["x"].Interface()); got != "5.0 QQQ" {
+			t.Errorf("res.x = %s, want 5.0 QQQ", got)
+		}
+	}
+}
@@ -10,6 +10,9 @@ type nodeDocument struct {
 
 func (n *nodeDocument) Execute(ctx *EvaluatorContext) error {
 	for _, node := range n.Nodes {
+		if err := ctx.step(); err != nil {
+			return ParseErr(err.SetToken(nodeToken(node)))
+		}
 		if err := node.Execute(ctx); err != nil {
 			return ParseErr(err)
 		}
@@ -17,12 +20,55 @@ func (n *nodeDocument) Execute(ctx *EvaluatorContext) error {
 	return nil
 }
 
+// nodeToken returns the token where node starts, errors of the step before node point at it.
+func nodeToken(node INode) *Token {
+	switch n := node.(type) {
+	case NodeResData:
+		return n.evl.GetPositionToken()
+	case *NodeAssignment:
+		return n.variable.GetPositionToken()
+	case NodeAssignment:
+		return n.variable.GetPositionToken()
+	case tagSetNode:
+		return n.locationToken
+	case *tagIfNode:
+		return n.ifTokens[0]
+	case *tagForNode:
+		return n.locationToken
+	case tagBreakNode:
+		return n.locationToken
+	case tagContinueNode:
+		return n.locationToken
+	case *tagFuncNode:
+		return n.locationToken
+	case *tagReturnNode:
+		return n.locationToken
+	case *customTagNode:
+		return n.nameToken
+	case evalNode:
+		return n.GetPositionToken()
+	}
+	return nil
+}
+
 type NodeWrapper struct {
-	nodes []INode
+	locationToken *Token
+	end           int // byte offset of the end of '}'
+	nodes         []INode
 }
 
 func (wrapper *NodeWrapper) Execute(ctx *EvaluatorContext) error {
+	if err := ctx.enter(wrapper.locationToken); err != nil {
+		return err
+	}
+	defer ctx.leave()
 	for _, n := range wrapper.nodes {
+		if err := ctx.step(); err != nil {
+			if pos := nodeToken(n); pos != nil {
+				return err.SetToken(pos)
+			}
+			return err.SetToken(wrapper.locationToken)
+		}
 		err := n.Execute(ctx)
 		if err != nil {
 			return err
@@ -41,10 +87,19 @@ func (n NodeResData) Execute(ctx *EvaluatorContext) error {
 	if err != nil {
 		return err
 	}
+	if val.IsNil() && isCall(n.evl) {
+		return nil
+	}
 	ctx.ResultMap[ctx.defResultKey][n.name] = val
 	return nil
 }
 
+// isCall reports whether e is a call like f(3), a statement calling a function that returns nothing records no result.
+func isCall(e IEvaluator) bool {
+	v, ok := e.(*variableResolver)
+	return ok && v.parts[len(v.parts)-1].isFunctionCall
+}
+
 type NodeAssignment struct {
 	variable *variableResolver
 	value    IEvaluator
@@ -53,3 +108,15 @@ type NodeAssignment struct {
 func (n NodeAssignment) Execute(ctx *EvaluatorContext) error {
 	return n.variable.SetPartValue(ctx, n.value)
 }
+
+// customTagNode records where a tag registered by RegisterTag or WithTag starts and ends,
+// the node returned by its TagParser is opaque to the parser.
+type customTagNode struct {
+	nameToken *Token
+	end       int
+	node      INode
+}
+
+func (n *customTagNode) Execute(ctx *EvaluatorContext) error {
+	return n.node.Execute(ctx)
+}
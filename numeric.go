@@ -0,0 +1,166 @@
+package mathxf
+
+import (
+	"context"
+	"math"
+
+	"github.com/shopspring/decimal"
+)
+
+// RoundingMode is the rounding of decimal results, see Numeric.
+type RoundingMode int
+
+const (
+	RoundHalfUp   RoundingMode = iota // half away from zero, the default
+	RoundHalfEven                     // half to even, banker's rounding
+	RoundDown                         // toward zero
+	RoundUp                           // away from zero
+	RoundCeiling                      // toward positive infinity
+	RoundFloor                        // toward negative infinity
+)
+
+var roundingModes = map[string]RoundingMode{
+	"half_up":   RoundHalfUp,
+	"half_even": RoundHalfEven,
+	"down":      RoundDown,
+	"up":        RoundUp,
+	"ceiling":   RoundCeiling,
+	"floor":     RoundFloor,
+}
+
+// ParseRoundingMode returns the mode of a name like "half_even", the names used by round(x, n, mode).
+func ParseRoundingMode(name string) (RoundingMode, bool) {
+	mode, ok := roundingModes[name]
+	return mode, ok
+}
+
+func (m RoundingMode) String() string {
+	for name, mode := range roundingModes {
+		if mode == m {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+// Numeric holds the settings of decimal arithmetic in high-precision mode.
+type Numeric struct {
+	DivScale    int32        // digits after the decimal point of /, % and ^ with a negative exponent
+	MathScale   int32        // digits after the decimal point of sqrt, exp, ln, the trigonometric functions and fractional ^
+	Rounding    RoundingMode // rounding of DivScale, of round without a mode and of ResultScale
+	ResultScale int32        // digits after the decimal point of numeric results, negative keeps them as they are
+}
+
+// DefNumeric is the default of Compile and NewTemplate, it divides like decimal.Div.
+var DefNumeric = Numeric{DivScale: 16, MathScale: 16, Rounding: RoundHalfUp, ResultScale: -1}
+
+type numericKey struct{}
+
+// ContextWithNumeric returns a copy of ctx that makes Program.Run use n instead of the settings of the program.
+func ContextWithNumeric(ctx context.Context, n Numeric) context.Context {
+	return context.WithValue(ctx, numericKey{}, n)
+}
+
+// Round rounds d to places digits after the decimal point.
+func (m RoundingMode) Round(d decimal.Decimal, places int32) decimal.Decimal {
+	switch m {
+	case RoundHalfEven:
+		return d.RoundBank(places)
+	case RoundDown:
+		return d.RoundDown(places)
+	case RoundUp:
+		return d.RoundUp(places)
+	case RoundCeiling:
+		return d.RoundCeil(places)
+	case RoundFloor:
+		return d.RoundFloor(places)
+	}
+	return d.Round(places)
+}
+
+// RoundFloat rounds f to places digits after the decimal point.
+func (m RoundingMode) RoundFloat(f float64, places int) float64 {
+	p := math.Pow10(places)
+	x := f * p
+	switch m {
+	case RoundHalfEven:
+		x = math.RoundToEven(x)
+	case RoundDown:
+		x = math.Trunc(x)
+	case RoundUp:
+		if x < 0 {
+			x = math.Floor(x)
+		} else {
+			x = math.Ceil(x)
+		}
+	case RoundCeiling:
+		x = math.Ceil(x)
+	case RoundFloor:
+		x = math.Floor(x)
+	default:
+		x = math.Round(x)
+	}
+	return x / p
+}
+
+// Div returns d1 / d2 rounded to DivScale digits, d2 must not be zero.
+func (n Numeric) Div(d1, d2 decimal.Decimal) decimal.Decimal {
+	q, r := d1.QuoRem(d2, n.DivScale)
+	if r.IsZero() {
+		return q
+	}
+	neg := d1.Sign()*d2.Sign() < 0
+	var away bool
+	switch n.Rounding {
+	case RoundDown:
+	case RoundUp:
+		away = true
+	case RoundCeiling:
+		away = !neg
+	case RoundFloor:
+		away = neg
+	default:
+		// compare the remainder with half a unit of the last digit of q, like decimal.DivRound
+		c := r.Abs().Mul(decimal.NewFromInt(2)).Shift(n.DivScale).Cmp(d2.Abs())
+		away = c > 0 || c == 0 && (n.Rounding == RoundHalfUp || q.Shift(n.DivScale).BigInt().Bit(0) == 1)
+	}
+	if !away {
+		return q
+	}
+	if neg {
+		return q.Sub(decimal.New(1, -n.DivScale))
+	}
+	return q.Add(decimal.New(1, -n.DivScale))
+}
+
+// Mod returns d1 % d2 with the sign of d1, d2 must not be zero.
+func (n Numeric) Mod(d1, d2 decimal.Decimal) decimal.Decimal {
+	_, r := d1.QuoRem(d2, 0)
+	return n.scale(r, n.DivScale)
+}
+
+// scale rounds d if it has more than places digits after the decimal point.
+func (n Numeric) scale(d decimal.Decimal, places int32) decimal.Decimal {
+	if d.Exponent() >= -places {
+		return d
+	}
+	return n.Rounding.Round(d, places)
+}
+
+// scaleResults rounds the numeric results of a run to ResultScale digits.
+func (ctx *EvaluatorContext) scaleResults() {
+	n := ctx.Numeric
+	if n.ResultScale < 0 {
+		return
+	}
+	for _, vals := range ctx.ResultMap {
+		for k, v := range vals {
+			switch val := v.Interface().(type) {
+			case decimal.Decimal:
+				vals[k] = AsValue(n.scale(val, n.ResultScale))
+			case float64:
+				vals[k] = AsValue(n.Rounding.RoundFloat(val, int(n.ResultScale)))
+			}
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package mathxf
+
+import (
+	"context"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestRoundingMode(t *testing.T) {
+	tests := []struct {
+		d    string
+		mode RoundingMode
+		want [2]string // of d and of -d
+	}{
+		{"2.125", RoundHalfUp, [2]string{"2.13", "-2.13"}},
+		{"2.125", RoundHalfEven, [2]string{"2.12", "-2.12"}},
+		{"2.375", RoundHalfEven, [2]string{"2.38", "-2.38"}},
+		{"2.341", RoundDown, [2]string{"2.34", "-2.34"}},
+		{"2.341", RoundUp, [2]string{"2.35", "-2.35"}},
+		{"2.341", RoundCeiling, [2]string{"2.35", "-2.34"}},
+		{"2.349", RoundFloor, [2]string{"2.34", "-2.35"}},
+	}
+	for _, tt := range tests {
+		d := decimal.RequireFromString(tt.d)
+		if got := tt.mode.Round(d, 2).String(); got != tt.want[0] {
+			t.Errorf("%v.Round(%s, 2) = %s, want %s", tt.mode, d, got, tt.want[0])
+		}
+		if got := tt.mode.Round(d.Neg(), 2).String(); got != tt.want[1] {
+			t.Errorf("%v.Round(-%s, 2) = %s, want %s", tt.mode, d, got, tt.want[1])
+		}
+		f, _ := d.Float64()
+		if got := decimal.NewFromFloat(tt.mode.RoundFloat(f, 2)).String(); got != tt.want[0] {
+			t.Errorf("%v.RoundFloat(%s, 2) = %s, want %s", tt.mode, d, got, tt.want[0])
+		}
+		if mode, ok := ParseRoundingMode(tt.mode.String()); !ok || mode != tt.mode {
+			t.Errorf("ParseRoundingMode(%q) = %v, %v", tt.mode.String(), mode, ok)
+		}
+	}
+}
+
+func TestNumeric(t *testing.T) {
+	tests := []struct {
+		src  string
+		opts []Option
+		ctx  *Numeric
+		want string
+	}{
+		{src: "res.x = 10 / 3", want: "3.3333333333333333"},
+		{src: "res.x = 10 / 3", opts: []Option{WithDivisionScale(4)}, want: "3.3333"},
+		{src: "res.x = 2 / 3", opts: []Option{WithDivisionScale(2), WithRounding(RoundDown)}, want: "0.66"},
+		{src: "res.x = 2 ^ -2", opts: []Option{WithDivisionScale(1)}, want: "0.3"},
+		{src: "res.x = 2 / 3", ctx: &Numeric{DivScale: 3, Rounding: RoundUp, ResultScale: -1}, want: "0.667"},
+		{src: "res.x = round(2.345, 2)", want: "2.35"},
+		{src: "res.x = round(2.345, 2)", opts: []Option{WithRounding(RoundHalfEven)}, want: "2.34"},
+		{src: `res.x = round(2.345, 2, "half_even")`, want: "2.34"},
+		{src: `res.x = round(-2.341, 2, "floor")`, want: "-2.35"},
+		{src: "res.x = 1.005 + 1\nres.y = \"1.005\"", opts: []Option{WithResultScale(2), WithRounding(RoundHalfEven)}, want: "2 1.005"},
+		{src: "res.x = 2.125", opts: []Option{WithResultScale(2), WithRounding(RoundHalfEven)}, want: "2.12"},
+	}
+	for _, tt := range tests {
+		p, err := Compile(tt.src, tt.opts...)
+		if err != nil {
+			t.Fatalf("Compile(%q): %v", tt.src, err)
+		}
+		ctx := context.Background()
+		if tt.ctx != nil {
+			ctx = ContextWithNumeric(ctx, *tt.ctx)
+		}
+		res, err := p.Run(ctx, nil)
+		if err != nil {
+			t.Fatalf("Run(%q): %v", tt.src, err)
+		}
+		got := res[DefResultKey]["x"].String()
+		if y, ok := res[DefResultKey]["y"]; ok {
+			got += " " + y.String()
+		}
+		if got != tt.want {
+			t.Errorf("%q: res = %s, want %s", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestRoundInvalidMode(t *testing.T) {
+	p, err := Compile(`res.x = round(2.345, 2, "half")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.Run(context.Background(), nil)
+	if e, ok := err.(*Error); !ok || e.Code != ArgumentInvalidErr.Code() {
+		t.Errorf("err = %v, want %v", err, ArgumentInvalidErr)
+	}
+}
@@ -0,0 +1,383 @@
+package mathxf
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/shopspring/decimal"
+)
+
+// constRef is a constant of AddFuncOrConst or DefConst inlined into a constResolver.
+type constRef struct {
+	name string
+	ele  *ValElement
+}
+
+// constResolver is a constant subexpression folded by the optimizer, its value is computed
+// for both precisions at compile time.
+type constResolver struct {
+	locationToken *Token
+	vals          [2]*Value   // indexed by IsHighPrecision
+	consts        []*constRef // inlined constants, orig is evaluated if one of them is shadowed at run time
+	numeric       *Numeric    // the settings of a folded /, % or ^, orig is evaluated if a run uses others
+	orig          IEvaluator
+}
+
+func (c *constResolver) GetPositionToken() *Token {
+	return c.locationToken
+}
+
+func (c *constResolver) Evaluate(ctx *EvaluatorContext) (*Value, error) {
+	if err := ctx.step(); err != nil {
+		return nil, err.SetToken(c.GetPositionToken())
+	}
+	if c.numeric != nil && *c.numeric != ctx.Numeric {
+		return c.orig.Evaluate(ctx)
+	}
+	for _, ref := range c.consts {
+		if ele, _ := ctx.Lookup(ref.name); ele != ref.ele {
+			return c.orig.Evaluate(ctx)
+		}
+	}
+	return c.vals[boolIndex(ctx.IsHighPrecision)], nil
+}
+
+// identityExpression is x*1, 1*x, x+0, 0+x or x-0. A number x is only converted like the
+// operator would, other operands are left to the operator, e.g. a string concatenated with 0.
+type identityExpression struct {
+	x         IEvaluator
+	k         *constResolver
+	kLeft     bool // k is the left operand
+	opToken   *Token
+	kPosition *Token // the position of the right operand
+}
+
+func (n *identityExpression) GetPositionToken() *Token {
+	if n.kLeft {
+		return n.k.GetPositionToken()
+	}
+	return n.x.GetPositionToken()
+}
+
+func (n *identityExpression) Evaluate(ctx *EvaluatorContext) (*Value, error) {
+	if err := ctx.step(); err != nil {
+		return nil, err.SetToken(n.GetPositionToken())
+	}
+	v, err := n.x.Evaluate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return n.eval(ctx, v)
+}
+
+// eval applies the identity to the value v of x, it is shared by the tree-walker and the VM.
+func (n *identityExpression) eval(ctx *EvaluatorContext, v *Value) (*Value, error) {
+	if !v.IsNil() && v.IsNumber() {
+		if ctx.IsHighPrecision {
+			return AsValue(v.Decimal()), nil
+		}
+		return AsValue(v.Float()), nil
+	}
+	v1, v2 := v, n.k.vals[boolIndex(ctx.IsHighPrecision)]
+	if n.kLeft {
+		v1, v2 = v2, v1
+	}
+	if n.opToken.typ == TokenMul {
+		return evalTerm(ctx, n.opToken, v1, v2, n.kPosition)
+	}
+	return evalSimple(ctx, n.opToken, v1, v2)
+}
+
+// optimizer folds the constant subexpressions of a document, removes the branches of if
+// statements with a constant condition and simplifies x*1 and x+0. Changed nodes are copied,
+// the parsed document stays intact for Program.AST.
+type optimizer struct {
+	ctxs [2][2]*EvaluatorContext // compile-time contexts, indexed by IsHighPrecision and IsStrict
+}
+
+func (p *Program) optimizeDocument(doc *nodeDocument) *nodeDocument {
+	o := new(optimizer)
+	for hp := range o.ctxs {
+		for strict := range o.ctxs[hp] {
+			ctx := p.newContext(context.TODO())
+			ctx.IsHighPrecision = hp == 1
+			ctx.IsStrict = strict == 1
+			o.ctxs[hp][strict] = ctx
+		}
+	}
+	return &nodeDocument{Nodes: o.nodes(doc.Nodes)}
+}
+
+func (o *optimizer) nodes(nodes []INode) []INode {
+	res := make([]INode, 0, len(nodes))
+	for _, node := range nodes {
+		if node = o.node(node); node != nil {
+			res = append(res, node)
+		}
+	}
+	return res
+}
+
+func (o *optimizer) wrapper(w *NodeWrapper) *NodeWrapper {
+	return &NodeWrapper{locationToken: w.locationToken, end: w.end, nodes: o.nodes(w.nodes)}
+}
+
+// node returns the optimized node, nil if it has no effect.
+func (o *optimizer) node(node INode) INode {
+	switch n := node.(type) {
+	case NodeResData:
+		return NodeResData{name: n.name, evl: o.expr(n.evl)}
+	case *NodeAssignment:
+		return &NodeAssignment{variable: n.variable, value: o.expr(n.value)}
+	case tagSetNode:
+		res := n
+		res.setNodes = make([]*SetNode, len(n.setNodes))
+		var shared IEvaluator
+		for i, set := range n.setNodes {
+			if i == 0 || !n.isAssign {
+				shared = o.expr(set.expression)
+			}
+			res.setNodes[i] = &SetNode{nameToken: set.nameToken, name: set.name, expression: shared}
+		}
+		return res
+	case *tagIfNode:
+		return o.ifNode(n)
+	case *tagForNode:
+		res := *n
+		res.iterable = o.expr(n.iterable)
+		res.wrapper = o.wrapper(n.wrapper)
+		return &res
+	case *tagFuncNode:
+		res := *n
+		res.body = o.wrapper(n.body)
+		return &res
+	case *tagReturnNode:
+		if n.expr == nil {
+			return n
+		}
+		return &tagReturnNode{locationToken: n.locationToken, expr: o.expr(n.expr)}
+	}
+	return node
+}
+
+// ifNode drops the branches whose condition is constant false, a constant true condition
+// makes its branch the else branch of the remaining conditions.
+func (o *optimizer) ifNode(n *tagIfNode) INode {
+	res := new(tagIfNode)
+	for i, cond := range n.conditions {
+		cond = o.expr(cond)
+		if b, ok := constBool(cond); ok {
+			if !b {
+				continue
+			}
+			w := o.wrapper(n.wrappers[i])
+			if len(res.conditions) == 0 {
+				return w
+			}
+			res.wrappers = append(res.wrappers, w)
+			return res
+		}
+		res.ifTokens = append(res.ifTokens, n.ifTokens[i])
+		res.conditions = append(res.conditions, cond)
+		res.wrappers = append(res.wrappers, o.wrapper(n.wrappers[i]))
+	}
+	if len(n.wrappers) > len(n.conditions) {
+		w := o.wrapper(n.wrappers[len(n.conditions)])
+		if len(res.conditions) == 0 {
+			return w
+		}
+		res.wrappers = append(res.wrappers, w)
+	}
+	if len(res.conditions) == 0 {
+		return nil
+	}
+	return res
+}
+
+// constBool reports the value of a constant bool condition without inlined constants.
+func constBool(e IEvaluator) (bool, bool) {
+	c, ok := e.(*constResolver)
+	if !ok || len(c.consts) > 0 {
+		return false, false
+	}
+	v1, v2 := c.vals[0], c.vals[1]
+	if !v1.IsBool() || !v2.IsBool() || v1.IsTrue() != v2.IsTrue() {
+		return false, false
+	}
+	return v1.IsTrue(), true
+}
+
+func (o *optimizer) expr(e IEvaluator) IEvaluator {
+	switch n := e.(type) {
+	case *numberResolver, *boolResolver, *stringResolver, *moneyResolver, *durationResolver:
+		return o.fold(e)
+	case *variableResolver:
+		return o.variable(n)
+	case *arrayResolver:
+		res := *n
+		res.parts = o.parts(n.parts)
+		return &res
+	case *Expression:
+		if n.expr2 == nil {
+			return o.expr(n.expr1)
+		}
+		res := &Expression{expr1: o.expr(n.expr1), expr2: o.expr(n.expr2), opToken: n.opToken}
+		return o.fold(res, res.expr1, res.expr2)
+	case *ternaryExpression:
+		res := &ternaryExpression{cond: o.expr(n.cond), expr1: o.expr(n.expr1), expr2: o.expr(n.expr2), opToken: n.opToken}
+		return o.fold(res, res.cond, res.expr1, res.expr2)
+	case *relationalExpression:
+		if n.expr2 == nil {
+			return o.expr(n.expr1)
+		}
+		res := &relationalExpression{expr1: o.expr(n.expr1), expr2: o.expr(n.expr2), opToken: n.opToken}
+		return o.fold(res, res.expr1, res.expr2)
+	case *simpleExpression:
+		if n.term2 == nil {
+			return o.expr(n.term1)
+		}
+		res := &simpleExpression{term1: o.expr(n.term1), term2: o.expr(n.term2), opToken: n.opToken}
+		if folded := o.fold(res, res.term1, res.term2); folded != IEvaluator(res) {
+			return folded
+		}
+		return o.identity(res, res.term1, res.term2, res.opToken)
+	case *termExpression:
+		if n.factor2 == nil {
+			return o.expr(n.factor1)
+		}
+		res := &termExpression{factor1: o.expr(n.factor1), factor2: o.expr(n.factor2), opToken: n.opToken}
+		if folded := o.fold(res, res.factor1, res.factor2); folded != IEvaluator(res) {
+			return folded
+		}
+		return o.identity(res, res.factor1, res.factor2, res.opToken)
+	case *powerExpression:
+		if n.power2 == nil {
+			return o.expr(n.power1)
+		}
+		res := &powerExpression{power1: o.expr(n.power1), power2: o.expr(n.power2), opToken: n.opToken}
+		return o.fold(res, res.power1, res.power2)
+	case *unaryExpression:
+		res := &unaryExpression{expr: o.expr(n.expr), opToken: n.opToken}
+		return o.fold(res, res.expr)
+	}
+	return e
+}
+
+// variable inlines a constant with a number, string or bool value and optimizes the
+// subscripts and arguments of other variables.
+func (o *optimizer) variable(v *variableResolver) IEvaluator {
+	root := v.parts[0]
+	if len(v.parts) == 1 && !root.isFunctionCall {
+		ele, ok := o.ctxs[0][0].Lookup(root.name)
+		if ok && ele.ValType == ConstVal && !ele.IsFunc {
+			switch typeOfValue(AsValue(ele.Val)).Kind {
+			case KindNumber, KindString, KindBool:
+				if res, ok := o.fold(v).(*constResolver); ok {
+					res.consts = []*constRef{{name: root.name, ele: ele}}
+					if _, ok := ele.Val.(mathConst); ok {
+						res.numeric = &o.ctxs[0][0].Numeric
+					}
+					return res
+				}
+			}
+		}
+		return v
+	}
+	res := *v
+	res.parts = o.parts(v.parts)
+	return &res
+}
+
+func (o *optimizer) parts(parts []*variablePart) []*variablePart {
+	res := make([]*variablePart, len(parts))
+	for i, part := range parts {
+		p := *part
+		if p.subscript != nil {
+			p.subscript = o.expr(p.subscript)
+		}
+		if p.callingArgs != nil {
+			p.callingArgs = make([]IEvaluator, len(part.callingArgs))
+			for j, arg := range part.callingArgs {
+				p.callingArgs[j] = o.expr(arg)
+			}
+		}
+		res[i] = &p
+	}
+	return res
+}
+
+// fold evaluates e at compile time if its operands are constant. e is returned unchanged
+// if the evaluation fails, which is then reported at run time, or if strict mode would
+// give a different result.
+func (o *optimizer) fold(e IEvaluator, operands ...IEvaluator) IEvaluator {
+	res := &constResolver{locationToken: e.GetPositionToken(), orig: e}
+	for _, operand := range operands {
+		c, ok := operand.(*constResolver)
+		if !ok {
+			return e
+		}
+		res.consts = append(res.consts, c.consts...)
+		if c.numeric != nil {
+			res.numeric = c.numeric
+		}
+	}
+	switch n := e.(type) {
+	case *termExpression:
+		if n.opToken.typ != TokenMul {
+			res.numeric = &o.ctxs[0][0].Numeric
+		}
+	case *powerExpression:
+		res.numeric = &o.ctxs[0][0].Numeric
+	}
+	for hp, ctxs := range o.ctxs {
+		var vals [2]*Value
+		for strict, ctx := range ctxs {
+			v, err := e.Evaluate(ctx)
+			if err != nil {
+				return e
+			}
+			vals[strict] = v
+		}
+		if !reflect.DeepEqual(vals[0].Interface(), vals[1].Interface()) {
+			return e
+		}
+		res.vals[hp] = vals[0]
+	}
+	return res
+}
+
+// identity simplifies x*1, 1*x, x+0, 0+x and x-0, e is returned if it is none of them.
+func (o *optimizer) identity(e, x, y IEvaluator, op *Token) IEvaluator {
+	n := &identityExpression{opToken: op, kPosition: y.GetPositionToken()}
+	var want decimal.Decimal
+	switch op.typ {
+	case TokenAdd, TokenSub:
+		want = decimal.Zero
+	case TokenMul:
+		want = decimal.NewFromInt(1)
+	default:
+		return e
+	}
+	if k, ok := y.(*constResolver); ok && isConstNumber(k, want) {
+		n.x, n.k = x, k
+	} else if k, ok := x.(*constResolver); ok && op.typ != TokenSub && isConstNumber(k, want) {
+		n.x, n.k, n.kLeft = y, k, true
+	} else {
+		return e
+	}
+	return n
+}
+
+// isConstNumber reports whether k is the literal number want, a float64 without high precision
+// and a decimal with exponent 0 otherwise, e.g. not 3%2 which is an int.
+func isConstNumber(k *constResolver, want decimal.Decimal) bool {
+	if len(k.consts) > 0 {
+		return false
+	}
+	f, ok := k.vals[0].Interface().(float64)
+	if !ok || f != want.InexactFloat64() {
+		return false
+	}
+	d, ok := k.vals[1].Interface().(decimal.Decimal)
+	return ok && d.Exponent() == 0 && d.Equal(want)
+}
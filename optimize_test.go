@@ -0,0 +1,84 @@
+package mathxf
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+)
+
+// TestOptimize checks that the optimized document gives the results and errors of the parsed one.
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		src  string
+		env  map[string]any
+		opts []Option
+	}{
+		{src: "res.x = 1 + 2 * 6 / 4 + (456 - 8 * 9.2) - (2 + 4 ^ 5)"},
+		{src: "res.x = pi * 2\nres.y = 10 / 3"},
+		{src: "res.x = a * 1 + 0\nres.y = 0 + b\nres.z = s + 0", env: map[string]any{"a": 1.5, "b": 2, "s": "s"}},
+		{src: "if true { res.x = 1 } else { res.x = 2 }\nif false { res.y = 1 }\nif 1 > 2 { res.z = 1 } else if a > 0 { res.z = 2 }", env: map[string]any{"a": 1}},
+		{src: "res.x = k * 3", opts: []Option{WithFuncOrConst("k", 2)}},
+		{src: "res.x = k * 3", env: map[string]any{"k": 5}, opts: []Option{WithFuncOrConst("k", 2)}},
+		{src: "val s = 0\nfor i in items { s = s + i * (2 - 1) }\nres.s = s", env: map[string]any{"items": []int{1, 2, 3}}},
+		{src: "res.x = 1 / (2 - 2)"},
+		{src: "res.x = a + 1 / 0", env: map[string]any{"a": 1}},
+	}
+	for _, tt := range tests {
+		for _, hp := range []bool{true, false} {
+			var want string
+			for _, optimize := range []bool{false, true} {
+				opts := append(tt.opts, WithHighPrecision(hp), WithOptimize(optimize))
+				p, err := Compile(tt.src, opts...)
+				if err != nil {
+					t.Fatalf("Compile(%q): %v", tt.src, err)
+				}
+				res, err := p.Run(context.Background(), tt.env)
+				got := fmt.Sprint(err)
+				if err == nil {
+					var kv []string
+					for k, v := range res[DefResultKey] {
+						kv = append(kv, fmt.Sprintf("%s=%v", k, v.Interface()))
+					}
+					sort.Strings(kv)
+					got = fmt.Sprint(kv)
+				}
+				if !optimize {
+					want = got
+				} else if got != want {
+					t.Errorf("%q hp=%v: optimized %s, want %s", tt.src, hp, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestOptimizeFolds(t *testing.T) {
+	tests := []struct {
+		src   string
+		nodes int // the number of statements left
+		fold  bool
+	}{
+		{"res.x = 1 + 2 * 3", 1, true},
+		{"res.x = pi * 2", 1, true},
+		{"res.x = a + 1", 1, false},
+		{"if false { res.x = 1 }\nres.y = 2", 1, true},
+		{"if 1 < 2 { res.x = 1 } else { res.x = 2 }", 1, false},
+	}
+	for _, tt := range tests {
+		p, err := Compile(tt.src)
+		if err != nil {
+			t.Fatalf("Compile(%q): %v", tt.src, err)
+		}
+		nodes := p.exec.Nodes
+		if len(nodes) != tt.nodes {
+			t.Errorf("%q: %d statements, want %d", tt.src, len(nodes), tt.nodes)
+			continue
+		}
+		if n, ok := nodes[len(nodes)-1].(NodeResData); ok {
+			if _, fold := n.evl.(*constResolver); fold != tt.fold {
+				t.Errorf("%q: folded %v, want %v", tt.src, fold, tt.fold)
+			}
+		}
+	}
+}
@@ -5,9 +5,7 @@ import (
 )
 
 func Parse(tpl string) (*Parser, error) {
-	l := lex(tpl)
-	l.run()
-	return &Parser{lex: l, tags: defTags()}, nil
+	return &Parser{lex: lex(tpl), tags: defTags()}, nil
 }
 func (p *Parser) ParseDocument() (*nodeDocument, error) {
 	doc := &nodeDocument{
@@ -15,22 +13,91 @@ func (p *Parser) ParseDocument() (*nodeDocument, error) {
 	}
 	ind := 1
 	for p.PeekToken().typ != TokenEOF {
+		start := p.PeekToken()
 		node, err := p.parseDocElement(ind)
 		if err != nil {
-			return nil, ParseErr(err)
+			if !p.recovering {
+				return nil, ParseErr(err)
+			}
+			p.recover(err, start, false)
+			continue
 		}
 		doc.Nodes = append(doc.Nodes, node)
 		ind++
 	}
 	return doc, nil
 }
+
+// ParseDocumentRecover parses like ParseDocument, but instead of stopping at the first error it skips
+// to the next statement or closing brace and goes on. It returns the partial document and all errors.
+func (p *Parser) ParseDocumentRecover() (*nodeDocument, []error) {
+	p.recovering = true
+	defer func() {
+		p.recovering = false
+	}()
+	doc, _ := p.ParseDocument()
+	return doc, p.errs
+}
+
+// recover records err and skips the tokens of the broken statement that started at start.
+// It stops before a '}' closing the current wrapper, after a ';' or at the first identifier
+// on a line after the error. Nested braces are skipped as a whole.
+func (p *Parser) recover(err error, start Token, inWrapper bool) {
+	e := ParseErr(err)
+	if len(p.errs) == 0 || p.errs[len(p.errs)-1].Error() != e.Error() {
+		p.errs = append(p.errs, e)
+	}
+	last := p.lastToken()
+	errLine := last.line
+	depth := 0
+	switch last.typ {
+	case TokenLeftBigBrackets:
+		// the block of the broken statement is already opened
+		depth = 1
+	case TokenRightBigBrackets:
+		// the failing token closes a block, hand it back to the wrapper
+		p.Backup()
+	}
+	for {
+		t := p.PeekToken()
+		if t.typ == TokenEOF || t.typ == TokenError {
+			break
+		}
+		if depth == 0 {
+			if t.typ == TokenSemicolon {
+				p.NextToken()
+				break
+			}
+			if t.typ == TokenRightBigBrackets {
+				if !inWrapper {
+					p.NextToken()
+				}
+				break
+			}
+			if t.typ == TokenIdentifier && t.line > errLine && t.pos != start.pos {
+				break
+			}
+		}
+		switch t.typ {
+		case TokenLeftBigBrackets:
+			depth++
+		case TokenRightBigBrackets:
+			depth--
+		}
+		p.NextToken()
+	}
+	if p.PeekToken().pos == start.pos && p.PeekToken().typ != TokenEOF {
+		p.NextToken()
+	}
+}
+
 func (p *Parser) parseDocElement(ind int) (INode, error) {
 	t := p.PeekToken()
 	if t.typ == TokenIdentifier {
 		tagParser, ok := p.tags[t.val]
 		if ok {
 			p.NextToken()
-			return tagParser(p)
+			return p.parseTag(t, tagParser)
 		}
 	} else {
 		evl, err := p.ParseExpression()
@@ -46,7 +113,12 @@ func (p *Parser) parseDocElement(ind int) (INode, error) {
 	next := p.NextToken()
 	if next.typ != TokenAssign {
 		p.Backup()
-		return NodeResData{name: fmt.Sprintf("res%d", ind), evl: vRes}, nil
+		// the variable is the first operand of an expression, e.g. `level > 3 ? 0.3 : 0.1`
+		evl, err := p.parseExpressionFrom(vRes)
+		if err != nil {
+			return nil, err
+		}
+		return NodeResData{name: fmt.Sprintf("res%d", ind), evl: evl}, nil
 	}
 
 	exp2, err := p.ParseExpression()
@@ -63,67 +135,96 @@ func (p *Parser) WrapUntil() (*NodeWrapper, error) {
 	peek := p.PeekToken()
 	if peek.typ == TokenLeftBigBrackets {
 		p.NextToken()
-		wrapper := new(NodeWrapper)
+		wrapper := &NodeWrapper{locationToken: &peek}
 		for {
 			t := p.PeekToken()
 			switch t.typ {
 			case TokenEOF:
-				return nil, WrapperUnclosedErr.SetPosition(t.line, t.col)
+				return nil, WrapperUnclosedErr.SetToken(&t)
 			case TokenRightBigBrackets:
-				p.NextToken()
+				wrapper.end = p.NextToken().end
 				return wrapper, nil
 			}
+			var node INode
+			var err error
 			tagParser, ok := p.tags[t.val]
 			if ok {
 				p.NextToken()
-				tagNode, err := tagParser(p)
-				if err != nil {
+				node, err = p.parseTag(t, tagParser)
+			} else {
+				node, err = p.ParseAssignment(p.NextToken())
+			}
+			if err != nil {
+				if !p.recovering {
 					return nil, err
 				}
-				wrapper.nodes = append(wrapper.nodes, tagNode)
+				p.recover(err, t, true)
 				continue
 			}
-			n := p.NextToken()
-			assign, err := p.ParseAssignment(n)
-			if err != nil {
-				return nil, err
-			}
-			wrapper.nodes = append(wrapper.nodes, assign)
+			wrapper.nodes = append(wrapper.nodes, node)
 		}
 	}
-	return nil, UnexpectedTokenErr.SetMessagef("wrapUntil", peek.val).SetPosition(peek.line, peek.col)
+	return nil, UnexpectedTokenErr.SetMessagef("wrapUntil", peek.spelling()).SetToken(&peek)
+}
+
+// parseTag runs the parser of the tag named by t, the nodes of custom tags are wrapped to keep their span.
+func (p *Parser) parseTag(t Token, tagParser TagParser) (INode, error) {
+	node, err := tagParser(p)
+	if err != nil {
+		return nil, err
+	}
+	switch node.(type) {
+	case *tagIfNode, tagSetNode, *tagForNode, tagBreakNode, tagContinueNode, *tagFuncNode, *tagReturnNode:
+		return node, nil
+	}
+	return &customTagNode{nameToken: &t, end: p.lastToken().end, node: node}, nil
 }
 
 type Parser struct {
 	lex *lexer
 
-	peekTokens [3]Token // three-token lookahead for Parser.
-	peekCount  int
+	tokens     []Token // tokens read from the lexer so far, for unlimited lookahead and backup
+	next       int     // index of the next token in tokens
+	loopDepth  int     // nesting depth of for loops, break and continue are only valid inside a loop
+	funcDepth  int     // nesting depth of func bodies, return is only valid inside a function
+	recovering bool    // collect errors into errs and go on parsing, see ParseDocumentRecover
+	errs       []error
 
 	tags map[string]TagParser
 }
 
 func (p *Parser) PeekToken() Token {
-	if p.peekCount > 0 {
-		return p.peekTokens[p.peekCount-1]
+	return p.PeekTokenN(0)
+}
+
+// PeekTokenN returns the n-th token after the next one without consuming it, PeekTokenN(0) is PeekToken.
+func (p *Parser) PeekTokenN(n int) Token {
+	for len(p.tokens) <= p.next+n {
+		p.tokens = append(p.tokens, p.lex.nextToken())
 	}
-	p.peekCount = 1
-	p.peekTokens[0] = p.lex.nextToken()
-	return p.peekTokens[0]
+	return p.tokens[p.next+n]
 }
 
 func (p *Parser) NextToken() Token {
-	if p.peekCount > 0 {
-		p.peekCount--
-	} else {
-		p.peekTokens[0] = p.lex.nextToken()
+	t := p.PeekToken()
+	p.next++
+	return t
+}
+
+// lastToken returns the token most recently returned by NextToken.
+func (p *Parser) lastToken() Token {
+	if p.next == 0 {
+		return Token{}
 	}
-	return p.peekTokens[p.peekCount]
+	return p.tokens[p.next-1]
 }
 
+// Backup steps back one token, it can be called repeatedly up to the first token.
 func (p *Parser) Backup() {
-	p.peekCount++
+	if p.next > 0 {
+		p.next--
+	}
 }
 func (p *Parser) Ignore() {
-	p.peekCount--
+	p.NextToken()
 }
@@ -1,7 +1,11 @@
 package mathxf
 
 import (
+	"fmt"
 	"strconv"
+	"strings"
+
+	"github.com/shopspring/decimal"
 )
 
 func (p *Parser) ParseAssignment(t Token) (INode, error) {
@@ -11,7 +15,7 @@ func (p *Parser) ParseAssignment(t Token) (INode, error) {
 	}
 	next := p.NextToken()
 	if next.typ != TokenAssign {
-		return nil, UnexpectedTokenErr.SetMessagef("assignment", next.val).SetPosition(next.line, next.col)
+		return nil, UnexpectedTokenErr.SetMessagef("assignment", next.spelling()).SetToken(&next)
 	}
 	exp2, err := p.ParseExpression()
 	if err != nil {
@@ -24,7 +28,42 @@ func (p *Parser) ParseAssignment(t Token) (INode, error) {
 }
 
 func (p *Parser) ParseExpression() (IEvaluator, error) {
-	expr1, err := p.parseRelationalExpression()
+	return p.parseExpressionFrom(nil)
+}
+
+// parseExpressionFrom parses an expression whose first operand primary is already parsed,
+// e.g. the variable of a statement that turned out not to be an assignment. A nil primary
+// is parsed from the tokens, the parse functions below take it the same way.
+func (p *Parser) parseExpressionFrom(primary IEvaluator) (IEvaluator, error) {
+	cond, err := p.parseLogicalExpression(primary)
+	if err != nil {
+		return nil, err
+	}
+	if p.PeekToken().typ != TokenTernary {
+		return cond, nil
+	}
+	op := p.NextToken()
+	expr1, err := p.ParseExpression()
+	if err != nil {
+		return nil, err
+	}
+	colon := p.NextToken()
+	if colon.typ != TokenColon {
+		return nil, UnexpectedTokenErr.SetMessagef("ternary", colon.spelling()).SetToken(&colon)
+	}
+	expr2, err := p.ParseExpression()
+	if err != nil {
+		return nil, err
+	}
+	return &ternaryExpression{
+		cond:    cond,
+		expr1:   expr1,
+		expr2:   expr2,
+		opToken: &op,
+	}, nil
+}
+func (p *Parser) parseLogicalExpression(primary IEvaluator) (IEvaluator, error) {
+	expr1, err := p.parseRelationalExpression(primary)
 	if err != nil {
 		return nil, err
 	}
@@ -34,7 +73,7 @@ func (p *Parser) ParseExpression() (IEvaluator, error) {
 	peek := p.PeekToken()
 	if peek.typ == TokenAnd || peek.typ == TokenOr {
 		op := p.NextToken()
-		expr2, err := p.ParseExpression()
+		expr2, err := p.parseLogicalExpression(nil)
 		if err != nil {
 			return nil, err
 		}
@@ -44,8 +83,8 @@ func (p *Parser) ParseExpression() (IEvaluator, error) {
 	}
 	return exp.expr1, nil
 }
-func (p *Parser) parseRelationalExpression() (IEvaluator, error) {
-	expr1, err := p.parseSimpleExpression()
+func (p *Parser) parseRelationalExpression(primary IEvaluator) (IEvaluator, error) {
+	expr1, err := p.parseSimpleExpression(primary)
 	if err != nil {
 		return nil, err
 	}
@@ -56,7 +95,7 @@ func (p *Parser) parseRelationalExpression() (IEvaluator, error) {
 	switch peek.typ {
 	case TokenEquals, TokenNotEquals, TokenGreat, TokenGreatEquals, TokenLess, TokenLessEquals:
 		op := p.NextToken()
-		expr2, err := p.parseRelationalExpression()
+		expr2, err := p.parseRelationalExpression(nil)
 		if err != nil {
 			return nil, err
 		}
@@ -65,7 +104,7 @@ func (p *Parser) parseRelationalExpression() (IEvaluator, error) {
 		return expr, nil
 	case TokenIn:
 		op := p.NextToken()
-		expr2, err := p.parseSimpleExpression()
+		expr2, err := p.parseSimpleExpression(nil)
 		if err != nil {
 			return nil, err
 		}
@@ -76,8 +115,8 @@ func (p *Parser) parseRelationalExpression() (IEvaluator, error) {
 		return expr.expr1, nil
 	}
 }
-func (p *Parser) parseSimpleExpression() (IEvaluator, error) {
-	term1, err := p.parseTerm()
+func (p *Parser) parseSimpleExpression(primary IEvaluator) (IEvaluator, error) {
+	term1, err := p.parseTerm(primary)
 	if err != nil {
 		return nil, err
 	}
@@ -94,7 +133,7 @@ func (p *Parser) parseSimpleExpression() (IEvaluator, error) {
 				}
 			}
 			op := p.NextToken()
-			term2, err := p.parseTerm()
+			term2, err := p.parseTerm(nil)
 			if err != nil {
 				return nil, err
 			}
@@ -109,8 +148,8 @@ func (p *Parser) parseSimpleExpression() (IEvaluator, error) {
 	}
 
 }
-func (p *Parser) parseTerm() (IEvaluator, error) {
-	factor1, err := p.parsePower()
+func (p *Parser) parseTerm(primary IEvaluator) (IEvaluator, error) {
+	factor1, err := p.parseUnary(primary)
 	if err != nil {
 		return nil, err
 	}
@@ -127,7 +166,7 @@ func (p *Parser) parseTerm() (IEvaluator, error) {
 				}
 			}
 			op := p.NextToken()
-			factor2, err := p.parsePower()
+			factor2, err := p.parseUnary(nil)
 			if err != nil {
 				return nil, err
 			}
@@ -141,21 +180,44 @@ func (p *Parser) parseTerm() (IEvaluator, error) {
 		}
 	}
 }
-func (p *Parser) parsePower() (IEvaluator, error) {
-	power1, err := p.parseFactor()
-	if err != nil {
-		return nil, err
+func (p *Parser) parseUnary(primary IEvaluator) (IEvaluator, error) {
+	if primary != nil {
+		return p.parsePower(primary)
+	}
+	switch p.PeekToken().typ {
+	case TokenNot, TokenSub, TokenAdd:
+		op := p.NextToken()
+		expr, err := p.parseUnary(nil)
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpression{
+			expr:    expr,
+			opToken: &op,
+		}, nil
+	default:
+		return p.parsePower(nil)
+	}
+}
+func (p *Parser) parsePower(primary IEvaluator) (IEvaluator, error) {
+	power1 := primary
+	if power1 == nil {
+		var err error
+		if power1, err = p.parseFactor(); err != nil {
+			return nil, err
+		}
 	}
 	powerObj := &powerExpression{
 		power1: power1,
 	}
 	if p.PeekToken().typ == TokenPow {
-		p.NextToken()
-		power2, err := p.parsePower()
+		op := p.NextToken()
+		power2, err := p.parseUnary(nil)
 		if err != nil {
 			return nil, err
 		}
 		powerObj.power2 = power2
+		powerObj.opToken = &op
 		return powerObj, nil
 	}
 	return powerObj.power1, nil
@@ -172,7 +234,7 @@ func (p *Parser) parseFactor() (IEvaluator, error) {
 			p.NextToken()
 			return expr, nil
 		}
-		return nil, MissingRightParenErr.SetMessagef(")").SetPosition(peek.line, peek.col)
+		return nil, MissingRightParenErr.SetMessagef(")").SetToken(&peek)
 	}
 	return p.parseVariableOrLiteral()
 }
@@ -180,9 +242,9 @@ func (p *Parser) parseVariableOrLiteral() (IEvaluator, error) {
 	t := p.NextToken()
 	switch t.typ {
 	case TokenEOF:
-		return nil, UnexpectedEofErr.SetPosition(t.line, t.col)
+		return nil, UnexpectedEofErr.SetToken(&t)
 	case TokenError:
-		return nil, LexerTokenErr.SetMessagef(t.val).SetPosition(t.line, t.col)
+		return nil, LexerTokenErr.SetMessagef(t.val).SetToken(&t)
 	case TokenNumber:
 		f, err := strconv.ParseFloat(t.val, 64)
 		if err != nil {
@@ -193,6 +255,20 @@ func (p *Parser) parseVariableOrLiteral() (IEvaluator, error) {
 			val:           f,
 		}
 		return fr, nil
+	case TokenMoney:
+		fields := strings.Fields(t.val)
+		amount, err := decimal.NewFromString(fields[0])
+		if err != nil {
+			return nil, LexerTokenErr.SetMessagef(fmt.Sprintf("bad money syntax: %q", t.val)).SetToken(&t)
+		}
+		m, err := NewMoney(amount, fields[1])
+		if err != nil {
+			return nil, Cause(err).SetToken(&t)
+		}
+		return &moneyResolver{locationToken: &t, val: m}, nil
+	case TokenDuration:
+		d, _ := scanDuration(t.val)
+		return &durationResolver{locationToken: &t, val: d}, nil
 	case TokenBool:
 		b, err := strconv.ParseBool(t.val)
 		if err != nil {
@@ -224,13 +300,13 @@ func (p *Parser) parseVariableOrLiteral() (IEvaluator, error) {
 			locationToken: &t,
 		}
 		if p.PeekToken().typ == TokenRightBrackets {
-			p.NextToken()
+			arr.end = p.NextToken().end
 			return arr, nil
 		}
 		for {
 			peek := p.PeekToken()
 			if peek.typ == TokenEOF {
-				return nil, UnexpectedEofErr.SetPosition(peek.line, peek.col)
+				return nil, UnexpectedEofErr.SetToken(&peek)
 			}
 			exprArg, err := p.ParseExpression()
 			if err != nil {
@@ -241,12 +317,12 @@ func (p *Parser) parseVariableOrLiteral() (IEvaluator, error) {
 				subscript: exprArg,
 			})
 			if p.PeekToken().typ == TokenRightBrackets {
-				p.NextToken()
+				arr.end = p.NextToken().end
 				break
 			}
 			next := p.NextToken()
 			if next.typ != TokenComma {
-				return nil, MissingRightParenErr.SetMessagef("]").SetPosition(next.line, next.col)
+				return nil, MissingRightParenErr.SetMessagef("]").SetToken(&next)
 			}
 		}
 		return arr, nil
@@ -256,36 +332,42 @@ func (p *Parser) parseVariableOrLiteral() (IEvaluator, error) {
 
 func (p *Parser) ParseVariable(t Token) (*variableResolver, error) {
 	if t.typ != TokenIdentifier {
-		return nil, UnexpectedTokenErr.SetMessagef("parse variable", t.val).SetPosition(t.line, t.col)
+		return nil, UnexpectedTokenErr.SetMessagef("parse variable", t.spelling()).SetToken(&t)
 	}
 	resolver := &variableResolver{
 		locationToken: &t,
+		end:           t.end,
 	}
 	resolver.parts = append(resolver.parts, &variablePart{
-		typ:  VariablePartTypeIdent,
-		name: t.val,
+		typ:           VariablePartTypeIdent,
+		name:          t.val,
+		locationToken: &t,
 	})
 	for {
 		next := p.NextToken()
 		switch next.typ {
 		case TokenField:
 			resolver.parts = append(resolver.parts, &variablePart{
-				typ:  VariablePartTypeIdent,
-				name: next.val,
+				typ:           VariablePartTypeIdent,
+				name:          next.val,
+				locationToken: &next,
 			})
+			resolver.end = next.end
 		case TokenLeftBrackets:
 			exprSubscript, err := p.ParseExpression()
 			if err != nil {
 				return nil, err
 			}
 			resolver.parts = append(resolver.parts, &variablePart{
-				typ:       VariablePartTypeSubscript,
-				subscript: exprSubscript,
+				typ:           VariablePartTypeSubscript,
+				subscript:     exprSubscript,
+				locationToken: &next,
 			})
 			nextR := p.NextToken()
 			if nextR.typ != TokenRightBrackets {
-				return nil, MissingRightParenErr.SetMessagef("]").SetPosition(nextR.line, nextR.col)
+				return nil, MissingRightParenErr.SetMessagef("]").SetToken(&nextR)
 			}
+			resolver.end = nextR.end
 		case TokenLeftParen:
 			funcPart := resolver.parts[len(resolver.parts)-1]
 			funcPart.isFunctionCall = true
@@ -293,10 +375,10 @@ func (p *Parser) ParseVariable(t Token) (*variableResolver, error) {
 			for {
 				peek := p.PeekToken()
 				if peek.typ == TokenEOF {
-					return nil, UnexpectedEofErr.SetPosition(peek.line, peek.col)
+					return nil, UnexpectedEofErr.SetToken(&peek)
 				}
 				if peek.typ == TokenRightParen {
-					p.NextToken()
+					resolver.end = p.NextToken().end
 					break argumentLoop
 				}
 				exprArg, err := p.ParseExpression()
@@ -306,10 +388,11 @@ func (p *Parser) ParseVariable(t Token) (*variableResolver, error) {
 				funcPart.callingArgs = append(funcPart.callingArgs, exprArg)
 				next2 := p.NextToken()
 				if next2.typ == TokenRightParen {
+					resolver.end = next2.end
 					break argumentLoop
 				}
 				if next2.typ != TokenComma {
-					return nil, MissingRightParenErr.SetMessagef(")").SetPosition(next2.line, next2.col)
+					return nil, MissingRightParenErr.SetMessagef(")").SetToken(&next2)
 				}
 			}
 		default:
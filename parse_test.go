@@ -0,0 +1,26 @@
+package mathxf
+
+import "testing"
+
+// TestExpressionStatementFromVariable checks statements that start with a variable but are not assignments.
+func TestExpressionStatementFromVariable(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`a ^ 2 + 1`, "10"},
+		{`a * 2 - -a`, "9"},
+		{`a > 2 ? "big" : "small"`, "big"},
+		{`a in [1, 3] && a != 1`, "true"},
+		{`items[1] * (a - 1)`, "4"},
+		{`max(a, 5) % 3`, "2"},
+	}
+	env := map[string]any{"a": 3, "items": []int{1, 2}}
+	for _, tt := range tests {
+		for _, res := range runSrc(t, tt.src, env) {
+			if got := toString(res[DefResultKey]["res1"]); got != tt.want {
+				t.Errorf("%s = %s, want %s", tt.src, got, tt.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,468 @@
+package mathxf
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"time"
+)
+
+// Option configures a Program at compile time.
+type Option func(p *Program) error
+
+// WithHighPrecision enables or disables decimal arithmetic, it is enabled by default.
+func WithHighPrecision(b bool) Option {
+	return func(p *Program) error {
+		p.isHighPrecision = b
+		return nil
+	}
+}
+
+// WithStrictTypes makes operators fail on operands of the wrong type, e.g. a string compared to
+// a number or a bool added to a number, instead of coercing them. See also Program.CheckTypes.
+func WithStrictTypes(b bool) Option {
+	return func(p *Program) error {
+		p.isStrict = b
+		return nil
+	}
+}
+
+// WithBytecode compiles the program to bytecode run by a stack VM instead of walking the syntax tree.
+// Results are the same and errors carry the same codes, but the VM counts instructions and not nodes,
+// a call takes two steps, so WithMaxSteps stops at a different point. It pays off for rules that are run many times.
+func WithBytecode(b bool) Option {
+	return func(p *Program) error {
+		p.useBytecode = b
+		return nil
+	}
+}
+
+// WithOptimize enables or disables the optimization of the parsed template, it is enabled by default.
+// Constant subexpressions and constants of WithFuncOrConst are folded, branches of if with a constant
+// condition are removed and x*1, x+0 are simplified. Disable it to debug a template node by node.
+func WithOptimize(b bool) Option {
+	return func(p *Program) error {
+		p.optimize = b
+		return nil
+	}
+}
+
+// WithFuncOrConst registers a constant or a function, see template.AddFuncOrConst.
+func WithFuncOrConst(name string, val any) Option {
+	return func(p *Program) error {
+		if _, ok := p.lookupConst(name); ok {
+			return p.parseErrFn(ConstRegisteredErr.SetMessagef(name))
+		}
+		p.consts[name] = NewConstValElement(val, reflect.ValueOf(val).Kind() == reflect.Func)
+		return nil
+	}
+}
+
+// WithDefResultKey sets the result key used by bare expressions, default is "res".
+func WithDefResultKey(key string) Option {
+	return func(p *Program) error {
+		p.defResultKey = key
+		return nil
+	}
+}
+
+// WithResultKeys adds result prefix keys besides the default result key.
+func WithResultKeys(keys ...string) Option {
+	return func(p *Program) error {
+		for _, key := range keys {
+			if key == DefResultEnvKey || key == p.defResultKey {
+				return p.parseErrFn(ResultKeyRegisteredErr.SetMessagef(key))
+			}
+			for _, k := range p.resultKeys {
+				if k == key {
+					return p.parseErrFn(ResultKeyRegisteredErr.SetMessagef(key))
+				}
+			}
+			p.resultKeys = append(p.resultKeys, key)
+		}
+		return nil
+	}
+}
+
+// WithReplaceStrMap converts display code into executable code before parsing, see template.ReplaceStrMap.
+func WithReplaceStrMap(strMap map[string]string) Option {
+	return func(p *Program) error {
+		for k, v := range strMap {
+			if !containsAtLeastOneLetter(v) {
+				return p.parseErrFn(InvalidReplaceStrErr.SetMessagef(v))
+			}
+			p.strMap[k] = v
+		}
+		p.keyOrder = aliasOrder(p.strMap)
+		return nil
+	}
+}
+
+// WithKeywords adds localized spellings of keywords, e.g. {"如果": "if", "否则": "else", "并且": "and"}.
+// The keywords keep working, a localized word must be an identifier and is no longer one itself.
+func WithKeywords(keywords map[string]string) Option {
+	return func(p *Program) error {
+		for k, v := range keywords {
+			_, isKeyword := TokenKeywords[k]
+			if _, ok := TokenKeywords[v]; !ok || isKeyword || !isIdentifier(k) {
+				return p.parseErrFn(InvalidKeywordErr.SetMessagef(k, v))
+			}
+			p.keywords[k] = v
+		}
+		return nil
+	}
+}
+
+// keyword returns k as spelled in messages, the localized word of WithKeywords if there is one.
+func (p *Program) keyword(k string) string {
+	spelling := k
+	for word, v := range p.keywords {
+		if v == k && (spelling == k || word < spelling) {
+			spelling = word
+		}
+	}
+	return spelling
+}
+
+// WithDivisionScale sets the digits after the decimal point of /, % and ^ with a negative exponent
+// in high-precision mode, 16 by default.
+func WithDivisionScale(scale int32) Option {
+	return func(p *Program) error {
+		p.numeric.DivScale = scale
+		return nil
+	}
+}
+
+// WithMathScale sets the digits after the decimal point of sqrt, exp, ln, the trigonometric functions
+// and fractional ^ in high-precision mode, 16 by default.
+func WithMathScale(scale int32) Option {
+	return func(p *Program) error {
+		p.numeric.MathScale = scale
+		return nil
+	}
+}
+
+// WithRounding sets the rounding of the division scale, of round(x, n) and of the result scale.
+func WithRounding(mode RoundingMode) Option {
+	return func(p *Program) error {
+		p.numeric.Rounding = mode
+		return nil
+	}
+}
+
+// WithResultScale rounds numeric results to scale digits after the decimal point, a negative
+// scale (the default) keeps them as they are.
+func WithResultScale(scale int32) Option {
+	return func(p *Program) error {
+		p.numeric.ResultScale = scale
+		return nil
+	}
+}
+
+// Numeric returns the decimal settings of the program, e.g. to change them for a run with ContextWithNumeric.
+func (p *Program) Numeric() Numeric {
+	return p.numeric
+}
+
+// WithLocale sets the locale of error messages, e.g. LocaleZhCN. The default is English,
+// ContextWithLocale overrides it for a single Run.
+func WithLocale(locale string) Option {
+	return func(p *Program) error {
+		p.locale = locale
+		return nil
+	}
+}
+
+// WithLocation sets the time zone of date, now and the calendar functions like year and start_of_month,
+// time.Local by default. ContextWithLocation overrides it for a single Run.
+func WithLocation(loc *time.Location) Option {
+	return func(p *Program) error {
+		p.location = loc
+		return nil
+	}
+}
+
+// WithNow sets the clock of now(), e.g. a fixed time in tests. ContextWithNow overrides it for a single Run.
+func WithNow(now func() time.Time) Option {
+	return func(p *Program) error {
+		p.now = now
+		return nil
+	}
+}
+
+// WithTag registers a custom tag parser.
+func WithTag(name string, parserFn TagParser) Option {
+	return func(p *Program) error {
+		if _, ok := p.tags[name]; ok {
+			return p.parseErrFn(TagRegisteredErr.SetMessagef(name))
+		}
+		p.tags[name] = parserFn
+		return nil
+	}
+}
+
+// WithParseErrFn sets the function used to convert ECodes into the returned error.
+func WithParseErrFn(fn ParseECodeFn) Option {
+	return func(p *Program) error {
+		p.parseErrFn = fn
+		return nil
+	}
+}
+
+// WithMaxSteps limits the number of evaluation steps of a single run, 0 means unlimited.
+func WithMaxSteps(n int) Option {
+	return func(p *Program) error {
+		p.maxSteps = n
+		return nil
+	}
+}
+
+// WithTimeout limits the wall time of a single run, 0 means no timeout.
+func WithTimeout(d time.Duration) Option {
+	return func(p *Program) error {
+		p.timeout = d
+		return nil
+	}
+}
+
+// WithMaxDepth limits the nesting depth of blocks and user-defined function calls, default is DefMaxDepth.
+// 0 means unlimited, endless recursion is then only stopped by WithMaxSteps or WithTimeout.
+func WithMaxDepth(n int) Option {
+	return func(p *Program) error {
+		p.maxDepth = n
+		return nil
+	}
+}
+
+// Program is a compiled template. It is immutable after Compile and can be
+// executed concurrently by multiple goroutines, every Run uses its own EvaluatorContext.
+type Program struct {
+	root *nodeDocument
+	exec *nodeDocument // the document run by Run, root after optimizations
+	src  string
+	errs ErrorList
+
+	tags     map[string]TagParser
+	keyOrder []string
+	strMap   map[string]string
+	keywords map[string]string // localized keywords of WithKeywords and their keyword
+	consts   ValElementMap
+
+	isHighPrecision bool
+	isStrict        bool
+	numeric         Numeric
+	optimize        bool
+	useBytecode     bool
+	code            *bytecode // nil unless useBytecode
+	defResultKey    string
+	resultKeys      []string
+	parseErrFn      ParseECodeFn
+	locale          string // the locale of error messages, see WithLocale
+	location        *time.Location
+	now             func() time.Time
+
+	maxSteps int
+	timeout  time.Duration
+	maxDepth int
+}
+
+func newProgram() *Program {
+	return &Program{
+		tags:            defTags(),
+		strMap:          make(map[string]string),
+		keywords:        make(map[string]string),
+		consts:          make(ValElementMap),
+		isHighPrecision: true,
+		numeric:         DefNumeric,
+		optimize:        true,
+		defResultKey:    DefResultKey,
+		parseErrFn:      ParseErr,
+		maxDepth:        DefMaxDepth,
+	}
+}
+
+// Compile lexes and parses tpl once and returns a reusable Program.
+func Compile(tpl string, opts ...Option) (*Program, error) {
+	p := newProgram()
+	for _, opt := range opts {
+		if err := opt(p); err != nil {
+			return nil, localizeErr(err, p.locale)
+		}
+	}
+	if err := p.parse(tpl); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *Program) parse(tpl string) error {
+	root, err := p.newParser(tpl).ParseDocument()
+	if err != nil {
+		return p.wrapErr(err)
+	}
+	p.root = root
+	p.prepare()
+	return nil
+}
+
+// prepare builds what Run executes from the parsed document.
+func (p *Program) prepare() {
+	p.exec = p.root
+	if p.optimize {
+		p.exec = p.optimizeDocument(p.root)
+	}
+	if p.useBytecode {
+		p.code = p.compileBytecode()
+	}
+}
+
+// newParser returns a parser on tpl, the lexer resolves the display strings of WithReplaceStrMap.
+func (p *Program) newParser(tpl string) *Parser {
+	p.src = tpl
+	l := lex(tpl)
+	l.aliases, l.aliasOrder = p.strMap, p.keyOrder
+	l.replaceKeywords = p.keywords
+	return &Parser{
+		lex:  l,
+		tags: p.tags,
+	}
+}
+
+// wrapErr converts err with the ParseECodeFn of the program, an *Error result refers to the original source.
+func (p *Program) wrapErr(err error) error {
+	err = p.parseErrFn(err)
+	var e *Error
+	if errors.As(err, &e) {
+		return e.withSource(p.src).Localize(p.locale)
+	}
+	return err
+}
+
+// CompileAll is like Compile, but reports every syntax error instead of the first one.
+// The error is an ErrorList, nil if there are none. The returned Program holds the statements
+// that could be parsed, e.g. for an editor to inspect, Run on it returns the same error.
+func CompileAll(tpl string, opts ...Option) (*Program, error) {
+	p := newProgram()
+	for _, opt := range opts {
+		if err := opt(p); err != nil {
+			return nil, ErrorList{localizeErr(err, p.locale)}
+		}
+	}
+	root, errs := p.newParser(tpl).ParseDocumentRecover()
+	p.root = root
+	if len(errs) == 0 {
+		p.prepare()
+	}
+	for _, err := range errs {
+		p.errs = append(p.errs, p.wrapErr(err))
+	}
+	if len(p.errs) == 0 {
+		return p, nil
+	}
+	return p, p.errs
+}
+
+// Run executes the program with env. ctx may be nil, in which case context.TODO is used.
+// The run stops with an error when ctx is canceled or the limits of the program are exceeded.
+func (p *Program) Run(ctx context.Context, env map[string]any) (map[string]ValMap, error) {
+	_, res, err := p.run(ctx, env)
+	return res, err
+}
+
+// run is Run, it also returns the context of the run, which is nil if the program has syntax errors.
+func (p *Program) run(ctx context.Context, env map[string]any) (*EvaluatorContext, map[string]ValMap, error) {
+	if ctx == nil {
+		ctx = context.TODO()
+	}
+	if len(p.errs) > 0 {
+		if locale, ok := ctx.Value(localeKey{}).(string); ok {
+			return nil, nil, localizeErr(p.errs, locale)
+		}
+		return nil, nil, p.errs
+	}
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+	evalCtx := p.newContext(ctx)
+	if n, ok := ctx.Value(numericKey{}).(Numeric); ok {
+		evalCtx.Numeric = n
+	}
+	if loc, ok := ctx.Value(locationKey{}).(*time.Location); ok {
+		evalCtx.Location = loc
+	}
+	if now, ok := ctx.Value(nowKey{}).(time.Time); ok {
+		evalCtx.Now = func() time.Time { return now }
+	}
+	var res map[string]ValMap
+	var err error
+	if p.code != nil {
+		res, err = runBytecode(p.code, evalCtx, env)
+	} else {
+		res, err = runDocument(p.exec, evalCtx, env)
+	}
+	if err != nil {
+		err = p.wrapErr(err)
+		if locale, ok := ctx.Value(localeKey{}).(string); ok {
+			err = localizeErr(err, locale)
+		}
+		return evalCtx, nil, err
+	}
+	return evalCtx, res, nil
+}
+
+func (p *Program) lookupConst(name string) (*ValElement, bool) {
+	if ele, ok := p.consts[name]; ok {
+		return ele, true
+	}
+	ele, ok := DefConst[name]
+	return ele, ok
+}
+
+func (p *Program) newContext(ctx context.Context) *EvaluatorContext {
+	res := &EvaluatorContext{
+		Context:         ctx,
+		IsHighPrecision: p.isHighPrecision,
+		IsStrict:        p.isStrict,
+		Numeric:         p.numeric,
+		Location:        p.location,
+		Now:             p.now,
+		ValMap:          make(ValElementMap),
+		ResultMap:       make(map[string]ValMap),
+		constMap:        p.consts,
+		maxSteps:        p.maxSteps,
+		maxDepth:        p.maxDepth,
+		defResultKey:    p.defResultKey,
+		parseErrFn:      p.parseErrFn,
+	}
+	res.ResultMap[p.defResultKey] = make(ValMap)
+	for _, key := range p.resultKeys {
+		res.ResultMap[key] = make(ValMap)
+	}
+	return res
+}
+
+func runDocument(root INode, ctx *EvaluatorContext, env map[string]any) (map[string]ValMap, error) {
+	for k, v := range env {
+		ctx.ValMap[k] = NewPublicValElement(v)
+	}
+	err := root.Execute(ctx)
+	if err != nil {
+		return nil, err
+	}
+	_env := make(ValMap)
+	for k, ele := range ctx.ValMap {
+		if ele.ValType != PublicVal {
+			continue
+		}
+		if ele.IsSet {
+			_env[k] = AsValue(ele.Val)
+		}
+	}
+	if len(_env) > 0 {
+		ctx.ResultMap[DefResultEnvKey] = _env
+	}
+	ctx.scaleResults()
+	return ctx.ResultMap, nil
+}
@@ -0,0 +1,232 @@
+package mathxf
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/xslasd/mathxf/ast"
+)
+
+// AST returns the syntax tree of the program. Positions refer to the source passed to Compile,
+// names of the display strings of WithReplaceStrMap are the replaced ones.
+// The tree is built on every call and may be changed freely by the caller.
+func (p *Program) AST() *ast.File {
+	b := newASTBuilder(p.src)
+	f := &ast.File{
+		StartPos: b.pos(0),
+		EndPos:   b.pos(len(p.src)),
+	}
+	if p.root == nil {
+		return f
+	}
+	for _, node := range p.root.Nodes {
+		if s := b.stmt(node); s != nil {
+			f.Stmts = append(f.Stmts, s)
+		}
+	}
+	return f
+}
+
+// astBuilder converts the parsed nodes into an ast.File.
+type astBuilder struct {
+	src        string
+	lineStarts []int
+}
+
+func newASTBuilder(src string) *astBuilder {
+	b := &astBuilder{src: src, lineStarts: []int{0}}
+	for i := 0; i < len(src); i++ {
+		if src[i] == '\n' {
+			b.lineStarts = append(b.lineStarts, i+1)
+		}
+	}
+	return b
+}
+
+// pos converts a byte offset of the source into a position.
+func (b *astBuilder) pos(o int) ast.Pos {
+	o = clamp(o, 0, len(b.src))
+	line := sort.Search(len(b.lineStarts), func(i int) bool {
+		return b.lineStarts[i] > o
+	})
+	return ast.Pos{Offset: o, Line: line, Column: o - b.lineStarts[line-1] + 1}
+}
+
+func (b *astBuilder) tokenPos(t *Token) ast.Pos {
+	if t == nil {
+		return ast.Pos{}
+	}
+	return b.pos(t.pos)
+}
+
+func (b *astBuilder) tokenEnd(t *Token) ast.Pos {
+	if t == nil {
+		return ast.Pos{}
+	}
+	return b.pos(t.end)
+}
+
+func (b *astBuilder) ident(t *Token) *ast.Ident {
+	return &ast.Ident{NamePos: b.tokenPos(t), NameEnd: b.tokenEnd(t), Name: t.val}
+}
+
+func (b *astBuilder) block(w *NodeWrapper) *ast.BlockStmt {
+	res := &ast.BlockStmt{
+		Lbrace: b.tokenPos(w.locationToken),
+		Rbrace: b.pos(w.end - 1),
+	}
+	for _, node := range w.nodes {
+		if s := b.stmt(node); s != nil {
+			res.Stmts = append(res.Stmts, s)
+		}
+	}
+	return res
+}
+
+func (b *astBuilder) stmt(node INode) ast.Stmt {
+	switch n := node.(type) {
+	case NodeResData:
+		return &ast.ExprStmt{X: b.expr(n.evl), Name: n.name}
+	case *NodeAssignment:
+		return &ast.AssignStmt{Target: b.variable(n.variable), Value: b.expr(n.value)}
+	case tagSetNode:
+		res := &ast.ValStmt{Val: b.tokenPos(n.locationToken)}
+		for _, set := range n.setNodes {
+			res.Names = append(res.Names, b.ident(set.nameToken))
+		}
+		if n.isAssign && len(n.setNodes) > 0 {
+			res.Value = b.expr(n.setNodes[0].expression)
+		}
+		return res
+	case *tagIfNode:
+		return b.ifStmt(n, 0)
+	case *tagForNode:
+		res := &ast.ForStmt{
+			For:  b.tokenPos(n.locationToken),
+			Key:  b.ident(n.keyToken),
+			X:    b.expr(n.iterable),
+			Body: b.block(n.wrapper),
+		}
+		if n.valToken != nil {
+			res.Value = b.ident(n.valToken)
+		}
+		return res
+	case *tagFuncNode:
+		res := &ast.FuncStmt{
+			Func: b.tokenPos(n.locationToken),
+			Name: b.ident(n.nameToken),
+			Body: b.block(n.body),
+		}
+		for _, param := range n.paramTokens {
+			res.Params = append(res.Params, b.ident(param))
+		}
+		return res
+	case *tagReturnNode:
+		res := &ast.ReturnStmt{
+			Return:    b.tokenPos(n.locationToken),
+			ReturnEnd: b.tokenEnd(n.locationToken),
+		}
+		if n.expr != nil {
+			res.Result = b.expr(n.expr)
+		}
+		return res
+	case tagBreakNode:
+		return &ast.BranchStmt{TokPos: b.tokenPos(n.locationToken), TokEnd: b.tokenEnd(n.locationToken), Tok: KeywordBreak}
+	case tagContinueNode:
+		return &ast.BranchStmt{TokPos: b.tokenPos(n.locationToken), TokEnd: b.tokenEnd(n.locationToken), Tok: KeywordContinue}
+	case *customTagNode:
+		return &ast.CustomStmt{Name: b.ident(n.nameToken), Impl: n.node, EndPos: b.pos(n.end)}
+	}
+	return nil
+}
+
+// ifStmt converts the condition i of n and the rest of the chain into nested if statements.
+func (b *astBuilder) ifStmt(n *tagIfNode, i int) *ast.IfStmt {
+	res := &ast.IfStmt{
+		If:   b.tokenPos(n.ifTokens[i]),
+		Cond: b.expr(n.conditions[i]),
+		Body: b.block(n.wrappers[i]),
+	}
+	switch {
+	case i+1 < len(n.conditions):
+		res.Else = b.ifStmt(n, i+1)
+	case i+1 < len(n.wrappers):
+		res.Else = b.block(n.wrappers[i+1])
+	}
+	return res
+}
+
+func (b *astBuilder) binary(x IEvaluator, op *Token, y IEvaluator) ast.Expr {
+	if y == nil || op == nil {
+		return b.expr(x)
+	}
+	return &ast.BinaryExpr{X: b.expr(x), OpPos: b.tokenPos(op), Op: op.val, Y: b.expr(y)}
+}
+
+func (b *astBuilder) expr(e IEvaluator) ast.Expr {
+	switch n := e.(type) {
+	case *Expression:
+		return b.binary(n.expr1, n.opToken, n.expr2)
+	case *relationalExpression:
+		return b.binary(n.expr1, n.opToken, n.expr2)
+	case *simpleExpression:
+		return b.binary(n.term1, n.opToken, n.term2)
+	case *termExpression:
+		return b.binary(n.factor1, n.opToken, n.factor2)
+	case *powerExpression:
+		return b.binary(n.power1, n.opToken, n.power2)
+	case *unaryExpression:
+		return &ast.UnaryExpr{OpPos: b.tokenPos(n.opToken), Op: n.opToken.val, X: b.expr(n.expr)}
+	case *ternaryExpression:
+		return &ast.CondExpr{Cond: b.expr(n.cond), X: b.expr(n.expr1), Y: b.expr(n.expr2)}
+	case *numberResolver:
+		if n.locationToken == nil {
+			return &ast.BasicLit{Kind: ast.Number, Value: strconv.FormatFloat(n.val, 'f', -1, 64)}
+		}
+		kind := ast.Number
+		if n.locationToken.typ == TokenCharConstant {
+			kind = ast.Char
+		}
+		return b.literal(n.locationToken, kind)
+	case *boolResolver:
+		return b.literal(n.locationToken, ast.Bool)
+	case *moneyResolver:
+		return b.literal(n.locationToken, ast.Money)
+	case *durationResolver:
+		return b.literal(n.locationToken, ast.Duration)
+	case *stringResolver:
+		t := n.locationToken
+		// the token holds the string without its quotes
+		return &ast.BasicLit{ValuePos: b.pos(t.pos - 1), ValueEnd: b.pos(t.end + 1), Kind: ast.String, Value: t.val}
+	case *arrayResolver:
+		res := &ast.ArrayLit{Lbrack: b.tokenPos(n.locationToken), Rbrack: b.pos(n.end - 1)}
+		for _, part := range n.parts {
+			res.Elems = append(res.Elems, b.expr(part.subscript))
+		}
+		return res
+	case *variableResolver:
+		return b.variable(n)
+	}
+	return nil
+}
+
+func (b *astBuilder) literal(t *Token, kind ast.LitKind) *ast.BasicLit {
+	return &ast.BasicLit{ValuePos: b.tokenPos(t), ValueEnd: b.tokenEnd(t), Kind: kind, Value: t.val}
+}
+
+func (b *astBuilder) variable(v *variableResolver) *ast.Var {
+	res := &ast.Var{EndPos: b.pos(v.end)}
+	for _, part := range v.parts {
+		elem := &ast.VarElem{ElemPos: b.tokenPos(part.locationToken), Call: part.isFunctionCall}
+		if part.typ == VariablePartTypeSubscript {
+			elem.Index = b.expr(part.subscript)
+		} else {
+			elem.Name = part.name
+		}
+		for _, arg := range part.callingArgs {
+			elem.Args = append(elem.Args, b.expr(arg))
+		}
+		res.Elems = append(res.Elems, elem)
+	}
+	return res
+}
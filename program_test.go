@@ -0,0 +1,228 @@
+package mathxf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestProgramConcurrentRun runs one Program from many goroutines, run it with go test -race.
+func TestProgramConcurrentRun(t *testing.T) {
+	const src = `val total = 0
+for item in items {
+	total = total + item * rate
+}
+res.total = total
+res.label = name + ":" + to_string(len(items))`
+	for _, bytecode := range []bool{false, true} {
+		p, err := Compile(src, WithBytecode(bytecode), WithFuncOrConst("rate", 2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var wg sync.WaitGroup
+		errs := make(chan error, 64)
+		for g := 0; g < 64; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := 0; i < 20; i++ {
+					n := g + i
+					items := make([]int, n)
+					for j := range items {
+						items[j] = j
+					}
+					res, err := p.Run(context.Background(), map[string]any{"items": items, "name": fmt.Sprint("g", g)})
+					if err != nil {
+						errs <- err
+						return
+					}
+					want := fmt.Sprint(n * (n - 1))
+					if got := res[DefResultKey]["total"].String(); got != want {
+						errs <- fmt.Errorf("goroutine %d: total = %s, want %s", g, got, want)
+						return
+					}
+					if got, want := res[DefResultKey]["label"].String(), fmt.Sprintf("g%d:%d", g, n); got != want {
+						errs <- fmt.Errorf("goroutine %d: label = %s, want %s", g, got, want)
+						return
+					}
+				}
+			}(g)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Errorf("bytecode=%v: %v", bytecode, err)
+		}
+	}
+}
+
+func TestTemplateConcurrentExecute(t *testing.T) {
+	tpl, err := NewTemplate("val y = x * 2\nres.y = y")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for g := 0; g < 32; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			res, err := tpl.Execute(map[string]any{"x": g})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if got, want := res[DefResultKey]["y"].String(), fmt.Sprint(g*2); got != want {
+				t.Errorf("y = %s, want %s", got, want)
+			}
+			_ = tpl.PublicValMap()
+		}(g)
+	}
+	wg.Wait()
+}
+
+func TestConstsArePerTemplate(t *testing.T) {
+	t1, err := NewTemplate("res.x = ff * 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := t1.AddFuncOrConst("ff", 100); err != nil {
+		t.Fatal(err)
+	}
+	t2, err := NewTemplate("res.x = ff * 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := t1.Execute(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := res[DefResultKey]["x"].String(); got != "200" {
+		t.Errorf("t1: res.x = %s, want 200", got)
+	}
+	if _, err := t2.Execute(nil); err == nil {
+		t.Error("t2: ff of t1 is visible in t2")
+	}
+	if _, ok := DefConst["ff"]; ok {
+		t.Error("AddFuncOrConst changed DefConst")
+	}
+	// the same name may be registered with another value on another template
+	if err := t2.AddFuncOrConst("ff", 1); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := t2.Execute(nil); err != nil || res[DefResultKey]["x"].String() != "2" {
+		t.Errorf("t2: res = %v, err = %v, want res.x = 2", res, err)
+	}
+}
+
+func TestMaxDepth(t *testing.T) {
+	const src = "func f(n) { return n == 0 ? 0 : f(n - 1) + 1 }\nif true { res.x = f(150) }"
+	// 0 means unlimited
+	for _, res := range runSrc(t, src, nil, WithMaxDepth(0)) {
+		if got := res[DefResultKey]["x"].String(); got != "150" {
+			t.Errorf("res.x = %s, want 150", got)
+		}
+	}
+	for _, bytecode := range []bool{false, true} {
+		p, err := Compile(src, WithMaxDepth(10), WithBytecode(bytecode))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := p.Run(context.Background(), nil); errCode(err) != CallDepthExceededErr.Code() {
+			t.Errorf("bytecode=%v: err = %v, want CallDepthExceededErr", bytecode, err)
+		}
+	}
+}
+
+func TestCompileAllError(t *testing.T) {
+	p, err := CompileAll("res.x = 1")
+	if err != nil {
+		t.Fatalf("CompileAll of a valid template: err = %v, want nil", err)
+	}
+	if _, err := p.Run(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	p, err = CompileAll("res.x = (1\nres.y = 2\nres.z = 1 +* 2")
+	var errs ErrorList
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Fatalf("err = %v, want an ErrorList of 2 errors", err)
+	}
+	if _, err := p.Run(context.Background(), nil); !errors.As(err, &errs) || len(errs) != 2 {
+		t.Errorf("Run: err = %v, want the errors of CompileAll", err)
+	}
+	tpl, err := NewTemplate("res.x = (1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tpl.Execute(nil); err == nil {
+		t.Error("Execute of an invalid template succeeded")
+	}
+	if vals := tpl.PublicValMap(); len(vals) != 0 {
+		t.Errorf("PublicValMap() = %v, want empty", vals)
+	}
+}
+
+// TestRunLimitsHavePosition checks that the step limit, the timeout and a canceled context
+// report the statement they stop.
+func TestRunLimitsHavePosition(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name string
+		src  string
+		ctx  context.Context
+		opts []Option
+		code int
+		line int
+	}{
+		{"max steps", "val a = 1\nres.x = a + 2\nres.y = a * 3", context.Background(), []Option{WithMaxSteps(3)}, StepLimitExceededErr.Code(), 2},
+		{"timeout", "res.a = 1\nfor i in range(1000) {\n  for j in range(1000) { res.x = i + j }\n}", context.Background(), []Option{WithTimeout(10 * time.Millisecond)}, ExecutionTimeoutErr.Code(), 3},
+		{"canceled", "\n  res.x = 1", canceled, nil, ExecutionCanceledErr.Code(), 2},
+	}
+	for _, tt := range tests {
+		for _, bytecode := range []bool{false, true} {
+			p, err := Compile(tt.src, append(tt.opts, WithBytecode(bytecode))...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = p.Run(tt.ctx, nil)
+			var e *Error
+			if !errors.As(err, &e) || e.Code != tt.code {
+				t.Errorf("%s bytecode=%v: err = %v, want code %d", tt.name, bytecode, err, tt.code)
+				continue
+			}
+			if e.Line != tt.line || e.Column == 0 {
+				t.Errorf("%s bytecode=%v: position = %d:%d, want line %d", tt.name, bytecode, e.Line, e.Column, tt.line)
+			}
+		}
+	}
+}
+
+// runSrc compiles src with opts and runs it with env, once as a tree and once as bytecode.
+func runSrc(t *testing.T, src string, env map[string]any, opts ...Option) []map[string]ValMap {
+	t.Helper()
+	var res []map[string]ValMap
+	for _, bytecode := range []bool{false, true} {
+		p, err := Compile(src, append(opts, WithBytecode(bytecode))...)
+		if err != nil {
+			t.Fatalf("Compile(%q): %v", src, err)
+		}
+		out, err := p.Run(context.Background(), env)
+		if err != nil {
+			t.Fatalf("Run(%q) bytecode=%v: %v", src, bytecode, err)
+		}
+		res = append(res, out)
+	}
+	return res
+}
+
+// errCode returns the code of err, 0 if it is not an *Error.
+func errCode(err error) int {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return 0
+}
@@ -15,6 +15,9 @@ func baseStateFn(l *lexer) stateFn {
 	if strings.HasPrefix(l.input[l.pos:], leftComment) {
 		return comment2StateFn(l)
 	}
+	if len(l.aliasOrder) > 0 && l.alias() {
+		return baseStateFn
+	}
 	switch r := l.next(); {
 	case r == eof:
 		return nil
@@ -38,26 +41,8 @@ func baseStateFn(l *lexer) stateFn {
 	case r == '%':
 		l.emit(TokenMod)
 	case r == '-':
-		if pr := l.peek(); (('0' <= pr && pr <= '9') || pr == '.') &&
-			TokenNumber != l.lastTokenType &&
-			TokenIdentifier != l.lastTokenType &&
-			TokenBool != l.lastTokenType &&
-			TokenField != l.lastTokenType &&
-			TokenChar != l.lastTokenType {
-			return numberStateFn
-		}
 		l.emit(TokenSub)
 	case r == '+':
-		if r := l.peek(); '0' <= r && r <= '9' &&
-			TokenAdd != l.lastTokenType &&
-			TokenSub != l.lastTokenType &&
-			TokenNumber != l.lastTokenType &&
-			TokenIdentifier != l.lastTokenType &&
-			TokenBool != l.lastTokenType &&
-			TokenField != l.lastTokenType &&
-			TokenChar != l.lastTokenType {
-			return numberStateFn
-		}
 		l.emit(TokenAdd)
 	case r == '?':
 		l.emit(TokenTernary)
@@ -184,13 +169,22 @@ func stringStateFn(l *lexer) stateFn {
 }
 
 func numberStateFn(l *lexer) stateFn {
+	if _, n := scanDuration(l.input[l.start:]); n > 0 {
+		l.col += l.start + n - l.pos
+		l.pos = l.start + n
+		l.emit(TokenDuration)
+		return baseStateFn
+	}
 	isNumber, isComplex := l.scanNumber()
 	if !isNumber {
 		return l.emitError("bad number syntax: %q", l.value())
 	}
-	if isComplex {
+	switch {
+	case isComplex:
 		l.emit(TokenComplex)
-	} else {
+	case l.currency():
+		l.emit(TokenMoney)
+	default:
 		l.emit(TokenNumber)
 	}
 	return baseStateFn
@@ -213,10 +207,14 @@ func comment2StateFn(l *lexer) stateFn {
 	if i < 0 {
 		return l.emitError("unclosed comment")
 	}
-	i2 := strings.Count(l.input[l.pos:i+2], string('\n'))
-	l.line += i2
-	l.pos += i + len(rightComment)
-	l.col = 0
+	text := l.input[l.pos : l.pos+i+len(rightComment)]
+	l.line += strings.Count(text, "\n")
+	if nl := strings.LastIndexByte(text, '\n'); nl >= 0 {
+		l.col = len(text) - nl - 1
+	} else {
+		l.col += len(leftComment) + len(text)
+	}
+	l.pos += len(text)
 	l.ignore()
 	return baseStateFn
 }
@@ -228,6 +226,12 @@ func identifierStateFn(l *lexer) stateFn {
 		default:
 			l.backup()
 			word := l.input[l.start:l.pos]
+			if keyword, ok := l.replaceKeywords[word]; ok {
+				l.emit(TokenKeywords[keyword])
+				t := &l.items[len(l.items)-1]
+				t.val, t.text = keyword, word
+				return baseStateFn
+			}
 			token, ok := TokenKeywords[word]
 			if ok {
 				l.emit(token)
@@ -0,0 +1,70 @@
+package mathxf
+
+// The functions of this file implement the strict mode of WithStrictTypes: operands of the wrong
+// type are reported instead of being coerced by Value.Decimal, Value.Float or Value.IsTrue.
+
+// strictNumber fails unless v is a number.
+func strictNumber(op *Token, v *Value) error {
+	if t := typeOfValue(v); t.Kind != KindNumber {
+		return InvalidOperationErr.SetMessagef(op.val, t).SetToken(op)
+	}
+	return nil
+}
+
+// strictNumbers fails unless both operands of op are numbers.
+func strictNumbers(op *Token, v1, v2 *Value) error {
+	if err := strictNumber(op, v1); err != nil {
+		return err
+	}
+	return strictNumber(op, v2)
+}
+
+// strictBool fails unless v, used as what, is a bool.
+func strictBool(pos *Token, v *Value, what string) error {
+	if t := typeOfValue(v); t.Kind != KindBool {
+		return CannotUseTypeErr.SetMessagef(t, BoolType, what).SetToken(pos)
+	}
+	return nil
+}
+
+// strictRelational checks the operands of a comparison. Two numbers are left to the caller and
+// give a nil result, other operands of the same type are compared without conversion.
+func strictRelational(op *Token, v1, v2 *Value) (*Value, error) {
+	t1, t2 := typeOfValue(v1), typeOfValue(v2)
+	if op.typ == TokenIn {
+		return nil, nil
+	}
+	if t1.Kind == KindNumber && t2.Kind == KindNumber {
+		return nil, nil
+	}
+	switch op.typ {
+	case TokenEquals, TokenNotEquals:
+		if t1.Kind != t2.Kind && t1.Kind != KindNil && t2.Kind != KindNil {
+			return nil, MismatchedTypesErr.SetMessagef(op.val, t1, t2).SetToken(op)
+		}
+		equal := v1.EqualValueTo(v2) || t1.Kind == KindNil && t2.Kind == KindNil
+		return AsValue(equal == (op.typ == TokenEquals)), nil
+	}
+	if t1.Kind != KindTime || t2.Kind != KindTime {
+		if t1.Kind == t2.Kind || !t1.ordered() || !t2.ordered() {
+			bad := t1
+			if t1.ordered() {
+				bad = t2
+			}
+			return nil, InvalidOperationErr.SetMessagef(op.val, bad).SetToken(op)
+		}
+		return nil, MismatchedTypesErr.SetMessagef(op.val, t1, t2).SetToken(op)
+	}
+	tm1, tm2 := v1.Time(), v2.Time()
+	switch op.typ {
+	case TokenLess:
+		return AsValue(tm1.Before(tm2)), nil
+	case TokenLessEquals:
+		return AsValue(!tm1.After(tm2)), nil
+	case TokenGreat:
+		return AsValue(tm1.After(tm2)), nil
+	case TokenGreatEquals:
+		return AsValue(!tm1.Before(tm2)), nil
+	}
+	return nil, UnknownOperatorErr.SetMessagef(op.val).SetToken(op)
+}
@@ -0,0 +1,357 @@
+package mathxf
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/shopspring/decimal"
+)
+
+// The string functions of DefConst count and index strings in runes, not bytes.
+
+// stringArg returns the argument v of the function name as a string, col is the index of the argument.
+func stringArg(name string, v *Value, col int) (string, error) {
+	if !v.IsString() {
+		return "", CannotUseTypeErr.SetMessagef(typeOfValue(v), StringType, "argument to "+name).SetCol(col)
+	}
+	return v.String(), nil
+}
+
+// intArg returns the argument v of the function name as an integer, col is the index of the argument.
+func intArg(name string, v *Value, col int) (int, error) {
+	if v.IsNil() || !v.IsNumber() || v.IsDuration() {
+		return 0, ArgumentNotNumberErr.SetMessagef(name, v.Interface()).SetCol(col)
+	}
+	if !v.Decimal().Equal(decimal.NewFromInt(int64(v.Integer()))) {
+		return 0, ArgumentInvalidErr.SetMessagef(name, col+1).SetCol(col)
+	}
+	return v.Integer(), nil
+}
+
+// stringFunc returns a function of a single string argument, e.g. upper(s).
+func stringFunc(name string, fn func(string) string) func(*Value) (*Value, error) {
+	return func(arg *Value) (*Value, error) {
+		s, err := stringArg(name, arg, 0)
+		if err != nil {
+			return nil, err
+		}
+		return AsValue(fn(s)), nil
+	}
+}
+
+// stringPredicate returns a function that reports whether a string has a property of another, e.g. starts_with(s, prefix).
+func stringPredicate(name string, fn func(s, sub string) bool) func(*Value, *Value) (*Value, error) {
+	return func(arg1, arg2 *Value) (*Value, error) {
+		s, err := stringArg(name, arg1, 0)
+		if err != nil {
+			return nil, err
+		}
+		sub, err := stringArg(name, arg2, 1)
+		if err != nil {
+			return nil, err
+		}
+		return AsValue(fn(s, sub)), nil
+	}
+}
+
+// defLen len(x) returns the number of runes of a string or the number of items of a list or map.
+func defLen(arg *Value) (*Value, error) {
+	if arg.IsString() {
+		return AsValue(utf8.RuneCountInString(arg.String())), nil
+	}
+	switch typeOfValue(arg).Kind {
+	case KindList, KindMap:
+		return AsValue(arg.Len()), nil
+	}
+	return nil, CannotUseTypeErr.SetMessagef(typeOfValue(arg), StringType, "argument to len")
+}
+
+// defTrim trim(s) removes the leading and trailing white space of s, trim(s, chars) the runes in chars.
+func defTrim(args ...*Value) (*Value, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return nil, ArgumentNotEnoughErr.SetMessagef("trim", "1-2", len(args))
+	}
+	s, err := stringArg("trim", args[0], 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 1 {
+		return AsValue(strings.TrimSpace(s)), nil
+	}
+	chars, err := stringArg("trim", args[1], 1)
+	if err != nil {
+		return nil, err
+	}
+	return AsValue(strings.Trim(s, chars)), nil
+}
+
+// defSubstr substr(s, start) or substr(s, start, length) returns length runes of s from the rune
+// index start, a negative start counts from the end. The result is cut to the bounds of s.
+func defSubstr(args ...*Value) (*Value, error) {
+	if len(args) < 2 || len(args) > 3 {
+		return nil, ArgumentNotEnoughErr.SetMessagef("substr", "2-3", len(args))
+	}
+	s, err := stringArg("substr", args[0], 0)
+	if err != nil {
+		return nil, err
+	}
+	runes := []rune(s)
+	start, err := intArg("substr", args[1], 1)
+	if err != nil {
+		return nil, err
+	}
+	if start < 0 {
+		start += len(runes)
+	}
+	start = minInt(maxInt(start, 0), len(runes))
+	end := len(runes)
+	if len(args) == 3 {
+		length, err := intArg("substr", args[2], 2)
+		if err != nil {
+			return nil, err
+		}
+		if length < 0 {
+			return nil, ArgumentInvalidErr.SetMessagef("substr", 3).SetCol(2)
+		}
+		end = minInt(start+length, end)
+	}
+	return AsValue(string(runes[start:end])), nil
+}
+
+// defReplace replace(s, old, new) replaces all old in s by new, replace(s, old, new, n) the first n.
+func defReplace(args ...*Value) (*Value, error) {
+	if len(args) < 3 || len(args) > 4 {
+		return nil, ArgumentNotEnoughErr.SetMessagef("replace", "3-4", len(args))
+	}
+	var parts [3]string
+	for i := range parts {
+		s, err := stringArg("replace", args[i], i)
+		if err != nil {
+			return nil, err
+		}
+		parts[i] = s
+	}
+	n := -1
+	if len(args) == 4 {
+		var err error
+		if n, err = intArg("replace", args[3], 3); err != nil {
+			return nil, err
+		}
+	}
+	return AsValue(strings.Replace(parts[0], parts[1], parts[2], n)), nil
+}
+
+// splitString split(s, sep) splits s around sep, an empty sep splits s into its runes.
+func splitString(arg, sep *Value) (*Value, error) {
+	s, err := stringArg("split", arg, 0)
+	if err != nil {
+		return nil, err
+	}
+	sp, err := stringArg("split", sep, 1)
+	if err != nil {
+		return nil, err
+	}
+	return AsValue(strings.Split(s, sp)), nil
+}
+
+// defJoin join(list, sep) concatenates the items of list formatted by to_string, separated by sep.
+func defJoin(ctx *EvaluatorContext, list, sep *Value) (*Value, error) {
+	if typeOfValue(list).Kind != KindList {
+		return nil, CannotUseTypeErr.SetMessagef(typeOfValue(list), ListOf(nil), "argument to join")
+	}
+	sp, err := stringArg("join", sep, 1)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]string, 0, list.Len())
+	list.Iterate(func(idx, count int, key, value *Value) bool {
+		items = append(items, toString(key))
+		return true
+	}, func() {})
+	return AsValue(strings.Join(items, sp)), nil
+}
+
+// defIndexOf index_of(s, sub) returns the rune index of the first sub in s, -1 if s does not contain sub.
+func defIndexOf(arg, sub *Value) (*Value, error) {
+	s, err := stringArg("index_of", arg, 0)
+	if err != nil {
+		return nil, err
+	}
+	sb, err := stringArg("index_of", sub, 1)
+	if err != nil {
+		return nil, err
+	}
+	i := strings.Index(s, sb)
+	if i < 0 {
+		return AsValue(-1), nil
+	}
+	return AsValue(utf8.RuneCountInString(s[:i])), nil
+}
+
+// defPadLeft pad_left(s, width) pads s with spaces on the left to width runes, pad_left(s, width, pad)
+// repeats the runes of pad. s is returned as it is if it is not shorter than width.
+// maxPadWidth bounds the width of pad_left, a rule must not allocate an unbounded string.
+const maxPadWidth = 1 << 16
+
+func defPadLeft(args ...*Value) (*Value, error) {
+	if len(args) < 2 || len(args) > 3 {
+		return nil, ArgumentNotEnoughErr.SetMessagef("pad_left", "2-3", len(args))
+	}
+	s, err := stringArg("pad_left", args[0], 0)
+	if err != nil {
+		return nil, err
+	}
+	width, err := intArg("pad_left", args[1], 1)
+	if err != nil {
+		return nil, err
+	}
+	if width > maxPadWidth {
+		return nil, ArgumentInvalidErr.SetMessagef("pad_left", 2).SetCol(1)
+	}
+	pad := []rune(" ")
+	if len(args) == 3 {
+		p, err := stringArg("pad_left", args[2], 2)
+		if err != nil {
+			return nil, err
+		}
+		if p == "" {
+			return nil, ArgumentInvalidErr.SetMessagef("pad_left", 3).SetCol(2)
+		}
+		pad = []rune(p)
+	}
+	n := width - utf8.RuneCountInString(s)
+	if n <= 0 {
+		return AsValue(s), nil
+	}
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		b.WriteRune(pad[i%len(pad)])
+	}
+	b.WriteString(s)
+	return AsValue(b.String()), nil
+}
+
+// defFormat format(layout, args...) formats args like fmt.Sprintf. Numbers are formatted as decimals,
+// so %.2f rounds exactly and %d accepts every integral number.
+func defFormat(args ...*Value) (*Value, error) {
+	if len(args) < 1 {
+		return nil, ArgumentNotEnoughErr.SetMessagef("format", "at least 1", len(args))
+	}
+	layout, err := stringArg("format", args[0], 0)
+	if err != nil {
+		return nil, err
+	}
+	vals := make([]any, len(args)-1)
+	for i, arg := range args[1:] {
+		switch {
+		case arg.IsNil():
+			vals[i] = nil
+		case arg.IsNumber() && !arg.IsDuration():
+			vals[i] = decimalFormatter(arg.Decimal())
+		default:
+			vals[i] = arg.Interface()
+		}
+	}
+	return AsValue(fmt.Sprintf(layout, vals...)), nil
+}
+
+// decimalFormatter formats a number for format with the verbs d, f, F, s and v as a decimal,
+// other verbs like e and g format it as a float64.
+type decimalFormatter decimal.Decimal
+
+func (d decimalFormatter) Format(f fmt.State, verb rune) {
+	dec := decimal.Decimal(d)
+	var s string
+	switch verb {
+	case 'd':
+		s = dec.Round(0).String()
+	case 'f', 'F':
+		prec, ok := f.Precision()
+		if !ok {
+			prec = 6
+		}
+		s = dec.StringFixed(int32(prec))
+	case 's', 'v':
+		s = dec.String()
+	default:
+		fmt.Fprintf(f, fmt.FormatString(f, verb), dec.InexactFloat64())
+		return
+	}
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	} else if f.Flag('+') {
+		sign = "+"
+	} else if f.Flag(' ') {
+		sign = " "
+	}
+	width, _ := f.Width()
+	pad := width - len(sign) - len(s)
+	switch {
+	case pad <= 0:
+		s = sign + s
+	case f.Flag('-'):
+		s = sign + s + strings.Repeat(" ", pad)
+	case f.Flag('0'):
+		s = sign + strings.Repeat("0", pad) + s
+	default:
+		s = strings.Repeat(" ", pad) + sign + s
+	}
+	f.Write([]byte(s))
+}
+
+// toString formats v for to_string and join: numbers without trailing zeros, bools as true and false.
+func toString(v *Value) string {
+	if inner, ok := v.Interface().(*Value); ok {
+		return toString(inner)
+	}
+	switch {
+	case v.IsNil():
+		return ""
+	case v.IsBool():
+		return strconv.FormatBool(v.Bool())
+	case v.IsFloat():
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64)
+	}
+	return v.String()
+}
+
+// defToString to_string(x) formats x as a string, see toString.
+func defToString(arg *Value) (*Value, error) {
+	return AsValue(toString(arg)), nil
+}
+
+// defToNumber to_number(s) parses the decimal number s, a float in float mode. Numbers are returned as they are.
+func defToNumber(ctx *EvaluatorContext, arg *Value) (*Value, error) {
+	if arg.IsNumber() && !arg.IsDuration() {
+		return arg, nil
+	}
+	s, err := stringArg("to_number", arg, 0)
+	if err != nil {
+		return nil, err
+	}
+	d, err := decimal.NewFromString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, ArgumentInvalidErr.SetMessagef("to_number", 1)
+	}
+	if ctx.IsHighPrecision {
+		return AsValue(d), nil
+	}
+	return AsValue(d.InexactFloat64()), nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
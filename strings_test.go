@@ -0,0 +1,96 @@
+package mathxf
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestStringFunctions(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{`len("你好world")`, "7"},
+		{`len([1, 2, 3])`, "3"},
+		{`upper("straße")`, "STRAßE"},
+		{`trim("  hi  ") + trim("xxhixx", "x")`, "hihi"},
+		{`substr("你好世界", 1, 2)`, "好世"},
+		{`substr("你好世界", -2)`, "世界"},
+		{`substr("你好世界", -3, 1)`, "好"},
+		{`substr("你好世界", -9, 2)`, "你好"},
+		{`substr("你好世界", 9)`, ""},
+		{`index_of("你好世界", "世")`, "2"},
+		{`index_of("你好世界", "x")`, "-1"},
+		{`index_of("ab你好", "好")`, "3"},
+		{`pad_left("7", 3, "0")`, "007"},
+		{`pad_left("ab", 5, "xy")`, "xyxab"},
+		{`pad_left("你好", 3)`, " 你好"},
+		{`pad_left("你好世界", 3)`, "你好世界"},
+		{`len(pad_left("", 65536, "ab"))`, "65536"},
+		{`replace("a-b-c", "-", "+", 1)`, "a+b-c"},
+		{`join(split("a,b,,c", ","), "|")`, "a|b||c"},
+		{`join(split("甲乙丙", ""), "/")`, "甲/乙/丙"},
+		{`join([1, 2.5, "x", true], ",")`, "1,2.5,x,true"},
+		{`starts_with("你好", "你") && ends_with("你好", "好")`, "true"},
+		{`format("%d", 42)`, "42"},
+		{`format("%d", 2.5)`, "3"},
+		{`format("%05d", -42)`, "-0042"},
+		{`format("%+d", 7)`, "+7"},
+		{`format("%.2f", 2.675)`, "2.68"},
+		{`format("%.2f", 1.005)`, "1.01"},
+		{`format("%6.1f|%-4s|", 3.14159, "ab")`, "   3.1|ab  |"},
+		{`format("%.2f 元", 1234.5)`, "1234.50 元"},
+		{`to_string(1.5) + to_string(true)`, "1.5true"},
+		{`to_number(" 12.50 ") + 1`, "13.5"},
+	}
+	for _, tt := range tests {
+		for _, hp := range []bool{true, false} {
+			for _, res := range runSrc(t, "res.x = "+tt.expr, nil, WithHighPrecision(hp)) {
+				if got := toString(res[DefResultKey]["x"]); got != tt.want {
+					t.Errorf("%s (hp=%v) = %q, want %q", tt.expr, hp, got, tt.want)
+				}
+			}
+		}
+	}
+}
+
+func TestStringFunctionErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		code int
+		span string // the source text the error points at
+	}{
+		// errors of the first argument point at the function
+		{`res.x = upper(1)`, CannotUseTypeErr.Code(), "upper"},
+		{`res.x = len(3)`, CannotUseTypeErr.Code(), "len"},
+		{`res.x = to_number("x")`, ArgumentInvalidErr.Code(), "to_number"},
+		{`res.x = substr("abc", 1.5)`, ArgumentInvalidErr.Code(), "1.5"},
+		{`res.x = substr("abc", 0, n)`, ArgumentInvalidErr.Code(), "n"},
+		{`res.x = split("a", 1)`, CannotUseTypeErr.Code(), "1"},
+		{`res.x = join(["a"], 1)`, CannotUseTypeErr.Code(), "1"},
+		{`res.x = pad_left("ab", "3")`, ArgumentNotNumberErr.Code(), "3"},
+		{`res.x = pad_left("", 1e12)`, ArgumentInvalidErr.Code(), "1e12"},
+		{`res.x = pad_left("", 65537)`, ArgumentInvalidErr.Code(), "65537"},
+		{`res.x = replace("a", "b", "c", x)`, ArgumentNotNumberErr.Code(), "x"},
+		{`res.x = format(1)`, CannotUseTypeErr.Code(), "format"},
+	}
+	env := map[string]any{"n": -1, "x": "y"}
+	for _, tt := range tests {
+		for _, bytecode := range []bool{false, true} {
+			p, err := Compile(tt.src, WithBytecode(bytecode))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = p.Run(context.Background(), env)
+			var e *Error
+			if !errors.As(err, &e) {
+				t.Errorf("%s: err = %v, want code %d", tt.src, err, tt.code)
+				continue
+			}
+			if e.Code != tt.code || tt.src[e.Start:e.End] != tt.span {
+				t.Errorf("%s bytecode=%v: code %d at %q, want %d at %q", tt.src, bytecode, e.Code, tt.src[e.Start:e.End], tt.code, tt.span)
+			}
+		}
+	}
+}
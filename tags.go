@@ -5,17 +5,22 @@ import "fmt"
 type TagParser func(parser *Parser) (INode, error)
 
 func (t *template) RegisterTag(name string, parserFn TagParser) error {
-	_, ok := t.tags[name]
+	_, ok := t.prog.tags[name]
 	if ok {
 		return t.ParseErr()(TagRegisteredErr.SetMessagef(name))
 	}
 	fmt.Printf("registering tag '%s' \n", name)
-	t.tags[name] = parserFn
+	t.prog.tags[name] = parserFn
 	return nil
 }
 func defTags() map[string]TagParser {
 	return map[string]TagParser{
-		KeywordIf:  tagIfParser,
-		KeywordSet: tagSetParser,
+		KeywordIf:       tagIfParser,
+		KeywordSet:      tagSetParser,
+		KeywordFor:      tagForParser,
+		KeywordBreak:    tagBreakParser,
+		KeywordContinue: tagContinueParser,
+		KeywordFunc:     tagFuncParser,
+		KeywordReturn:   tagReturnParser,
 	}
 }
@@ -0,0 +1,154 @@
+package mathxf
+
+import (
+	"errors"
+	"reflect"
+)
+
+var (
+	errBreak    = errors.New("break")
+	errContinue = errors.New("continue")
+)
+
+// tagForNode for item in items { } / for k, v in m { }
+// Loop variables live in a scope of their own for every iteration and never leak into ValMap.
+type tagForNode struct {
+	locationToken *Token
+	keyToken      *Token
+	valToken      *Token // nil for a single loop variable
+	keyName       string
+	valName       string
+	iterable      IEvaluator
+	wrapper       *NodeWrapper
+}
+
+func (t *tagForNode) Execute(ctx *EvaluatorContext) error {
+	items, err := t.iterable.Evaluate(ctx)
+	if err != nil {
+		return err
+	}
+	isMap := items.getResolvedValue().Kind() == reflect.Map
+	if !isMap && !items.CanSlice() {
+		pos := t.iterable.GetPositionToken()
+		return VariableNotIterableErr.SetMessagef(pos.val).SetToken(pos)
+	}
+	var loopErr error
+	items.IterateOrder(func(idx, count int, key, value *Value) bool {
+		if err := ctx.step(); err != nil {
+			loopErr = err.SetToken(t.iterable.GetPositionToken())
+			return false
+		}
+		ctx.pushScope()
+		defer ctx.popScope()
+		if t.valName == "" {
+			ctx.Define(t.keyName, NewPrivateValElement(loopValue(key.Val)))
+		} else if isMap {
+			ctx.Define(t.keyName, NewPrivateValElement(loopValue(key.Val)))
+			ctx.Define(t.valName, NewPrivateValElement(loopValue(value.Val)))
+		} else {
+			ctx.Define(t.keyName, NewPrivateValElement(idx))
+			ctx.Define(t.valName, NewPrivateValElement(loopValue(key.Val)))
+		}
+		err := t.wrapper.Execute(ctx)
+		switch {
+		case err == nil, errors.Is(err, errContinue):
+			return true
+		case errors.Is(err, errBreak):
+			return false
+		default:
+			loopErr = err
+			return false
+		}
+	}, func() {}, false, isMap)
+	return loopErr
+}
+
+// loopValue unwraps an item of the iterable, the element handed out by Value.IterateOrder.
+func loopValue(val reflect.Value) any {
+	if val.IsValid() && val.Type() == TypeOfValuePtr {
+		val = val.Interface().(*Value).Val
+	}
+	if !val.IsValid() {
+		return nil
+	}
+	return val.Interface()
+}
+
+func tagForParser(parser *Parser) (INode, error) {
+	forToken := parser.lastToken()
+	res := &tagForNode{locationToken: &forToken}
+	key := parser.NextToken()
+	if err := checkIdentifier(key); err != nil {
+		return nil, err
+	}
+	res.keyToken = &key
+	res.keyName = key.val
+	next := parser.NextToken()
+	if next.typ == TokenComma {
+		val := parser.NextToken()
+		if err := checkIdentifier(val); err != nil {
+			return nil, err
+		}
+		res.valToken = &val
+		res.valName = val.val
+		next = parser.NextToken()
+	}
+	if next.typ != TokenIn {
+		return nil, UnexpectedTokenErr.SetMessagef(forToken.spelling(), next.spelling()).SetToken(&next)
+	}
+	iterable, err := parser.ParseExpression()
+	if err != nil {
+		return nil, err
+	}
+	res.iterable = iterable
+	parser.loopDepth++
+	wrapper, err := parser.WrapUntil()
+	parser.loopDepth--
+	if err != nil {
+		return nil, err
+	}
+	res.wrapper = wrapper
+	return res, nil
+}
+
+func checkIdentifier(t Token) error {
+	if t.typ != TokenIdentifier {
+		return TokenNotIdentifierErr.SetMessagef(t.spelling()).SetToken(&t)
+	}
+	if _, ok := TokenKeywords[t.val]; ok {
+		return VariableIsKeywordErr.SetMessagef(t.spelling()).SetToken(&t)
+	}
+	return nil
+}
+
+type tagBreakNode struct {
+	locationToken *Token
+}
+
+func (t tagBreakNode) Execute(ctx *EvaluatorContext) error {
+	return errBreak
+}
+
+type tagContinueNode struct {
+	locationToken *Token
+}
+
+func (t tagContinueNode) Execute(ctx *EvaluatorContext) error {
+	return errContinue
+}
+
+func tagBreakParser(parser *Parser) (INode, error) {
+	t := parser.lastToken()
+	if parser.loopDepth == 0 {
+		return nil, NotInLoopErr.SetMessagef(t.spelling()).SetToken(&t)
+	}
+	return tagBreakNode{locationToken: &t}, nil
+}
+
+func tagContinueParser(parser *Parser) (INode, error) {
+	t := parser.lastToken()
+	if parser.loopDepth == 0 {
+		return nil, NotInLoopErr.SetMessagef(t.spelling()).SetToken(&t)
+	}
+	return tagContinueNode{locationToken: &t}, nil
+}
@@ -0,0 +1,69 @@
+package mathxf
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestFor(t *testing.T) {
+	env := map[string]any{
+		"items": []int{10, 20, 30, 60, 40},
+		"m":     map[string]int{"b": 2, "a": 1, "c": 3},
+		"empty": []int{},
+	}
+	tests := []struct {
+		src  string
+		want string // the default results as k=v in key order
+	}{
+		{"val s = 0\nfor v in items { s = s + v }\nres.s = s", "s=160"},
+		{"val s = 0\nfor i, v in items { s = s + i * v }\nres.s = s", "s=420"},
+		{"val s = \"\"\nfor k, v in m { s = s + k + v }\nres.s = s", "s=a1b2c3"},
+		{"val s = \"\"\nfor k in m { s = s + k }\nres.s = s", "s=abc"},
+		{"val s = 0\nfor v in items {\n  if v > 30 { break }\n  s = s + v\n}\nres.s = s", "s=60"},
+		{"val s = 0\nfor v in items {\n  if v > 30 { continue }\n  s = s + v\n}\nres.s = s", "s=60"},
+		{"val s = 0\nfor i in range(0, 3) {\n  for i in range(0, 2) { s = s + i }\n  s = s + 10 * i\n}\nres.s = s", "s=33"},
+		{"val n = 0\nfor v in empty { n = n + 1 }\nres.n = n", "n=0"},
+		{"val s = \"\"\nfor i, c in \"你好\" { s = s + i + c }\nres.s = s", "s=0你1好"},
+		{"val v = 1\nfor v in items { res.last = v }\nres.v = v", "last=40 v=1"},
+	}
+	for _, tt := range tests {
+		p, err := Compile(tt.src)
+		if err != nil {
+			t.Fatalf("Compile(%q): %v", tt.src, err)
+		}
+		res, err := p.Run(context.Background(), env)
+		if err != nil {
+			t.Fatalf("Run(%q): %v", tt.src, err)
+		}
+		var kv []string
+		for k, v := range res[DefResultKey] {
+			kv = append(kv, k+"="+v.String())
+		}
+		sort.Strings(kv)
+		if got := strings.Join(kv, " "); got != tt.want {
+			t.Errorf("%q: res = %s, want %s", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestForErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"for v in 1 { res.x = v }", "not iterable"},
+		{"break", "not in a loop"},
+		{"for v in items { val s = v }\nres.s = s", "'s' is invalid"},
+	}
+	for _, tt := range tests {
+		p, err := Compile(tt.src)
+		if err == nil {
+			_, err = p.Run(context.Background(), map[string]any{"items": []int{1}})
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: err = %v, want %q", tt.src, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package mathxf
+
+import (
+	"errors"
+	"fmt"
+)
+
+// returnSignal carries the value of a return statement up to the function call.
+type returnSignal struct {
+	val *Value
+}
+
+func (r *returnSignal) Error() string {
+	return KeywordReturn
+}
+
+// scriptFunc is a function defined in the template by the func tag.
+type scriptFunc struct {
+	name    string
+	params  []string
+	body    *NodeWrapper
+	closure *scope
+}
+
+func (f *scriptFunc) call(ctx *EvaluatorContext, callingArgs []IEvaluator, pos *Token) (*Value, error) {
+	if len(callingArgs) != len(f.params) {
+		return nil, ArgumentNotEnoughErr.SetMessagef(f.name, fmt.Sprintf("=%d", len(f.params)), len(callingArgs)).SetToken(pos)
+	}
+	if ctx.maxDepth > 0 && ctx.depth >= ctx.maxDepth {
+		return nil, CallDepthExceededErr.SetMessagef(f.name, ctx.maxDepth).SetToken(pos)
+	}
+	locals := make(ValElementMap, len(f.params))
+	for i, arg := range callingArgs {
+		val, err := arg.Evaluate(ctx)
+		if err != nil {
+			return nil, err
+		}
+		locals[f.params[i]] = NewPrivateValElement(val.Interface())
+	}
+	caller := ctx.scope
+	ctx.scope = &scope{parent: f.closure, vals: locals}
+	err := f.body.Execute(ctx)
+	ctx.scope = caller
+	var ret *returnSignal
+	if errors.As(err, &ret) {
+		return ret.val, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return AsValue(nil), nil
+}
+
+// tagFuncNode func name(a, b) { ... return expr }
+type tagFuncNode struct {
+	locationToken *Token
+	nameToken     *Token
+	paramTokens   []*Token
+	params        []string
+	body          *NodeWrapper
+}
+
+func (t *tagFuncNode) Execute(ctx *EvaluatorContext) error {
+	name := t.nameToken.val
+	if ctx.declared(name) {
+		return VariableAlreadyExistsErr.SetMessagef(name).SetToken(t.nameToken)
+	}
+	fn := &scriptFunc{
+		name:    name,
+		params:  t.params,
+		body:    t.body,
+		closure: ctx.scope,
+	}
+	ctx.Define(name, NewConstValElement(fn, true))
+	return nil
+}
+
+func tagFuncParser(parser *Parser) (INode, error) {
+	funcToken := parser.lastToken()
+	name := parser.NextToken()
+	if err := checkIdentifier(name); err != nil {
+		return nil, err
+	}
+	res := &tagFuncNode{locationToken: &funcToken, nameToken: &name}
+	next := parser.NextToken()
+	if next.typ != TokenLeftParen {
+		return nil, UnexpectedTokenErr.SetMessagef(funcToken.spelling(), next.spelling()).SetToken(&next)
+	}
+	if parser.PeekToken().typ == TokenRightParen {
+		parser.NextToken()
+	} else {
+		for {
+			param := parser.NextToken()
+			if err := checkIdentifier(param); err != nil {
+				return nil, err
+			}
+			for _, p := range res.params {
+				if p == param.val {
+					return nil, VariableAlreadyExistsErr.SetMessagef(param.val).SetToken(&param)
+				}
+			}
+			res.paramTokens = append(res.paramTokens, &param)
+			res.params = append(res.params, param.val)
+			next = parser.NextToken()
+			if next.typ == TokenRightParen {
+				break
+			}
+			if next.typ != TokenComma {
+				return nil, MissingRightParenErr.SetMessagef(")").SetToken(&next)
+			}
+		}
+	}
+	loopDepth := parser.loopDepth
+	parser.loopDepth = 0
+	parser.funcDepth++
+	body, err := parser.WrapUntil()
+	parser.funcDepth--
+	parser.loopDepth = loopDepth
+	if err != nil {
+		return nil, err
+	}
+	res.body = body
+	return res, nil
+}
+
+// tagReturnNode return / return expr
+type tagReturnNode struct {
+	locationToken *Token
+	expr          IEvaluator
+}
+
+func (t *tagReturnNode) Execute(ctx *EvaluatorContext) error {
+	if t.expr == nil {
+		return &returnSignal{val: AsValue(nil)}
+	}
+	val, err := t.expr.Evaluate(ctx)
+	if err != nil {
+		return err
+	}
+	return &returnSignal{val: val}
+}
+
+func tagReturnParser(parser *Parser) (INode, error) {
+	t := parser.lastToken()
+	if parser.funcDepth == 0 {
+		return nil, NotInFuncErr.SetMessagef(t.spelling()).SetToken(&t)
+	}
+	if parser.PeekToken().typ == TokenRightBigBrackets {
+		return &tagReturnNode{locationToken: &t}, nil
+	}
+	expr, err := parser.ParseExpression()
+	if err != nil {
+		return nil, err
+	}
+	return &tagReturnNode{locationToken: &t, expr: expr}, nil
+}
@@ -0,0 +1,59 @@
+package mathxf
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestFunc(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string // the default results as k=v in key order
+	}{
+		{"func add(a, b) { return a + b }\nres.x = add(1, 2)", "x=3"},
+		{"func fact(n) { return n <= 1 ? 1 : n * fact(n - 1) }\nres.x = fact(5)", "x=120"},
+		{"val k = 10\nfunc f(a) { return a + k }\nres.x = f(1)", "x=11"},
+		{"func outer(a) { func inner(b) { return a + b } return inner(2) }\nres.x = outer(1)", "x=3"},
+		{"val a = 5\nfunc f(a) { return a * 2 }\nres.x = f(1)\nres.y = a", "x=2 y=5"},
+		{"func f(a) { if a > 1 { return } res.y = a }\nf(3)\nf(1)", "y=1"},
+		{"func f(a) { val b = a }\nf(3)\nres.x = 1", "x=1"},
+		{"func f(a) { return a * 2 }\nf(3)", "res2=6"},
+		{"func f() { return }\nres.x = f()", "x="},
+	}
+	for _, tt := range tests {
+		for _, res := range runSrc(t, tt.src, nil) {
+			var kv []string
+			for k, v := range res[DefResultKey] {
+				kv = append(kv, k+"="+toString(v))
+			}
+			sort.Strings(kv)
+			if got := strings.Join(kv, " "); got != tt.want {
+				t.Errorf("%q: res = %s, want %s", tt.src, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestFuncErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		code int
+	}{
+		{"func f(a) { return a }\nres.x = f(1, 2)", ArgumentNotEnoughErr.Code()},
+		{"val f = 1\nfunc f(a) { return a }", VariableAlreadyExistsErr.Code()},
+		{"func f(a) { return b }\nres.x = f(1)", VariableInvalidErr.Code()},
+	}
+	for _, tt := range tests {
+		for _, bytecode := range []bool{false, true} {
+			p, err := Compile(tt.src, WithBytecode(bytecode))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := p.Run(context.Background(), nil); errCode(err) != tt.code {
+				t.Errorf("%q bytecode=%v: err = %v, want code %d", tt.src, bytecode, err, tt.code)
+			}
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package mathxf
 
 type tagIfNode struct {
+	ifTokens   []*Token // the if keyword of every condition
 	conditions []IEvaluator
 	wrappers   []*NodeWrapper
 }
@@ -13,6 +14,11 @@ func (t *tagIfNode) Execute(ctx *EvaluatorContext) error {
 		if err != nil {
 			return err
 		}
+		if ctx.IsStrict {
+			if err := strictBool(condition.GetPositionToken(), res, "condition of "+t.ifTokens[index].spelling()); err != nil {
+				return err
+			}
+		}
 		if res.IsTrue() {
 			return t.wrappers[index].Execute(ctx)
 		}
@@ -25,11 +31,13 @@ func (t *tagIfNode) Execute(ctx *EvaluatorContext) error {
 }
 
 func tagIfParser(parser *Parser) (INode, error) {
+	ifToken := parser.lastToken()
 	condition, err := parser.ParseExpression()
 	if err != nil {
 		return nil, err
 	}
 	ifNode := new(tagIfNode)
+	ifNode.ifTokens = append(ifNode.ifTokens, &ifToken)
 	ifNode.conditions = append(ifNode.conditions, condition)
 	for {
 		wrapper, err := parser.WrapUntil()
@@ -40,7 +48,8 @@ func tagIfParser(parser *Parser) (INode, error) {
 		if parser.PeekToken().val == KeywordElse {
 			parser.NextToken()
 			if parser.PeekToken().val == KeywordIf {
-				parser.NextToken()
+				elseIfToken := parser.NextToken()
+				ifNode.ifTokens = append(ifNode.ifTokens, &elseIfToken)
 				elseIfCondition, err := parser.ParseExpression()
 				if err != nil {
 					return nil, err
@@ -0,0 +1,41 @@
+package mathxf
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// TestConditionErrorsSpellKeywords checks that a non-bool condition is reported with the keyword as written.
+func TestConditionErrorsSpellKeywords(t *testing.T) {
+	keywords := WithKeywords(map[string]string{"如果": "if", "否则": "else"})
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"如果 n { res.x = 1 }", "condition of 如果"},
+		{"如果 n > 1 { res.x = 1 } 否则 如果 n { res.x = 2 }", "condition of 如果"},
+		{"if n { res.x = 1 }", "condition of if"},
+		{"res.x = n ? 1 : 2", "condition of ?"},
+	}
+	for _, tt := range tests {
+		for _, bytecode := range []bool{false, true} {
+			p, err := Compile(tt.src, keywords, WithStrictTypes(true), WithBytecode(bytecode))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = p.Run(context.Background(), map[string]any{"n": 1})
+			if errCode(err) != CannotUseTypeErr.Code() || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("%q bytecode=%v: err = %v, want %q", tt.src, bytecode, err, tt.want)
+			}
+		}
+	}
+	p, err := Compile("如果 n { res.x = 1 }", keywords)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := p.CheckTypes(Schema{"n": NumberType})
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "condition of 如果") {
+		t.Errorf("CheckTypes() = %v, want the condition of 如果", errs)
+	}
+}
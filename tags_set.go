@@ -1,11 +1,13 @@
 package mathxf
 
 type tagSetNode struct {
-	setNodes []*SetNode
-	isAssign bool
+	locationToken *Token
+	setNodes      []*SetNode
+	isAssign      bool
 }
 
 type SetNode struct {
+	nameToken  *Token
 	name       string
 	expression IEvaluator
 }
@@ -20,23 +22,24 @@ func (t tagSetNode) Execute(ctx *EvaluatorContext) error {
 				return err
 			}
 		}
-		_, has := ctx.ValMap[set.name]
 		_, isRes := ctx.ResultMap[set.name]
-		if has || isRes {
+		if ctx.declared(set.name) || isRes {
 			pos := set.expression.GetPositionToken()
-			return VariableAlreadyExistsErr.SetMessagef(set.name).SetPosition(pos.line, pos.col)
+			return VariableAlreadyExistsErr.SetMessagef(set.name).SetToken(pos)
 		}
-		ctx.ValMap[set.name] = NewPrivateValElement(val)
+		ctx.Define(set.name, NewPrivateValElement(val))
 	}
 	return nil
 }
 func tagSetParser(parser *Parser) (INode, error) {
+	valToken := parser.lastToken()
 	res := tagSetNode{
-		setNodes: make([]*SetNode, 0),
+		locationToken: &valToken,
+		setNodes:      make([]*SetNode, 0),
 	}
 	var isAssign bool
 	var isComma bool
-	setNameArr := make([]string, 0)
+	setNameArr := make([]*Token, 0)
 	for {
 		next := parser.NextToken()
 		if next.typ != TokenIdentifier {
@@ -49,9 +52,9 @@ func tagSetParser(parser *Parser) (INode, error) {
 				parser.Backup()
 				break
 			}
-			return nil, VariableIsKeywordErr.SetMessagef(next.val).SetPosition(next.line, next.col)
+			return nil, VariableIsKeywordErr.SetMessagef(next.spelling()).SetToken(&next)
 		}
-		setNameArr = append(setNameArr, next.val)
+		setNameArr = append(setNameArr, &next)
 		assign := parser.NextToken()
 		if assign.typ == TokenAssign {
 			isAssign = true
@@ -64,7 +67,7 @@ func tagSetParser(parser *Parser) (INode, error) {
 	}
 	if len(setNameArr) == 0 {
 		peek := parser.PeekToken()
-		return nil, TokenNotIdentifierErr.SetMessagef(peek.val).SetPosition(peek.line, peek.col)
+		return nil, TokenNotIdentifierErr.SetMessagef(peek.spelling()).SetToken(&peek)
 	}
 	var exp IEvaluator
 	var err error
@@ -80,7 +83,8 @@ func tagSetParser(parser *Parser) (INode, error) {
 	}
 	for _, name := range setNameArr {
 		res.setNodes = append(res.setNodes, &SetNode{
-			name:       name,
+			nameToken:  name,
+			name:       name.val,
 			expression: exp,
 		})
 	}
@@ -93,7 +97,8 @@ func setNodeParser(parser *Parser) (*SetNode, bool, error) {
 	assign := parser.NextToken()
 	if assign.typ != TokenAssign {
 		res := &SetNode{
-			name: next.val,
+			nameToken: &next,
+			name:      next.val,
 			expression: &numberResolver{
 				locationToken: &next,
 				val:           0,
@@ -110,6 +115,7 @@ func setNodeParser(parser *Parser) (*SetNode, bool, error) {
 		return nil, false, err
 	}
 	return &SetNode{
+		nameToken:  &next,
 		name:       next.val,
 		expression: expression,
 	}, false, nil
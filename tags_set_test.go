@@ -0,0 +1,67 @@
+package mathxf
+
+import (
+	"context"
+	"testing"
+)
+
+func TestValShadowsBuiltin(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"val amount = 5\nres.x = amount + 1", "6"},
+		{"val currency = \"CNY\"\nres.x = currency", "CNY"},
+		{"val split = 3\nres.x = split * 2", "6"},
+		{"val money, allocate = 2\nres.x = money + allocate", "4"},
+		{"func amount(a) { return a * 2 }\nres.x = amount(4)", "8"},
+		{"for amount in [1, 2] { res.x = amount }", "2"},
+		{"val day = 5\nres.x = day", "5"},
+		{"val year, month = 1\nres.x = year + month", "2"},
+		{"val now = \"later\"\nres.x = now", "later"},
+		{"for second in [7] { res.x = second }", "7"},
+		{"func date(d) { return d * 2 }\nres.x = date(3)", "6"},
+		{"val len = 5\nres.x = len", "5"},
+		{"val format = \"x\"\nres.x = format + upper(\"y\")", "xY"},
+		{"func replace(s) { return s + \"!\" }\nres.x = replace(\"a\")", "a!"},
+	}
+	for _, tt := range tests {
+		for _, res := range runSrc(t, tt.src, nil) {
+			if got := res[DefResultKey]["x"].String(); got != tt.want {
+				t.Errorf("%q: res.x = %s, want %s", tt.src, got, tt.want)
+			}
+		}
+		p, err := Compile(tt.src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if errs := p.Check(); len(errs) > 0 {
+			t.Errorf("%q: Check() = %v, want no errors", tt.src, errs)
+		}
+	}
+}
+
+func TestValCollidesWithDeclaredNames(t *testing.T) {
+	tests := []struct {
+		src  string
+		env  map[string]any
+		opts []Option
+	}{
+		{src: "val rate = 2", opts: []Option{WithFuncOrConst("rate", 1)}},
+		{src: "val rate = 2", env: map[string]any{"rate": 1}},
+		{src: "val a = 1\nval a = 2"},
+		{src: "val res = 1"},
+	}
+	for _, tt := range tests {
+		for _, bytecode := range []bool{false, true} {
+			p, err := Compile(tt.src, append(tt.opts, WithBytecode(bytecode))...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = p.Run(context.Background(), tt.env)
+			if errCode(err) != VariableAlreadyExistsErr.Code() {
+				t.Errorf("%q bytecode=%v: err = %v, want VariableAlreadyExistsErr", tt.src, bytecode, err)
+			}
+		}
+	}
+}
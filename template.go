@@ -4,9 +4,8 @@ import (
 	"context"
 	"log"
 	"os"
-	"reflect"
-	"sort"
-	"strings"
+	"sync"
+	"time"
 )
 
 const DefResultKey = "res"
@@ -23,147 +22,143 @@ func logf(format string, items ...any) {
 }
 
 type template struct {
-	tpl      string // the string being scanned
-	ctx      *EvaluatorContext
-	tags     map[string]TagParser
-	keyOrder []string
-	strMap   map[string]string
+	tpl     string // the string being scanned
+	prog    *Program
+	context context.Context
 
-	root *nodeDocument
+	mu     sync.Mutex
+	valMap ValElementMap // ValMap of the last Execute
 }
 
 func Debug(b bool) {
 	debug = b
 }
+
+// AddFuncOrConst registers a constant or function on this template only, it never changes DefConst.
 func (t *template) AddFuncOrConst(name string, val any) error {
-	if _, ok := t.ctx.ValMap[name]; ok {
-		return t.ParseErr()(ConstRegisteredErr.SetMessagef(name))
-	}
-	switch reflect.ValueOf(val).Kind() {
-	case reflect.Func:
-		t.ctx.ValMap[name] = NewConstValElement(val, true)
-	default:
-		t.ctx.ValMap[name] = NewConstValElement(val, false)
-	}
-	return nil
+	return WithFuncOrConst(name, val)(t.prog)
 }
 func (t *template) SetParseErrFn(fn ParseECodeFn) {
-	t.ctx.parseErrFn = fn
+	t.prog.parseErrFn = fn
 }
 func (t *template) SetContext(ctx context.Context) {
-	t.ctx.Context = ctx
+	t.context = ctx
 }
 func (t *template) SetDefResultKey(key string) {
-	t.ctx.defResultKey = key
+	t.prog.defResultKey = key
 }
 func (t *template) AddResultKeys(keys ...string) error {
-	for _, key := range keys {
-		if key == DefResultEnvKey {
-			return t.ParseErr()(ResultKeyRegisteredErr.SetMessagef(key))
-		}
-		_, ok := t.ctx.ResultMap[key]
-		if ok {
-			return t.ParseErr()(ResultKeyRegisteredErr.SetMessagef(key))
-		}
-		t.ctx.ResultMap[key] = make(ValMap)
-	}
-	return nil
+	return WithResultKeys(keys...)(t.prog)
 }
 func (t *template) HighPrecision(b bool) {
-	t.ctx.IsHighPrecision = b
+	t.prog.isHighPrecision = b
+}
+func (t *template) StrictTypes(b bool) {
+	t.prog.isStrict = b
+}
+func (t *template) SetDivisionScale(scale int32) {
+	t.prog.numeric.DivScale = scale
+}
+func (t *template) SetMathScale(scale int32) {
+	t.prog.numeric.MathScale = scale
+}
+func (t *template) SetRounding(mode RoundingMode) {
+	t.prog.numeric.Rounding = mode
+}
+func (t *template) SetResultScale(scale int32) {
+	t.prog.numeric.ResultScale = scale
+}
+func (t *template) SetLocation(loc *time.Location) {
+	t.prog.location = loc
+}
+func (t *template) SetNow(now func() time.Time) {
+	t.prog.now = now
+}
+func (t *template) Optimize(b bool) {
+	t.prog.optimize = b
+}
+func (t *template) Bytecode(b bool) {
+	t.prog.useBytecode = b
+}
+func (t *template) SetLocale(locale string) {
+	t.prog.locale = locale
+}
+func (t *template) SetMaxSteps(n int) {
+	t.prog.maxSteps = n
+}
+func (t *template) SetTimeout(d time.Duration) {
+	t.prog.timeout = d
+}
+func (t *template) SetMaxDepth(n int) {
+	t.prog.maxDepth = n
 }
 
 // ReplaceStrMap strMap map[string]string ,map value must Ensure that the string contains at least one letter,
 // while allowing numbers and underscores.For example: 'abc'、'abc123'、'abc_123'".
 func (t *template) ReplaceStrMap(strMap map[string]string) error {
-	for k, v := range strMap {
-		if !containsAtLeastOneLetter(v) {
-			return t.ParseErr()(InvalidReplaceStrErr.SetMessagef(v))
-		}
-		t.keyOrder = append(t.keyOrder, k)
+	return WithReplaceStrMap(strMap)(t.prog)
+}
+
+// Keywords adds localized spellings of keywords, e.g. {"如果": "if", "否则": "else"}, see WithKeywords.
+func (t *template) Keywords(keywords map[string]string) error {
+	return WithKeywords(keywords)(t.prog)
+}
+
+// Code returns the template with its display strings translated into code.
+func (t *template) Code() (string, error) {
+	return ToCode(t.tpl, t.prog.strMap)
+}
+
+// Display returns the template in the display form of strMap, of ReplaceStrMap if strMap is nil.
+func (t *template) Display(strMap map[string]string) (string, error) {
+	code, err := t.Code()
+	if err != nil {
+		return "", err
 	}
-	sort.Slice(t.keyOrder, func(i, j int) bool {
-		return len(t.keyOrder[i]) > len(t.keyOrder[j])
-	})
-	t.strMap = strMap
-	return nil
+	if strMap == nil {
+		strMap = t.prog.strMap
+	}
+	return ToDisplay(code, strMap)
 }
 
 func NewTemplate(tpl string) (*template, error) {
 	t := &template{
-		tpl:    tpl,
-		strMap: make(map[string]string),
-		tags:   defTags(),
-		ctx: &EvaluatorContext{
-			Context:         context.TODO(),
-			IsHighPrecision: true,
-			ValMap:          DefConst,
-			ResultMap:       make(map[string]ValMap),
-			defResultKey:    DefResultKey,
-			parseErrFn:      ParseErr,
-		},
+		tpl:     tpl,
+		prog:    newProgram(),
+		context: context.TODO(),
 	}
-	t.ctx.ResultMap[t.ctx.defResultKey] = make(ValMap)
 	return t, nil
 }
 func (t *template) ParseErr() ParseECodeFn {
-	return t.ctx.parseErrFn
+	return t.prog.parseErrFn
 }
 func (t *template) Execute(env map[string]any) (map[string]ValMap, error) {
-	if t.root == nil {
-		for _, k := range t.keyOrder {
-			t.tpl = strings.ReplaceAll(t.tpl, k, t.strMap[k])
-		}
-		l := lex(t.tpl)
-		l.run()
-		parse := &Parser{
-			lex:  l,
-			tags: t.tags,
-		}
-		var err error
-		root, err := parse.ParseDocument()
-		if err != nil {
+	t.mu.Lock()
+	if t.prog.root == nil {
+		if err := t.prog.parse(t.tpl); err != nil {
+			t.mu.Unlock()
 			return nil, err
 		}
-		t.root = root
-	} else {
-		t.ctx.ResultMap = make(map[string]ValMap)
-		t.ctx.ResultMap[t.ctx.defResultKey] = make(ValMap)
-		for k, v := range t.ctx.ValMap {
-			if v.ValType != PublicVal {
-				continue
-			}
-			delete(t.ctx.ValMap, k)
-		}
-	}
-	for k, v := range env {
-		t.ctx.ValMap[k] = NewPublicValElement(v)
-	}
-	err := t.root.Execute(t.ctx)
-	if err != nil {
-		return nil, err
-	}
-	_env := make(ValMap)
-	for k, ele := range t.ctx.ValMap {
-		if ele.ValType != PublicVal {
-			continue
-		}
-		if ele.IsSet {
-			_env[k] = AsValue(ele.Val)
-		}
 	}
-	if len(_env) > 0 {
-		t.ctx.ResultMap[DefResultEnvKey] = _env
+	t.mu.Unlock()
+	ctx, res, err := t.prog.run(t.context, env)
+	t.mu.Lock()
+	t.valMap = nil
+	if ctx != nil {
+		t.valMap = ctx.ValMap
 	}
-	return t.ctx.ResultMap, nil
+	t.mu.Unlock()
+	return res, err
 }
 func (t *template) PublicValMap() ValElementMap {
 	return t.getValMap(PublicVal)
 }
 
 func (t *template) getValMap(valType ValType) ValElementMap {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	res := make(ValElementMap)
-	for k, v := range t.ctx.ValMap {
+	for k, v := range t.valMap {
 		if v.ValType != valType {
 			continue
 		}
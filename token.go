@@ -11,6 +11,17 @@ type Token struct {
 	line int       // The line number of this Token.
 	col  int       // The column number of this Token.
 	val  string    // The value of this Token.
+	pos  int       // The byte offset of the start of this Token.
+	end  int       // The byte offset of the end of this Token.
+	text string    // The source spelling of a localized keyword, see WithKeywords.
+}
+
+// spelling returns the Token as written in the source, i.e. the localized word of a keyword.
+func (t *Token) spelling() string {
+	if t.text != "" {
+		return t.text
+	}
+	return t.val
 }
 
 func (t Token) String() string {
@@ -61,7 +72,9 @@ const (
 	TokenOr  // || or or
 	TokenNot // ! or not
 	TokenIn
-	TokenNil // nil
+	TokenNil      // nil
+	TokenMoney    // 100.00 CNY
+	TokenDuration // 3d or 1h30m
 )
 
 const (
@@ -71,30 +84,40 @@ const (
 )
 
 const (
-	KeywordIf    = "if"
-	KeywordElse  = "else"
-	KeywordSet   = "val"
-	KeywordTrue  = "true"
-	KeywordFalse = "false"
-	KeywordAnd   = "and"
-	KeywordOr    = "or"
-	KeywordNot   = "not"
-	KeywordIn    = "in"
-	KeywordNil   = "nil"
+	KeywordIf       = "if"
+	KeywordElse     = "else"
+	KeywordSet      = "val"
+	KeywordFor      = "for"
+	KeywordBreak    = "break"
+	KeywordContinue = "continue"
+	KeywordFunc     = "func"
+	KeywordReturn   = "return"
+	KeywordTrue     = "true"
+	KeywordFalse    = "false"
+	KeywordAnd      = "and"
+	KeywordOr       = "or"
+	KeywordNot      = "not"
+	KeywordIn       = "in"
+	KeywordNil      = "nil"
 )
 
 var (
 	TokenKeywords = map[string]tokenType{
-		KeywordTrue:  TokenBool,
-		KeywordFalse: TokenBool,
-		KeywordNil:   TokenNil,
-		KeywordIn:    TokenIn,
-		KeywordAnd:   TokenAnd,
-		KeywordOr:    TokenOr,
-		KeywordNot:   TokenNot,
-		KeywordIf:    TokenIdentifier,
-		KeywordElse:  TokenIdentifier,
-		KeywordSet:   TokenIdentifier,
+		KeywordTrue:     TokenBool,
+		KeywordFalse:    TokenBool,
+		KeywordNil:      TokenNil,
+		KeywordIn:       TokenIn,
+		KeywordAnd:      TokenAnd,
+		KeywordOr:       TokenOr,
+		KeywordNot:      TokenNot,
+		KeywordIf:       TokenIdentifier,
+		KeywordElse:     TokenIdentifier,
+		KeywordSet:      TokenIdentifier,
+		KeywordFor:      TokenIdentifier,
+		KeywordBreak:    TokenIdentifier,
+		KeywordContinue: TokenIdentifier,
+		KeywordFunc:     TokenIdentifier,
+		KeywordReturn:   TokenIdentifier,
 	}
 )
 
@@ -0,0 +1,127 @@
+package mathxf
+
+import (
+	"sort"
+	"strings"
+)
+
+// ToCode translates the display strings of strMap in src into their code, like WithReplaceStrMap
+// does before parsing. Everything else, including whitespace, comments and string literals, is kept.
+func ToCode(src string, strMap map[string]string) (string, error) {
+	l := lex(src)
+	l.aliases, l.aliasOrder = strMap, aliasOrder(strMap)
+	var out strings.Builder
+	last := 0
+	for {
+		t := l.nextToken()
+		if t.typ == TokenEOF {
+			break
+		}
+		if t.typ == TokenError {
+			return "", translateErr(src, &t)
+		}
+		if t.pos < last {
+			// another token of the same display string
+			continue
+		}
+		text := src[t.pos:t.end]
+		if code, ok := strMap[text]; ok && t.val != text {
+			out.WriteString(src[last:t.pos])
+			out.WriteString(code)
+			last = t.end
+		}
+	}
+	out.WriteString(src[last:])
+	return out.String(), nil
+}
+
+// ToDisplay translates code back into display strings, it is the inverse of ToCode.
+// A variable path like user.level is replaced as a whole if it is the code of a display string,
+// the longest path first. Two display strings with the same code are reported as ambiguous.
+func ToDisplay(code string, strMap map[string]string) (string, error) {
+	display := make(map[string]string, len(strMap))
+	for _, k := range aliasOrder(strMap) {
+		v := strMap[k]
+		if other, ok := display[v]; ok {
+			return "", AmbiguousReplaceStrErr.SetMessagef(other, k, v)
+		}
+		display[v] = k
+	}
+	l := lex(code)
+	var tokens []Token
+	for {
+		t := l.nextToken()
+		if t.typ == TokenEOF {
+			break
+		}
+		if t.typ == TokenError {
+			return "", translateErr(code, &t)
+		}
+		tokens = append(tokens, t)
+	}
+	var out strings.Builder
+	last := 0
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		if t.typ != TokenIdentifier {
+			continue
+		}
+		match := -1
+		for j := i; j < len(tokens) && (j == i || tokens[j].typ == TokenField); j++ {
+			if _, ok := display[code[t.pos:tokens[j].end]]; ok {
+				match = j
+			}
+		}
+		if match < 0 {
+			continue
+		}
+		end := tokens[match].end
+		out.WriteString(code[last:t.pos])
+		out.WriteString(display[code[t.pos:end]])
+		last = end
+		i = match
+	}
+	out.WriteString(code[last:])
+	return out.String(), nil
+}
+
+// Code returns the source of the program with its display strings translated into code, e.g. to store it.
+func (p *Program) Code() (string, error) {
+	return ToCode(p.src, p.strMap)
+}
+
+// Display returns the source of the program in display form of strMap, the display strings
+// of the program itself if strMap is nil.
+func (p *Program) Display(strMap map[string]string) (string, error) {
+	code, err := p.Code()
+	if err != nil {
+		return "", err
+	}
+	if strMap == nil {
+		strMap = p.strMap
+	}
+	return ToDisplay(code, strMap)
+}
+
+// aliasOrder returns the keys of strMap, longer keys first.
+func aliasOrder(strMap map[string]string) []string {
+	keys := make([]string, 0, len(strMap))
+	for k := range strMap {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) > len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+func translateErr(src string, t *Token) error {
+	err := ParseErr(LexerTokenErr.SetMessagef(t.val).SetToken(t))
+	if e, ok := err.(*Error); ok {
+		return e.withSource(src)
+	}
+	return err
+}
@@ -0,0 +1,65 @@
+package mathxf
+
+import "testing"
+
+var translateMap = map[string]string{
+	"用户A.总订单数":          "TotalOrders",
+	"用户A.用户等级":          "UserLevel",
+	"特价商品区.单价小于10元.订单数": "SpecialOffer_10_Orders",
+	"优惠劵面值":             "CouponFace",
+	"订单数":               "Orders",
+}
+
+func TestTranslate(t *testing.T) {
+	tests := []struct {
+		display string
+		code    string
+	}{
+		{
+			"// 用户优惠劵面值计算规则\nif 用户A.总订单数 > 5 && 特价商品区.单价小于10元.订单数<=3 {\n   优惠劵面值 = 用户A.用户等级 * 10\n}",
+			"// 用户优惠劵面值计算规则\nif TotalOrders > 5 && SpecialOffer_10_Orders<=3 {\n   CouponFace = UserLevel * 10\n}",
+		},
+		{
+			"res.x = \"订单数\" + 订单数 /* 订单数 */ + 订单数量",
+			"res.x = \"订单数\" + Orders /* 订单数 */ + 订单数量",
+		},
+		{"res.x   =  订单数\t* 2\n\n", "res.x   =  Orders\t* 2\n\n"},
+		{"res.x = user.level", "res.x = user.level"},
+	}
+	for _, tt := range tests {
+		code, err := ToCode(tt.display, translateMap)
+		if err != nil || code != tt.code {
+			t.Errorf("ToCode(%q) = %q, %v, want %q", tt.display, code, err, tt.code)
+		}
+		display, err := ToDisplay(tt.code, translateMap)
+		if err != nil || display != tt.display {
+			t.Errorf("ToDisplay(%q) = %q, %v, want %q", tt.code, display, err, tt.display)
+		}
+	}
+}
+
+func TestProgramCodeDisplay(t *testing.T) {
+	src := "if 用户A.总订单数 > 5 {\n  优惠劵面值 = 1 // 面值\n}"
+	p, err := Compile(src, WithReplaceStrMap(translateMap))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := p.Code()
+	if want := "if TotalOrders > 5 {\n  CouponFace = 1 // 面值\n}"; err != nil || code != want {
+		t.Errorf("Code() = %q, %v, want %q", code, err, want)
+	}
+	if display, err := p.Display(nil); err != nil || display != src {
+		t.Errorf("Display(nil) = %q, %v, want %q", display, err, src)
+	}
+	en := map[string]string{"Total orders": "TotalOrders"}
+	if display, err := p.Display(en); err != nil || display != "if Total orders > 5 {\n  CouponFace = 1 // 面值\n}" {
+		t.Errorf("Display(en) = %q, %v", display, err)
+	}
+}
+
+func TestToDisplayAmbiguous(t *testing.T) {
+	_, err := ToDisplay("res.x = a", map[string]string{"甲": "a", "乙": "a"})
+	if err == nil || Cause(err).Code() != AmbiguousReplaceStrErr.Code() {
+		t.Errorf("err = %v, want %v", err, AmbiguousReplaceStrErr)
+	}
+}
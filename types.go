@@ -0,0 +1,286 @@
+package mathxf
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Kind is the kind of a Type.
+type Kind int
+
+const (
+	KindAny Kind = iota
+	KindNumber
+	KindString
+	KindBool
+	KindTime
+	KindList
+	KindMap
+	KindNil
+	KindMoney
+	KindDuration
+)
+
+var kindNames = [...]string{
+	KindAny:      "any",
+	KindNumber:   "number",
+	KindString:   "string",
+	KindBool:     "bool",
+	KindTime:     "time",
+	KindList:     "list",
+	KindMap:      "map",
+	KindNil:      "nil",
+	KindMoney:    "money",
+	KindDuration: "duration",
+}
+
+func (k Kind) String() string {
+	if k >= 0 && int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return fmt.Sprintf("Kind(%d)", int(k))
+}
+
+// Type is the static type of an expression or of an env variable, see Schema and Program.CheckTypes.
+type Type struct {
+	Kind   Kind
+	Elem   *Type            // element type of a list or map, nil means any
+	Fields map[string]*Type // known fields of a map or struct, nil if the fields are not declared
+}
+
+// Predeclared types. Decimal is the same type as Number, both ints, floats and decimals are numbers.
+var (
+	AnyType      = &Type{Kind: KindAny}
+	NumberType   = &Type{Kind: KindNumber}
+	DecimalType  = NumberType
+	StringType   = &Type{Kind: KindString}
+	BoolType     = &Type{Kind: KindBool}
+	TimeType     = &Type{Kind: KindTime}
+	MoneyType    = &Type{Kind: KindMoney}
+	DurationType = &Type{Kind: KindDuration}
+	NilType      = &Type{Kind: KindNil}
+)
+
+// ListOf returns the type of a list of elem.
+func ListOf(elem *Type) *Type {
+	return &Type{Kind: KindList, Elem: elem}
+}
+
+// MapOf returns the type of a map with string keys and elem values.
+func MapOf(elem *Type) *Type {
+	return &Type{Kind: KindMap, Elem: elem}
+}
+
+// ObjectOf returns the type of a struct or of a map with the given fields.
+func ObjectOf(fields map[string]*Type) *Type {
+	return &Type{Kind: KindMap, Fields: fields}
+}
+
+func (t *Type) String() string {
+	if t == nil {
+		return KindAny.String()
+	}
+	switch {
+	case t.Fields != nil:
+		names := make([]string, 0, len(t.Fields))
+		for name := range t.Fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for i, name := range names {
+			names[i] = name + ": " + t.Fields[name].String()
+		}
+		return "{" + strings.Join(names, ", ") + "}"
+	case t.Kind == KindList || t.Kind == KindMap:
+		return fmt.Sprintf("%s[%s]", t.Kind, t.Elem)
+	}
+	return t.Kind.String()
+}
+
+// elem returns the element type of a list or map.
+func (t *Type) elem() *Type {
+	if t.Elem == nil {
+		return AnyType
+	}
+	return t.Elem
+}
+
+// assignableTo reports whether a value of type t can be used where u is expected.
+func (t *Type) assignableTo(u *Type) bool {
+	if t.Kind == KindAny || u.Kind == KindAny || t.Kind == KindNil {
+		return true
+	}
+	return t.Kind == u.Kind
+}
+
+// ordered reports whether values of type t can be compared with < and >.
+func (t *Type) ordered() bool {
+	return t.Kind == KindNumber || t.Kind == KindTime || t.Kind == KindMoney || t.Kind == KindDuration || t.Kind == KindAny
+}
+
+var (
+	typeOfTime     = reflect.TypeOf(time.Time{})
+	typeOfDuration = reflect.TypeOf(time.Duration(0))
+)
+
+// TypeOf returns the Type of values of the Go type rt.
+func TypeOf(rt reflect.Type) *Type {
+	if rt == nil {
+		return NilType
+	}
+	switch rt {
+	case TypeOfValuePtr:
+		return AnyType
+	case TypeOfDecimalPtr.Elem():
+		return NumberType
+	case typeOfTime:
+		return TimeType
+	case typeOfDuration:
+		return DurationType
+	case typeOfMoney:
+		return MoneyType
+	}
+	switch rt.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return NumberType
+	case reflect.String:
+		return StringType
+	case reflect.Bool:
+		return BoolType
+	case reflect.Slice, reflect.Array:
+		return ListOf(TypeOf(rt.Elem()))
+	case reflect.Map:
+		return MapOf(TypeOf(rt.Elem()))
+	case reflect.Ptr:
+		return TypeOf(rt.Elem())
+	case reflect.Struct:
+		fields := make(map[string]*Type)
+		for i := 0; i < rt.NumField(); i++ {
+			if f := rt.Field(i); f.IsExported() {
+				fields[f.Name] = TypeOf(f.Type)
+			}
+		}
+		return ObjectOf(fields)
+	}
+	return AnyType
+}
+
+// typeOfValue returns the Type of the runtime value v, lists and maps are described by their Go type.
+func typeOfValue(v *Value) *Type {
+	if v == nil || v.IsNil() {
+		return NilType
+	}
+	if inner, ok := v.Interface().(*Value); ok {
+		return typeOfValue(inner)
+	}
+	return TypeOf(v.getResolvedValue().Type())
+}
+
+// Schema declares the types of the env variables of a program.
+type Schema map[string]*Type
+
+// Names returns the sorted names of the env variables of s, e.g. for Program.Check.
+func (s Schema) Names() []string {
+	names := make([]string, 0, len(s))
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SchemaOf derives a Schema from a Go struct, whose exported fields are the env variables,
+// or from a sample env map[string]any.
+func SchemaOf(v any) (Schema, error) {
+	if env, ok := v.(map[string]any); ok {
+		s := make(Schema, len(env))
+		for name, val := range env {
+			s[name] = typeOfValue(AsValue(val))
+		}
+		return s, nil
+	}
+	rt := reflect.TypeOf(v)
+	if t, ok := v.(reflect.Type); ok {
+		rt = t
+	}
+	t := TypeOf(rt)
+	if t.Fields == nil {
+		return nil, InvalidSchemaErr.SetMessagef(fmt.Sprintf("%T is not a struct", v))
+	}
+	return Schema(t.Fields), nil
+}
+
+// jsonSchema is the part of JSON Schema understood by SchemaFromJSON.
+type jsonSchema struct {
+	Type       any                    `json:"type"`
+	Format     string                 `json:"format"`
+	Items      *jsonSchema            `json:"items"`
+	Properties map[string]*jsonSchema `json:"properties"`
+	Additional any                    `json:"additionalProperties"`
+}
+
+// SchemaFromJSON reads a JSON Schema of an object, its properties are the env variables.
+// The types number, integer, string, boolean, array, object and null are supported,
+// a string with format date-time or date is a time and one with format duration is a duration.
+func SchemaFromJSON(data []byte) (Schema, error) {
+	var js jsonSchema
+	if err := json.Unmarshal(data, &js); err != nil {
+		return nil, InvalidSchemaErr.SetMessagef(err.Error())
+	}
+	if js.Properties == nil {
+		return nil, InvalidSchemaErr.SetMessagef("the schema has no properties")
+	}
+	return Schema(js.toType().Fields), nil
+}
+
+func (js *jsonSchema) toType() *Type {
+	if js == nil {
+		return AnyType
+	}
+	typ, _ := js.Type.(string)
+	if js.Type == nil && js.Properties != nil {
+		typ = "object"
+	}
+	switch typ {
+	case "number", "integer":
+		return NumberType
+	case "string":
+		if js.Format == "date-time" || js.Format == "date" {
+			return TimeType
+		}
+		if js.Format == "duration" {
+			return DurationType
+		}
+		return StringType
+	case "boolean":
+		return BoolType
+	case "null":
+		return NilType
+	case "array":
+		return ListOf(js.Items.toType())
+	case "object":
+		if js.Properties == nil {
+			elem := AnyType
+			if add, ok := js.Additional.(map[string]any); ok {
+				data, _ := json.Marshal(add)
+				var sub jsonSchema
+				if json.Unmarshal(data, &sub) == nil {
+					elem = sub.toType()
+				}
+			}
+			return MapOf(elem)
+		}
+		fields := make(map[string]*Type, len(js.Properties))
+		for name, prop := range js.Properties {
+			fields[name] = prop.toType()
+		}
+		return ObjectOf(fields)
+	}
+	return AnyType
+}
@@ -1,9 +1,22 @@
 package mathxf
 
-import "regexp"
+import (
+	"regexp"
+	"unicode"
+)
 
 func containsAtLeastOneLetter(s string) bool {
 	pattern := "[a-zA-Z0-9_]*[a-zA-Z][a-zA-Z0-9_]*"
 	matcher := regexp.MustCompile(pattern)
 	return matcher.MatchString(s)
 }
+
+// isIdentifier reports whether s is scanned as a single identifier.
+func isIdentifier(s string) bool {
+	for i, r := range s {
+		if !isAlphaNumeric(r) || i == 0 && unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return s != ""
+}
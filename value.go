@@ -122,6 +122,18 @@ func (v *Value) IsTime() bool {
 	return ok
 }
 
+// IsMoney checks whether the underlying value is a Money.
+func (v *Value) IsMoney() bool {
+	_, ok := v.Interface().(Money)
+	return ok
+}
+
+// IsDuration checks whether the underlying value is a time.Duration.
+func (v *Value) IsDuration() bool {
+	_, ok := v.Interface().(time.Duration)
+	return ok
+}
+
 // IsNil checks whether the underlying value is NIL
 func (v *Value) IsNil() bool {
 	// fmt.Printf("%+v\n", v.getResolvedValue().Type().String())
@@ -259,6 +271,19 @@ func (v *Value) Time() time.Time {
 	return time.Time{}
 }
 
+// Money returns the underlying value as Money.
+// If the underlying value is not a Money, it returns the zero value of Money.
+func (v *Value) Money() Money {
+	m, _ := v.Interface().(Money)
+	return m
+}
+
+// Duration returns the underlying value as time.Duration.
+func (v *Value) Duration() time.Duration {
+	d, _ := v.Interface().(time.Duration)
+	return d
+}
+
 // IsTrue tries to evaluate the underlying value the Pythonic-way:
 //
 // Returns TRUE in one the following cases:
@@ -268,11 +293,19 @@ func (v *Value) Time() time.Time {
 //   - float != 0.0
 //   - len(array/chan/map/slice/string) > 0
 //   - bool == true
+//   - Money with an amount != 0
 //   - underlying value is a struct
 //
 // Otherwise returns always FALSE.
 func (v *Value) IsTrue() bool {
+	if m, ok := v.Interface().(Money); ok {
+		return !m.Amount.IsZero()
+	}
 	val := v.getResolvedValue()
+	if val.IsValid() && val.Type() == TypeOfDecimalPtr.Elem() {
+		// a decimal is a struct, it is a number like the numbers of the float mode
+		return !val.Interface().(decimal.Decimal).IsZero()
+	}
 	switch val.Kind() {
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 		return val.Int() != 0
@@ -287,13 +320,6 @@ func (v *Value) IsTrue() bool {
 	case reflect.Struct:
 		return true // struct instance is always true
 	default:
-		if val.Type() == TypeOfDecimalPtr.Elem() {
-			b, ok := val.Interface().(decimal.Decimal)
-			if ok {
-				return b.Cmp(decimal.Zero) > 0
-			}
-			return false
-		}
 		logf("Value.IsTrue() not available for type: %s\n", v.getResolvedValue().Kind().String())
 		return false
 	}
@@ -536,6 +562,10 @@ func (v *Value) EqualValueTo(other *Value) bool {
 	if v.IsTime() && other.IsTime() {
 		return v.Time().Equal(other.Time())
 	}
+	if v.IsMoney() && other.IsMoney() {
+		m1, m2 := v.Money(), other.Money()
+		return m1.Currency == m2.Currency && m1.Amount.Equal(m2.Amount)
+	}
 	if !v.Val.IsValid() || !other.Val.IsValid() {
 		return false
 	}
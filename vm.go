@@ -0,0 +1,475 @@
+package mathxf
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// machine runs a bytecode for a single run, Program.run takes it from the pool of the bytecode.
+type machine struct {
+	bc      *bytecode
+	ctx     *EvaluatorContext
+	consts  []*Value
+	slots   []*ValElement // resolved roots of opGlobal, nil until first access
+	values  []slotValue   // values of the slots read without a path
+	stack   []*Value
+	targets []*ValElement // targets of opStore
+	loops   []loopState
+}
+
+// slotValue is the value of a slot, valid until the next assignment of the run.
+type slotValue struct {
+	val    *Value
+	writes int
+}
+
+// loopState is a running for loop. Its loop variables are declared again for every item,
+// in a scope of their own like the tree does, the scope and the elements are reused unless a func may capture them.
+type loopState struct {
+	node       *tagForNode
+	items      reflect.Value
+	keys       []reflect.Value // sorted keys of a map
+	chars      []rune          // characters of a string
+	idx, n     int
+	isMap      bool
+	outer      *scope
+	scope      *scope
+	key, value *ValElement
+	values     [2]slotValue // values of key and value read without a path
+	depth      int
+}
+
+// runBytecode is runDocument for a compiled program.
+func runBytecode(bc *bytecode, ctx *EvaluatorContext, env map[string]any) (map[string]ValMap, error) {
+	m, _ := bc.pool.Get().(*machine)
+	if m == nil {
+		m = &machine{
+			bc:     bc,
+			slots:  make([]*ValElement, len(bc.slotNames)),
+			values: make([]slotValue, len(bc.slotNames)),
+			stack:  make([]*Value, 0, 16),
+		}
+	}
+	m.ctx, m.consts = ctx, bc.consts[boolIndex(ctx.IsHighPrecision)]
+	ctx.vm = m
+	res, err := runDocument(m, ctx, env)
+	m.release()
+	return res, err
+}
+
+// release clears the machine of a finished run and returns it to the pool.
+func (m *machine) release() {
+	m.ctx.vm = nil
+	m.ctx = nil
+	for i := range m.slots {
+		m.slots[i], m.values[i] = nil, slotValue{}
+	}
+	for i := range m.stack[:cap(m.stack)] {
+		m.stack[:cap(m.stack)][i] = nil
+	}
+	m.stack, m.targets, m.loops = m.stack[:0], m.targets[:0], m.loops[:0]
+	m.bc.pool.Put(m)
+}
+
+// Execute runs the whole program, the machine is the root node of runDocument.
+func (m *machine) Execute(ctx *EvaluatorContext) error {
+	_, err := m.exec(0)
+	return ParseErr(err)
+}
+
+func (m *machine) push(v *Value) {
+	m.stack = append(m.stack, v)
+}
+
+func (m *machine) pop() *Value {
+	v := m.stack[len(m.stack)-1]
+	m.stack = m.stack[:len(m.stack)-1]
+	return v
+}
+
+func (m *machine) token(i int32) *Token {
+	if i < 0 {
+		return nil
+	}
+	return m.bc.tokens[i]
+}
+
+// exec runs the chunk at pc up to its opReturn and returns the value left on the stack, if any.
+// On an error the stack and the nesting depth are restored, e.g. for break or continue in a block.
+func (m *machine) exec(pc int) (*Value, error) {
+	base, depth := len(m.stack), m.ctx.depth
+	targets, loops := len(m.targets), len(m.loops)
+	res, err := m.loop(pc, base)
+	if err != nil {
+		m.stack = m.stack[:base]
+		m.targets = m.targets[:targets]
+		for len(m.loops) > loops {
+			m.endLoop()
+		}
+		m.ctx.depth = depth
+	}
+	return res, err
+}
+
+func (m *machine) loop(pc, base int) (*Value, error) {
+	ctx, bc := m.ctx, m.bc
+	for {
+		in := bc.code[pc]
+		pc++
+		if err := ctx.step(); err != nil {
+			return nil, err.SetToken(m.token(in.t))
+		}
+		switch in.op {
+		case opConst:
+			m.push(m.consts[in.a])
+		case opGlobal:
+			v := bc.vars[in.a]
+			ele := m.slots[in.b]
+			if ele == nil {
+				var ok bool
+				if ele, ok = ctx.Lookup(v.parts[0].name); !ok {
+					return nil, VariableInvalidErr.SetMessagef(v.parts[0].name).SetToken(v.locationToken)
+				}
+				m.slots[in.b] = ele
+			}
+			plain := len(v.parts) == 1 && !v.parts[0].isFunctionCall
+			if cached := &m.values[in.b]; plain && cached.val != nil && cached.writes == ctx.writes {
+				m.push(cached.val)
+				continue
+			}
+			val, err := v.resolve(ctx, ele)
+			if err != nil {
+				return nil, err
+			}
+			if plain {
+				m.values[in.b] = slotValue{val: val, writes: ctx.writes}
+			}
+			m.push(val)
+		case opLocal:
+			v := bc.vars[in.a]
+			ele, ok := ctx.Lookup(v.parts[0].name)
+			if !ok {
+				return nil, VariableInvalidErr.SetMessagef(v.parts[0].name).SetToken(v.locationToken)
+			}
+			val, err := v.resolve(ctx, ele)
+			if err != nil {
+				return nil, err
+			}
+			m.push(val)
+		case opLoopVar:
+			v, l := bc.vars[in.a], &m.loops[len(m.loops)-1-int(in.b>>1)]
+			ele, cached := l.key, &l.values[in.b&1]
+			if in.b&1 == 1 {
+				ele = l.value
+			}
+			plain := len(v.parts) == 1 && !v.parts[0].isFunctionCall
+			if plain && cached.val != nil && cached.writes == ctx.writes {
+				m.push(cached.val)
+				continue
+			}
+			val, err := v.resolve(ctx, ele)
+			if err != nil {
+				return nil, err
+			}
+			if plain {
+				*cached = slotValue{val: val, writes: ctx.writes}
+			}
+			m.push(val)
+		case opArray:
+			n := int(in.a)
+			items := make([]*Value, n)
+			copy(items, m.stack[len(m.stack)-n:])
+			m.stack = m.stack[:len(m.stack)-n]
+			m.push(&Value{Val: reflect.ValueOf(items)})
+		case opDup:
+			m.push(m.stack[len(m.stack)-1])
+		case opBinary:
+			y := m.pop()
+			x := m.pop()
+			op := bc.tokens[in.a]
+			var res *Value
+			var err error
+			switch op.typ {
+			case TokenAdd, TokenSub:
+				res, err = evalSimple(ctx, op, x, y)
+			case TokenMul, TokenDiv, TokenMod:
+				res, err = evalTerm(ctx, op, x, y, m.token(in.b))
+			case TokenPow:
+				res, err = evalPower(ctx, op, x, y)
+			default:
+				res, err = evalRelational(ctx, op, x, y)
+			}
+			if err != nil {
+				return nil, err
+			}
+			m.push(res)
+		case opUnary:
+			res, err := evalUnary(ctx, bc.tokens[in.a], m.pop(), m.token(in.b))
+			if err != nil {
+				return nil, err
+			}
+			m.push(res)
+		case opIdentity:
+			res, err := bc.idents[in.a].eval(ctx, m.pop())
+			if err != nil {
+				return nil, err
+			}
+			m.push(res)
+		case opJump:
+			pc = int(in.a)
+		case opJumpFalse:
+			v := m.pop()
+			if ctx.IsStrict {
+				if err := strictBool(m.token(in.t), v, bc.names[in.b]); err != nil {
+					return nil, err
+				}
+			}
+			if !v.IsTrue() {
+				pc = int(in.a)
+			}
+		case opAnd, opOr:
+			op := bc.tokens[in.b]
+			v := m.stack[len(m.stack)-1]
+			if ctx.IsStrict {
+				if err := strictBool(op, v, "operand of "+op.spelling()); err != nil {
+					return nil, err
+				}
+			}
+			if isTrue := v.IsTrue(); isTrue == (in.op == opOr) {
+				m.stack[len(m.stack)-1] = AsValue(isTrue)
+				pc = int(in.a)
+			} else {
+				m.pop()
+			}
+		case opBool:
+			op := bc.tokens[in.a]
+			v := m.stack[len(m.stack)-1]
+			if ctx.IsStrict {
+				if err := strictBool(op, v, "operand of "+op.spelling()); err != nil {
+					return nil, err
+				}
+			}
+			m.stack[len(m.stack)-1] = AsValue(v.IsTrue())
+		case opBuiltin:
+			call := bc.calls[in.a]
+			ele := m.slots[call.slot]
+			if ele == nil {
+				ele, _ = ctx.Lookup(bc.slotNames[call.slot])
+				m.slots[call.slot] = ele
+			}
+			if ele != call.ele {
+				pc = int(in.b)
+			}
+		case opCall:
+			call := bc.calls[in.a]
+			args := m.stack[len(m.stack)-call.nargs:]
+			res, err := call.fn(ctx, args)
+			m.stack = m.stack[:len(m.stack)-call.nargs]
+			if err := ctx.step(); err != nil {
+				return nil, err.SetToken(call.varPos)
+			}
+			if err != nil {
+				code := Cause(err)
+				pos := call.varPos
+				if _, col := code.Position(); col > 0 && col < len(call.argPos) {
+					pos = call.argPos[col]
+				}
+				return nil, code.SetToken(pos)
+			}
+			if res == nil {
+				res = AsValue(nil)
+			}
+			m.push(res)
+		case opSetRes:
+			if v := m.pop(); in.b == 0 || !v.IsNil() {
+				ctx.ResultMap[ctx.defResultKey][bc.names[in.a]] = v
+			}
+		case opAssign:
+			assign := bc.assigns[in.a]
+			if in.b == 1 {
+				if vals, ok := m.results(assign.variable); ok {
+					val, err := m.exec(assign.start)
+					if err != nil {
+						return nil, err
+					}
+					vals[assign.variable.parts[1].name] = val
+					continue
+				}
+			}
+			if err := assign.variable.SetPartValue(ctx, chunkEvaluator{m, assign}); err != nil {
+				return nil, err
+			}
+		case opTarget:
+			v := bc.vars[in.a]
+			ele, ok := ctx.Lookup(v.parts[0].name)
+			if !ok {
+				return nil, AssignObjectErr.SetMessagef(v.parts[0].name).SetToken(v.locationToken)
+			}
+			if ele.ValType == ConstVal {
+				return nil, VariableCannotSetValueErr.SetMessagef(v.parts[0].name).SetToken(v.locationToken)
+			}
+			m.targets = append(m.targets, ele)
+		case opStore:
+			ele := m.targets[len(m.targets)-1]
+			m.targets = m.targets[:len(m.targets)-1]
+			if ele.ValType == PublicVal {
+				ele.IsSet = true
+			}
+			ele.Val = m.pop().Interface()
+			ctx.writes++
+		case opIter:
+			if err := m.startLoop(bc.loops[in.a], m.pop()); err != nil {
+				return nil, err
+			}
+		case opNext:
+			if !m.next() {
+				pc = int(in.a)
+			}
+		case opEndLoop:
+			m.endLoop()
+		case opDefine:
+			set := bc.sets[in.a]
+			val := m.pop()
+			_, isRes := ctx.ResultMap[set.name]
+			if ctx.declared(set.name) || isRes {
+				pos := set.expression.GetPositionToken()
+				return nil, VariableAlreadyExistsErr.SetMessagef(set.name).SetToken(pos)
+			}
+			ctx.Define(set.name, NewPrivateValElement(val))
+		case opEnter:
+			if err := ctx.enter(bc.tokens[in.a]); err != nil {
+				return nil, err
+			}
+		case opLeave:
+			ctx.leave()
+		case opExec:
+			node := bc.nodes[in.a]
+			if in.b == 1 {
+				val, err := node.(evalNode).Evaluate(ctx)
+				if err != nil {
+					return nil, err
+				}
+				m.push(val)
+				continue
+			}
+			if err := node.Execute(ctx); err != nil {
+				return nil, err
+			}
+		case opReturn:
+			if len(m.stack) > base {
+				return m.pop(), nil
+			}
+			return nil, nil
+		default:
+			return nil, ServerErr.WithDetails(fmt.Sprintf("unknown opcode %d", in.op)).SetToken(m.token(in.t))
+		}
+	}
+}
+
+// chunkEvaluator evaluates the value of an assignment, SetPartValue calls it after resolving the target.
+type chunkEvaluator struct {
+	m      *machine
+	assign *assignChunk
+}
+
+func (c chunkEvaluator) GetPositionToken() *Token {
+	return c.assign.pos
+}
+
+func (c chunkEvaluator) Evaluate(ctx *EvaluatorContext) (*Value, error) {
+	return c.m.exec(c.assign.start)
+}
+
+// startLoop starts iterating items like tagForNode.Execute.
+func (m *machine) startLoop(node *tagForNode, items *Value) error {
+	ctx := m.ctx
+	l := loopState{node: node, items: items.getResolvedValue(), outer: ctx.scope, depth: ctx.depth}
+	switch l.items.Kind() {
+	case reflect.Map:
+		l.isMap = true
+		keys := sortedKeys(l.items.MapKeys())
+		sort.Sort(keys)
+		l.keys, l.n = keys, len(keys)
+	case reflect.Array, reflect.Slice:
+		l.n = l.items.Len()
+	case reflect.String:
+		l.chars = []rune(l.items.String())
+		l.n = len(l.chars)
+	default:
+		pos := node.iterable.GetPositionToken()
+		return VariableNotIterableErr.SetMessagef(pos.val).SetToken(pos)
+	}
+	if !m.bc.fresh {
+		l.scope = &scope{parent: l.outer, vals: make(ValElementMap, 2)}
+		l.key = NewPrivateValElement(nil)
+		if node.valName != "" {
+			l.value = NewPrivateValElement(nil)
+		}
+	}
+	m.loops = append(m.loops, l)
+	return nil
+}
+
+// next declares the loop variables of the next item of the innermost loop, false after the last item.
+func (m *machine) next() bool {
+	ctx, l := m.ctx, &m.loops[len(m.loops)-1]
+	// continue may leave blocks of the body
+	ctx.depth = l.depth
+	if l.idx >= l.n {
+		return false
+	}
+	var key, value any
+	switch {
+	case l.isMap:
+		key, value = loopValue(l.keys[l.idx]), loopValue(l.items.MapIndex(l.keys[l.idx]))
+	case l.chars != nil:
+		key = string(l.chars[l.idx])
+	default:
+		key = loopValue(l.items.Index(l.idx))
+	}
+	if l.node.valName != "" && !l.isMap {
+		key, value = l.idx, key
+	}
+	l.idx++
+	l.values = [2]slotValue{}
+	if m.bc.fresh {
+		ctx.scope = &scope{parent: l.outer, vals: make(ValElementMap)}
+		l.key = NewPrivateValElement(key)
+		ctx.Define(l.node.keyName, l.key)
+		if l.node.valName != "" {
+			l.value = NewPrivateValElement(value)
+			ctx.Define(l.node.valName, l.value)
+		}
+		return true
+	}
+	for name := range l.scope.vals {
+		delete(l.scope.vals, name)
+	}
+	ctx.scope = l.scope
+	l.key.Val = key
+	ctx.Define(l.node.keyName, l.key)
+	if l.value != nil {
+		l.value.Val = value
+		ctx.Define(l.node.valName, l.value)
+	}
+	return true
+}
+
+// endLoop leaves the innermost loop, also after break.
+func (m *machine) endLoop() {
+	l := m.loops[len(m.loops)-1]
+	m.loops = m.loops[:len(m.loops)-1]
+	m.ctx.scope = l.outer
+	m.ctx.depth = l.depth
+}
+
+// results returns the results written by an assignment to v, a result like res.x, unless a variable shadows its key.
+func (m *machine) results(v *variableResolver) (ValMap, bool) {
+	key := v.parts[0].name
+	if _, ok := m.ctx.Lookup(key); ok {
+		return nil, false
+	}
+	vals, ok := m.ctx.ResultMap[key]
+	return vals, ok
+}